@@ -0,0 +1,283 @@
+package merkletree_proof
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+)
+
+// ErrCircuitOpen is returned by CircuitBreaker without calling the backend
+// while the circuit is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerState is a state of CircuitBreaker.
+type BreakerState byte
+
+const (
+	// BreakerClosed passes all calls to the backend.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails all calls with ErrCircuitOpen.
+	BreakerOpen
+	// BreakerHalfOpen passes a limited number of probe calls to the
+	// backend to check if it recovered.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+var _ ReverseHashCli = (*CircuitBreaker)(nil)
+
+// CircuitBreaker is a NodeReader that stops calling the backend after it
+// fails several times in a row. While the circuit is open, calls fail at once
+// with ErrCircuitOpen, so callers can fall back to another source. After the
+// open timeout the breaker lets probe calls through and closes the circuit if
+// they all succeed, or opens it again on the first failure.
+//
+// Missing nodes and canceled contexts are not failures. A canceled call
+// doesn't count as a success either: it neither resets the failure count nor
+// counts as a passed probe. If the backend is a NodeWriter, SaveNodes calls
+// go through the breaker too.
+type CircuitBreaker struct {
+	backend          NodeReader
+	failureThreshold int
+	openTimeout      time.Duration
+	probes           int
+	isFailure        func(err error) bool
+	onStateChange    func(from, to BreakerState)
+	now              func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	gen      uint64 // incremented on every state change
+	failures int    // consecutive failures while closed
+	openedAt time.Time
+	inflight int // probes in flight while half-open
+	passed   int // successful probes while half-open
+}
+
+type CircuitBreakerOption func(b *CircuitBreaker) error
+
+// WithFailureThreshold sets the number of consecutive failures that open the
+// circuit. The default is 5.
+func WithFailureThreshold(n int) CircuitBreakerOption {
+	return func(b *CircuitBreaker) error {
+		if n <= 0 {
+			return errors.New("failure threshold must be positive")
+		}
+		b.failureThreshold = n
+		return nil
+	}
+}
+
+// WithOpenTimeout sets how long the circuit stays open before probe calls
+// are let through. The default is 30 seconds.
+func WithOpenTimeout(timeout time.Duration) CircuitBreakerOption {
+	return func(b *CircuitBreaker) error {
+		if timeout <= 0 {
+			return errors.New("open timeout must be positive")
+		}
+		b.openTimeout = timeout
+		return nil
+	}
+}
+
+// WithHalfOpenProbes sets the number of concurrent probe calls allowed while
+// half-open. The circuit closes when that many probes succeed. The default
+// is 1.
+func WithHalfOpenProbes(n int) CircuitBreakerOption {
+	return func(b *CircuitBreaker) error {
+		if n <= 0 {
+			return errors.New("number of probes must be positive")
+		}
+		b.probes = n
+		return nil
+	}
+}
+
+// WithFailurePredicate overrides which errors count as backend failures.
+func WithFailurePredicate(isFailure func(err error) bool) CircuitBreakerOption {
+	return func(b *CircuitBreaker) error {
+		if isFailure == nil {
+			return errors.New("failure predicate is nil")
+		}
+		b.isFailure = isFailure
+		return nil
+	}
+}
+
+// WithStateChangeHandler sets a function called on every state change. It
+// is called with the breaker lock held and must not call the breaker.
+func WithStateChangeHandler(fn func(from, to BreakerState)) CircuitBreakerOption {
+	return func(b *CircuitBreaker) error {
+		b.onStateChange = fn
+		return nil
+	}
+}
+
+func NewCircuitBreaker(backend NodeReader,
+	opts ...CircuitBreakerOption) (*CircuitBreaker, error) {
+
+	if backend == nil {
+		return nil, errors.New("backend is nil")
+	}
+
+	b := &CircuitBreaker{
+		backend:          backend,
+		failureThreshold: 5,
+		openTimeout:      30 * time.Second,
+		probes:           1,
+		isFailure:        isBreakerFailure,
+		now:              time.Now,
+	}
+	for _, o := range opts {
+		err := o(b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func isBreakerFailure(err error) bool {
+	return err != nil && !errors.Is(err, abicsr.ErrNodeNotFound) &&
+		!errors.Is(err, context.Canceled)
+}
+
+// State returns the current state of the circuit.
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.checkOpenTimeout()
+	return b.state
+}
+
+func (b *CircuitBreaker) GetNode(ctx context.Context,
+	hash *merkletree.Hash) (Node, error) {
+
+	gen, err := b.before()
+	if err != nil {
+		return Node{}, err
+	}
+	n, err := b.backend.GetNode(ctx, hash)
+	b.after(gen, err)
+	return n, err
+}
+
+// SaveNodes saves nodes to the backend if it is a NodeWriter.
+func (b *CircuitBreaker) SaveNodes(ctx context.Context, nodes []Node) error {
+	w, ok := b.backend.(NodeWriter)
+	if !ok {
+		return errors.New("backend does not support saving nodes")
+	}
+
+	gen, err := b.before()
+	if err != nil {
+		return err
+	}
+	err = w.SaveNodes(ctx, nodes)
+	b.after(gen, err)
+	return err
+}
+
+func (b *CircuitBreaker) GenerateProof(ctx context.Context,
+	treeRoot *merkletree.Hash,
+	key *merkletree.Hash) (*merkletree.Proof, error) {
+
+	return GenerateProof(ctx, b, treeRoot, key)
+}
+
+// before checks whether a call may go to the backend and returns the
+// generation of the state the call is made in.
+func (b *CircuitBreaker) before() (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.checkOpenTimeout()
+	switch b.state {
+	case BreakerOpen:
+		return 0, ErrCircuitOpen
+	case BreakerHalfOpen:
+		if b.inflight >= b.probes {
+			return 0, ErrCircuitOpen
+		}
+		b.inflight++
+	}
+	return b.gen, nil
+}
+
+// after records the result of a call. Results of calls made before the last
+// state change are ignored.
+func (b *CircuitBreaker) after(gen uint64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if gen != b.gen {
+		return
+	}
+	failed := b.isFailure(err)
+	// only an answer of the backend shows that it works
+	answered := err == nil || errors.Is(err, abicsr.ErrNodeNotFound)
+
+	switch b.state {
+	case BreakerClosed:
+		if !failed {
+			if answered {
+				b.failures = 0
+			}
+			return
+		}
+		b.failures++
+		if b.failures >= b.failureThreshold {
+			b.setState(BreakerOpen)
+		}
+	case BreakerHalfOpen:
+		b.inflight--
+		if failed {
+			b.setState(BreakerOpen)
+			return
+		}
+		if !answered {
+			return
+		}
+		b.passed++
+		if b.passed >= b.probes {
+			b.setState(BreakerClosed)
+		}
+	}
+}
+
+func (b *CircuitBreaker) checkOpenTimeout() {
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.openTimeout {
+		b.setState(BreakerHalfOpen)
+	}
+}
+
+func (b *CircuitBreaker) setState(state BreakerState) {
+	from := b.state
+	b.state = state
+	b.gen++
+	b.failures = 0
+	b.inflight = 0
+	b.passed = 0
+	if state == BreakerOpen {
+		b.openedAt = b.now()
+	}
+	if b.onStateChange != nil {
+		b.onStateChange(from, state)
+	}
+}
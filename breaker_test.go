@@ -0,0 +1,208 @@
+package merkletree_proof
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+	"github.com/iden3/merkletree-proof/internal/testtree"
+	"github.com/stretchr/testify/require"
+)
+
+// switchableReader fails with err if it is set and returns not found
+// otherwise.
+type switchableReader struct {
+	mu    sync.Mutex
+	err   error
+	calls int
+}
+
+func (r *switchableReader) GetNode(_ context.Context,
+	_ *merkletree.Hash) (Node, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls++
+	if r.err != nil {
+		return Node{}, r.err
+	}
+	return Node{}, abicsr.ErrNodeNotFound
+}
+
+func (r *switchableReader) setErr(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.err = err
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	errDown := errors.New("service unavailable")
+	backend := &switchableReader{}
+	var transitions []string
+	b, err := NewCircuitBreaker(backend,
+		WithFailureThreshold(3),
+		WithOpenTimeout(time.Minute),
+		WithHalfOpenProbes(2),
+		WithStateChangeHandler(func(from, to BreakerState) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		}))
+	require.NoError(t, err)
+	now := time.Now()
+	b.now = func() time.Time { return now }
+	hash := testtree.Hash(t, 1)
+
+	// missing nodes are not failures
+	for i := 0; i < 5; i++ {
+		_, err = b.GetNode(ctx, hash)
+		require.ErrorIs(t, err, abicsr.ErrNodeNotFound)
+	}
+	require.Equal(t, BreakerClosed, b.State())
+
+	// a success resets the failure count
+	backend.setErr(errDown)
+	for i := 0; i < 2; i++ {
+		_, err = b.GetNode(ctx, hash)
+		require.ErrorIs(t, err, errDown)
+	}
+	backend.setErr(nil)
+	_, _ = b.GetNode(ctx, hash)
+	backend.setErr(errDown)
+	for i := 0; i < 3; i++ {
+		_, err = b.GetNode(ctx, hash)
+		require.ErrorIs(t, err, errDown)
+	}
+	require.Equal(t, BreakerOpen, b.State())
+
+	// open circuit fails fast
+	calls := backend.calls
+	_, err = b.GetNode(ctx, hash)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	_, err = b.GenerateProof(ctx, hash, hash)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.Equal(t, calls, backend.calls)
+
+	// a failed probe opens the circuit again
+	now = now.Add(time.Minute)
+	require.Equal(t, BreakerHalfOpen, b.State())
+	_, err = b.GetNode(ctx, hash)
+	require.ErrorIs(t, err, errDown)
+	require.Equal(t, BreakerOpen, b.State())
+
+	// two successful probes close it
+	now = now.Add(time.Minute)
+	backend.setErr(nil)
+	_, err = b.GetNode(ctx, hash)
+	require.ErrorIs(t, err, abicsr.ErrNodeNotFound)
+	require.Equal(t, BreakerHalfOpen, b.State())
+	_, err = b.GetNode(ctx, hash)
+	require.ErrorIs(t, err, abicsr.ErrNodeNotFound)
+	require.Equal(t, BreakerClosed, b.State())
+
+	require.Equal(t, []string{
+		"closed->open",
+		"open->half-open",
+		"half-open->open",
+		"open->half-open",
+		"half-open->closed",
+	}, transitions)
+}
+
+// blockingReader blocks GetNode until release is closed.
+type blockingReader struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (r *blockingReader) GetNode(_ context.Context,
+	_ *merkletree.Hash) (Node, error) {
+
+	r.started <- struct{}{}
+	<-r.release
+	return Node{}, abicsr.ErrNodeNotFound
+}
+
+func TestCircuitBreaker_ProbeLimit(t *testing.T) {
+	ctx := context.Background()
+	backend := &blockingReader{started: make(chan struct{}),
+		release: make(chan struct{})}
+	b, err := NewCircuitBreaker(backend, WithOpenTimeout(time.Millisecond))
+	require.NoError(t, err)
+	b.mu.Lock()
+	b.setState(BreakerOpen)
+	b.mu.Unlock()
+	time.Sleep(2 * time.Millisecond)
+
+	hash := testtree.Hash(t, 1)
+	done := make(chan error)
+	go func() {
+		_, err := b.GetNode(ctx, hash)
+		done <- err
+	}()
+	<-backend.started
+
+	// only one probe at a time
+	_, err = b.GetNode(ctx, hash)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+
+	close(backend.release)
+	require.ErrorIs(t, <-done, abicsr.ErrNodeNotFound)
+	require.Equal(t, BreakerClosed, b.State())
+}
+
+func TestCircuitBreaker_CanceledProbes(t *testing.T) {
+	ctx := context.Background()
+	backend := &switchableReader{}
+	b, err := NewCircuitBreaker(backend,
+		WithFailureThreshold(2),
+		WithOpenTimeout(time.Minute),
+		WithHalfOpenProbes(2))
+	require.NoError(t, err)
+	now := time.Now()
+	b.now = func() time.Time { return now }
+	hash := testtree.Hash(t, 1)
+
+	// canceled calls don't reset the failure count
+	backend.setErr(errors.New("service unavailable"))
+	_, _ = b.GetNode(ctx, hash)
+	backend.setErr(context.Canceled)
+	_, _ = b.GetNode(ctx, hash)
+	backend.setErr(errors.New("service unavailable"))
+	_, _ = b.GetNode(ctx, hash)
+	require.Equal(t, BreakerOpen, b.State())
+	now = now.Add(time.Minute)
+	require.Equal(t, BreakerHalfOpen, b.State())
+
+	// canceled probes don't close the circuit
+	backend.setErr(context.Canceled)
+	for i := 0; i < 5; i++ {
+		_, err = b.GetNode(ctx, hash)
+		require.ErrorIs(t, err, context.Canceled)
+	}
+	require.Equal(t, BreakerHalfOpen, b.State())
+
+	backend.setErr(nil)
+	for i := 0; i < 2; i++ {
+		_, err = b.GetNode(ctx, hash)
+		require.ErrorIs(t, err, abicsr.ErrNodeNotFound)
+	}
+	require.Equal(t, BreakerClosed, b.State())
+}
+
+func TestCircuitBreaker_SaveNodes(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewCircuitBreaker(&switchableReader{})
+	require.NoError(t, err)
+	require.EqualError(t, b.SaveNodes(ctx, nil),
+		"backend does not support saving nodes")
+
+	backend := &testBackend{err: errors.New("write failed")}
+	b, err = NewCircuitBreaker(backend, WithFailureThreshold(1))
+	require.NoError(t, err)
+	require.Error(t, b.SaveNodes(ctx, nil))
+	require.ErrorIs(t, b.SaveNodes(ctx, nil), ErrCircuitOpen)
+}
@@ -0,0 +1,243 @@
+package merkletree_proof
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+)
+
+// IssueKind is the kind of problem found by CheckTree.
+type IssueKind byte
+
+const (
+	IssueUnknown IssueKind = iota
+	// IssueMissingNode means the node is not found by the reader.
+	IssueMissingNode
+	// IssueInvalidNode means the node hash does not match its children or
+	// the node is of unknown type.
+	IssueInvalidNode
+	// IssueUnexpectedNodeType means the node is valid, but can't be a part
+	// of a tree at this position, like a state node inside a tree.
+	IssueUnexpectedNodeType
+	// IssueEmptyMiddleNode means both children of a middle node are empty.
+	IssueEmptyMiddleNode
+	// IssueMisplacedLeaf means the leaf key bits do not match its path.
+	IssueMisplacedLeaf
+	// IssueTooDeep means the tree is deeper than the key length in bits.
+	IssueTooDeep
+)
+
+func (k IssueKind) String() string {
+	switch k {
+	case IssueMissingNode:
+		return "missing node"
+	case IssueInvalidNode:
+		return "invalid node"
+	case IssueUnexpectedNodeType:
+		return "unexpected node type"
+	case IssueEmptyMiddleNode:
+		return "middle node with two empty children"
+	case IssueMisplacedLeaf:
+		return "misplaced leaf"
+	case IssueTooDeep:
+		return "tree is too deep"
+	default:
+		return "unknown issue"
+	}
+}
+
+// TreeIssue is a problem with a node of a tree.
+type TreeIssue struct {
+	Kind IssueKind
+	// Root of the tree the node belongs to.
+	Root *merkletree.Hash
+	Hash *merkletree.Hash
+	// Path from the root to the node. Character i is the direction taken
+	// at depth i: '0' for the left child and '1' for the right one.
+	Path    string
+	Details string
+}
+
+func (i TreeIssue) String() string {
+	s := fmt.Sprintf("%v: %v at path %q of tree %v", i.Kind, i.Hash.Hex(),
+		i.Path, i.Root.Hex())
+	if i.Details != "" {
+		s += ": " + i.Details
+	}
+	return s
+}
+
+// CheckReport is the result of CheckTree and CheckState.
+type CheckReport struct {
+	MiddleNodes int
+	LeafNodes   int
+	Issues      []TreeIssue
+}
+
+// OK returns true if no issues were found.
+func (r CheckReport) OK() bool {
+	return len(r.Issues) == 0
+}
+
+// CheckTree walks every node reachable from the tree root and checks that
+// all nodes are present, their hashes match their children, no middle node
+// has two empty children and every leaf is placed on the path matching its
+// key bits. Problems with nodes are returned in the report; the error is
+// returned only if the reader fails for another reason than a missing node
+// or the context is done, or the root is nil.
+func CheckTree(ctx context.Context, cli NodeReader,
+	treeRoot *merkletree.Hash) (CheckReport, error) {
+
+	if treeRoot == nil {
+		return CheckReport{}, errors.New("tree root is nil")
+	}
+	var report CheckReport
+	err := checkTree(ctx, cli, treeRoot, &report)
+	return report, err
+}
+
+// CheckState checks the state node and the claims, revocation and roots
+// trees it references.
+func CheckState(ctx context.Context, cli NodeReader,
+	state *merkletree.Hash) (CheckReport, error) {
+
+	if state == nil {
+		return CheckReport{}, errors.New("state is nil")
+	}
+	var report CheckReport
+	n, ok, err := checkedNode(ctx, cli, state, state, "", &report)
+	if err != nil || !ok {
+		return report, err
+	}
+	if n.Type() != NodeTypeState {
+		report.Issues = append(report.Issues, TreeIssue{
+			Kind:    IssueUnexpectedNodeType,
+			Root:    state,
+			Hash:    state,
+			Details: "expected state node",
+		})
+		return report, nil
+	}
+
+	for _, root := range n.Children {
+		err = checkTree(ctx, cli, root, &report)
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+func checkTree(ctx context.Context, cli NodeReader, root *merkletree.Hash,
+	report *CheckReport) error {
+
+	c := treeChecker{cli: cli, root: root, report: report}
+	return c.check(ctx, root, nil)
+}
+
+type treeChecker struct {
+	cli    NodeReader
+	root   *merkletree.Hash
+	report *CheckReport
+}
+
+func (c *treeChecker) check(ctx context.Context, hash *merkletree.Hash,
+	path []byte) error {
+
+	if *hash == merkletree.HashZero {
+		return nil
+	}
+
+	if len(path) >= len(hash)*8 {
+		c.issue(IssueTooDeep, hash, path, "")
+		return nil
+	}
+
+	n, ok, err := checkedNode(ctx, c.cli, c.root, hash, string(path),
+		c.report)
+	if err != nil || !ok {
+		return err
+	}
+
+	switch n.Type() {
+	case NodeTypeMiddle:
+		c.report.MiddleNodes++
+		if *n.Children[0] == merkletree.HashZero &&
+			*n.Children[1] == merkletree.HashZero {
+
+			c.issue(IssueEmptyMiddleNode, hash, path, "")
+			return nil
+		}
+		err = c.check(ctx, n.Children[0], append(path, '0'))
+		if err != nil {
+			return err
+		}
+		return c.check(ctx, n.Children[1], append(path, '1'))
+	case NodeTypeLeaf:
+		c.report.LeafNodes++
+		key := n.Children[0]
+		for i, dir := range path {
+			if merkletree.TestBit(key[:], uint(i)) != (dir == '1') {
+				c.issue(IssueMisplacedLeaf, hash, path, fmt.Sprintf(
+					"bit %v of key %v does not match the path", i,
+					key.Hex()))
+				break
+			}
+		}
+		return nil
+	default:
+		c.issue(IssueUnexpectedNodeType, hash, path,
+			"state node inside a tree")
+		return nil
+	}
+}
+
+func (c *treeChecker) issue(kind IssueKind, hash *merkletree.Hash,
+	path []byte, details string) {
+
+	c.report.Issues = append(c.report.Issues, TreeIssue{
+		Kind:    kind,
+		Root:    c.root,
+		Hash:    hash,
+		Path:    string(path),
+		Details: details,
+	})
+}
+
+// checkedNode fetches the node and adds an issue to the report if the node
+// is missing or invalid. ok is false if the node can't be used further.
+func checkedNode(ctx context.Context, cli NodeReader, root,
+	hash *merkletree.Hash, path string,
+	report *CheckReport) (n Node, ok bool, err error) {
+
+	if err = ctx.Err(); err != nil {
+		return Node{}, false, err
+	}
+
+	n, err = cli.GetNode(ctx, hash)
+	if errors.Is(err, abicsr.ErrNodeNotFound) {
+		report.Issues = append(report.Issues, TreeIssue{
+			Kind: IssueMissingNode, Root: root, Hash: hash, Path: path})
+		return Node{}, false, nil
+	} else if err != nil {
+		return Node{}, false, err
+	}
+
+	// validate children against the requested hash, whatever the reader
+	// put into the node
+	n.Hash = hash
+	if err = n.Validate(); err != nil {
+		report.Issues = append(report.Issues, TreeIssue{
+			Kind:    IssueInvalidNode,
+			Root:    root,
+			Hash:    hash,
+			Path:    path,
+			Details: err.Error(),
+		})
+		return Node{}, false, nil
+	}
+	return n, true, nil
+}
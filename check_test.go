@@ -0,0 +1,121 @@
+package merkletree_proof
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/iden3/go-merkletree-sql/v2"
+	"github.com/iden3/merkletree-proof/internal/testtree"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckTree(t *testing.T) {
+	ctx := context.Background()
+	mt := testtree.Build(t, 1, 5, 7, 100, 12345)
+	nodes, err := NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+
+	cli := &testBackend{}
+	require.NoError(t, cli.SaveNodes(ctx, nodes))
+
+	report, err := CheckTree(ctx, cli, mt.Root())
+	require.NoError(t, err)
+	require.True(t, report.OK(), report.Issues)
+	require.Equal(t, 5, report.LeafNodes)
+	require.Equal(t, len(nodes)-5, report.MiddleNodes)
+
+	report, err = CheckTree(ctx, cli, &merkletree.HashZero)
+	require.NoError(t, err)
+	require.True(t, report.OK())
+	require.Equal(t, 0, report.LeafNodes)
+
+	_, err = CheckTree(ctx, cli, nil)
+	require.EqualError(t, err, "tree root is nil")
+	_, err = CheckState(ctx, cli, nil)
+	require.EqualError(t, err, "state is nil")
+}
+
+func TestCheckTree_Issues(t *testing.T) {
+	ctx := context.Background()
+	leafKey := testtree.Hash(t, 1) // first bit of key is 1
+	leaf, err := NewNodeFromMerkleTreeNode(
+		merkletree.NewNodeLeaf(leafKey, testtree.Hash(t, 10)))
+	require.NoError(t, err)
+	missing := testtree.Hash(t, 42)
+
+	// the leaf is placed to the left, but the key's first bit is 1
+	misplaced := mustMiddle(t, leaf.Hash, missing)
+	emptyMiddle := mustMiddle(t, &merkletree.HashZero, &merkletree.HashZero)
+	invalid := Node{
+		Hash:     testtree.Hash(t, 43),
+		Children: []*merkletree.Hash{leaf.Hash, leaf.Hash},
+	}
+	root := mustMiddle(t, misplaced.Hash, emptyMiddle.Hash)
+
+	cli := &testBackend{}
+	cli.saved = []Node{leaf, misplaced, emptyMiddle, invalid, root}
+
+	report, err := CheckTree(ctx, cli, root.Hash)
+	require.NoError(t, err)
+	require.Len(t, report.Issues, 3)
+
+	require.Equal(t, IssueMisplacedLeaf, report.Issues[0].Kind)
+	require.Equal(t, "00", report.Issues[0].Path)
+	require.Equal(t, leaf.Hash, report.Issues[0].Hash)
+	require.Equal(t, root.Hash, report.Issues[0].Root)
+
+	require.Equal(t, IssueMissingNode, report.Issues[1].Kind)
+	require.Equal(t, "01", report.Issues[1].Path)
+	require.Equal(t, missing, report.Issues[1].Hash)
+
+	require.Equal(t, IssueEmptyMiddleNode, report.Issues[2].Kind)
+	require.Equal(t, "1", report.Issues[2].Path)
+
+	report, err = CheckTree(ctx, cli, invalid.Hash)
+	require.NoError(t, err)
+	require.Len(t, report.Issues, 1)
+	require.Equal(t, IssueInvalidNode, report.Issues[0].Kind)
+	require.Equal(t, "", report.Issues[0].Path)
+}
+
+func TestCheckState(t *testing.T) {
+	ctx := context.Background()
+	claims := testtree.Build(t, 1, 2, 3)
+	revocations := testtree.Build(t, 5)
+	claimsNodes, err := NodesFromTree(ctx, claims, nil)
+	require.NoError(t, err)
+	revNodes, err := NodesFromTree(ctx, revocations, nil)
+	require.NoError(t, err)
+
+	stateHash, err := merkletree.HashElems(claims.Root().BigInt(),
+		revocations.Root().BigInt(), big.NewInt(0))
+	require.NoError(t, err)
+	state := Node{
+		Hash: stateHash,
+		Children: []*merkletree.Hash{claims.Root(), revocations.Root(),
+			&merkletree.HashZero},
+	}
+
+	cli := &testBackend{}
+	require.NoError(t, cli.SaveNodes(ctx, append(claimsNodes, state)))
+
+	report, err := CheckState(ctx, cli, stateHash)
+	require.NoError(t, err)
+	require.Equal(t, 3, report.LeafNodes)
+	require.Len(t, report.Issues, 1)
+	require.Equal(t, IssueMissingNode, report.Issues[0].Kind)
+	require.Equal(t, revocations.Root(), report.Issues[0].Root)
+
+	require.NoError(t, cli.SaveNodes(ctx, revNodes))
+	report, err = CheckState(ctx, cli, stateHash)
+	require.NoError(t, err)
+	require.True(t, report.OK())
+	require.Equal(t, 4, report.LeafNodes)
+}
+
+func mustMiddle(t testing.TB, l, r *merkletree.Hash) Node {
+	n, err := NewNodeFromMerkleTreeNode(merkletree.NewNodeMiddle(l, r))
+	require.NoError(t, err)
+	return n
+}
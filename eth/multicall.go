@@ -0,0 +1,262 @@
+package eth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	ethabi "github.com/ethereum/go-ethereum/accounts/abi"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/contracts-abi/rhs-storage/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+)
+
+var _ merkletree_proof.NodeChecker = (*ReverseHashCli)(nil)
+
+// DefaultMulticallAddress is the address of the Multicall3 contract, which
+// is deployed at the same address on most EVM chains.
+var DefaultMulticallAddress = ethcommon.HexToAddress(
+	"0xcA11bde05977b3631167028862bE2a173976CA11")
+
+// multicallBatchSize is the max number of getNode calls aggregated in one
+// eth_call.
+const multicallBatchSize = 200
+
+const multicall3ABI = `[{"inputs":[{"components":[{"internalType":"address","name":"target","type":"address"},{"internalType":"bool","name":"allowFailure","type":"bool"},{"internalType":"bytes","name":"callData","type":"bytes"}],"internalType":"struct Multicall3.Call3[]","name":"calls","type":"tuple[]"}],"name":"aggregate3","outputs":[{"components":[{"internalType":"bool","name":"success","type":"bool"},{"internalType":"bytes","name":"returnData","type":"bytes"}],"internalType":"struct Multicall3.Result[]","name":"returnData","type":"tuple[]"}],"stateMutability":"payable","type":"function"}]`
+
+var multicallABI ethabi.ABI
+
+func init() {
+	var err error
+	multicallABI, err = ethabi.JSON(strings.NewReader(multicall3ABI))
+	if err != nil {
+		panic(err)
+	}
+}
+
+// errNoMulticall is returned when there is no contract at the multicall
+// address.
+var errNoMulticall = errors.New("multicall contract is not available")
+
+type multicallCall struct {
+	Target       ethcommon.Address
+	AllowFailure bool
+	CallData     []byte
+}
+
+type multicallResult struct {
+	Success    bool
+	ReturnData []byte
+}
+
+// HasNodes returns a presence bitmap of the nodes: the i-th value reports
+// whether the contract stores the node with hashes[i]. getNode calls are
+// aggregated with the Multicall3 contract, so a batch of nodes is checked
+// with one RPC request. If there is no contract at the multicall address,
+// nodes are fetched one by one.
+func (cli *ReverseHashCli) HasNodes(ctx context.Context,
+	hashes []*merkletree.Hash) ([]bool, error) {
+
+	if cli.observer == nil {
+		return cli.hasNodes(ctx, hashes)
+	}
+
+	start := time.Now()
+	present, err := cli.hasNodes(ctx, hashes)
+	cli.observer.ObserveCall(ctx, merkletree_proof.CallInfo{
+		Backend:  backendName,
+		Op:       merkletree_proof.OpHasNodes,
+		Nodes:    len(hashes),
+		Duration: time.Since(start),
+		Err:      err,
+	})
+	return present, err
+}
+
+func (cli *ReverseHashCli) hasNodes(ctx context.Context,
+	hashes []*merkletree.Hash) ([]bool, error) {
+
+	for _, h := range hashes {
+		if h == nil {
+			return nil, errors.New("hash is nil")
+		}
+	}
+
+	present := make([]bool, len(hashes))
+	multicall := true
+	for start := 0; start < len(hashes); start += multicallBatchSize {
+		end := start + multicallBatchSize
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+
+		if multicall {
+			p, err := cli.hasNodesMulticall(ctx, hashes[start:end])
+			if err == nil {
+				copy(present[start:end], p)
+				continue
+			} else if !errors.Is(err, errNoMulticall) {
+				return nil, err
+			}
+			cli.logger.Debug("multicall contract is not available",
+				merkletree_proof.LogKeyBackend, backendName,
+				"multicall_address", cli.multicallAddress.Hex())
+			multicall = false
+		}
+
+		for i := start; i < end; i++ {
+			_, err := cli.getNode(ctx, hashes[i])
+			if errors.Is(err, abicsr.ErrNodeNotFound) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			present[i] = true
+		}
+	}
+	return present, nil
+}
+
+func (cli *ReverseHashCli) hasNodesMulticall(ctx context.Context,
+	hashes []*merkletree.Hash) ([]bool, error) {
+
+	rhsABI, err := abi.IRHSStorageMetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	input, err := packGetNodeCalls(rhsABI, cli.contractAddress, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := cli.limiter.Acquire(cli.ctx(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ctx, cancel := cli.ctxWithRPCTimeout(ctx)
+	defer cancel()
+
+	out, err := cli.ethClient.CallContract(ctx, ethereum.CallMsg{
+		To:   &cli.multicallAddress,
+		Data: input,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, errNoMulticall
+	}
+	return unpackGetNodeResults(rhsABI, out, hashes)
+}
+
+// packGetNodeCalls returns the input of an aggregate3 call making a getNode
+// call to the target for each hash.
+func packGetNodeCalls(rhsABI *ethabi.ABI, target ethcommon.Address,
+	hashes []*merkletree.Hash) ([]byte, error) {
+
+	calls := make([]multicallCall, len(hashes))
+	for i, h := range hashes {
+		data, err := rhsABI.Pack("getNode", h.BigInt())
+		if err != nil {
+			return nil, err
+		}
+		calls[i] = multicallCall{
+			Target:       target,
+			AllowFailure: true,
+			CallData:     data,
+		}
+	}
+	return multicallABI.Pack("aggregate3", calls)
+}
+
+// unpackGetNodeResults returns the presence bitmap of the nodes from the
+// output of an aggregate3 call made with packGetNodeCalls.
+func unpackGetNodeResults(rhsABI *ethabi.ABI, out []byte,
+	hashes []*merkletree.Hash) ([]bool, error) {
+
+	unpacked, err := multicallABI.Unpack("aggregate3", out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unpack multicall result: %w", err)
+	}
+	results := *ethabi.ConvertType(unpacked[0],
+		new([]multicallResult)).(*[]multicallResult)
+	if len(results) != len(hashes) {
+		return nil, fmt.Errorf("multicall returned %v results, expected %v",
+			len(results), len(hashes))
+	}
+
+	present := make([]bool, len(hashes))
+	for i, r := range results {
+		present[i], err = nodeExists(rhsABI, r)
+		if err != nil {
+			return nil, fmt.Errorf("getNode(%v): %w", hashes[i].Hex(), err)
+		}
+	}
+	return present, nil
+}
+
+// nodeNotFoundSelectors are the selectors of the NodeNotFound custom error
+// of contract versions reverting with custom errors.
+var nodeNotFoundSelectors = [][]byte{
+	crypto.Keccak256([]byte("NodeNotFound(uint256)"))[:4],
+	crypto.Keccak256([]byte("NodeNotFound()"))[:4],
+}
+
+// nodeExists interprets the result of a getNode call. The contract reverts
+// for missing nodes, with a "Node not found" reason or a NodeNotFound custom
+// error. Other reverts, including panics and reverts without data like out
+// of gas, are errors.
+func nodeExists(rhsABI *ethabi.ABI, r multicallResult) (bool, error) {
+	if !r.Success {
+		if isNodeNotFoundRevert(r.ReturnData) {
+			return false, nil
+		}
+		return false, revertError(r.ReturnData)
+	}
+
+	out, err := rhsABI.Unpack("getNode", r.ReturnData)
+	if err != nil {
+		return false, err
+	}
+	children := *ethabi.ConvertType(out[0], new([]*big.Int)).(*[]*big.Int)
+	return len(children) != 0, nil
+}
+
+func isNodeNotFoundRevert(data []byte) bool {
+	reason, err := ethabi.UnpackRevert(data)
+	if err == nil {
+		return abicsr.IsErrNodeNotFound(errors.New(reason))
+	}
+	if len(data) < 4 {
+		return false
+	}
+	for _, selector := range nodeNotFoundSelectors {
+		if bytes.Equal(data[:4], selector) {
+			return true
+		}
+	}
+	return false
+}
+
+func revertError(data []byte) error {
+	reason, err := ethabi.UnpackRevert(data)
+	switch {
+	case err == nil:
+		return fmt.Errorf("execution reverted: %v", reason)
+	case len(data) == 0:
+		return errors.New("execution reverted without data")
+	default:
+		return fmt.Errorf("execution reverted: %v", hexutil.Encode(data))
+	}
+}
@@ -0,0 +1,305 @@
+package eth
+
+import (
+	"context"
+	"math/big"
+	"sync/atomic"
+	"testing"
+
+	ethabi "github.com/ethereum/go-ethereum/accounts/abi"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/iden3/contracts-abi/rhs-storage/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+	"github.com/iden3/merkletree-proof/internal/testtree"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testContractAddress = ethcommon.HexToAddress(
+		"0x3d3763eC0a50CE1AdF83d0b5D99FBE0e3fEB43fb")
+	testMulticallAddress = ethcommon.HexToAddress(
+		"0x00000000000000000000000000000000000000aa")
+)
+
+func rhsABI(t testing.TB) *ethabi.ABI {
+	a, err := abi.IRHSStorageMetaData.GetAbi()
+	require.NoError(t, err)
+	return a
+}
+
+// revertData returns the return data of a revert with the reason.
+func revertData(t testing.TB, reason string) []byte {
+	typ, err := ethabi.NewType("string", "", nil)
+	require.NoError(t, err)
+	args, err := ethabi.Arguments{{Type: typ}}.Pack(reason)
+	require.NoError(t, err)
+	return append(crypto.Keccak256([]byte("Error(string)"))[:4], args...)
+}
+
+func TestNodeExists(t *testing.T) {
+	a := rhsABI(t)
+	children, err := a.Methods["getNode"].Outputs.Pack(
+		[]*big.Int{big.NewInt(1), big.NewInt(2)})
+	require.NoError(t, err)
+	noChildren, err := a.Methods["getNode"].Outputs.Pack([]*big.Int{})
+	require.NoError(t, err)
+	customNotFound := append(
+		crypto.Keccak256([]byte("NodeNotFound(uint256)"))[:4],
+		ethcommon.LeftPadBytes([]byte{5}, 32)...)
+	otherCustom := crypto.Keccak256([]byte("Unauthorized()"))[:4]
+	panicData := append(crypto.Keccak256([]byte("Panic(uint256)"))[:4],
+		ethcommon.LeftPadBytes([]byte{0x11}, 32)...)
+
+	testCases := []struct {
+		title   string
+		result  multicallResult
+		want    bool
+		wantErr string
+	}{
+		{
+			title:  "node",
+			result: multicallResult{Success: true, ReturnData: children},
+			want:   true,
+		},
+		{
+			title:  "no children",
+			result: multicallResult{Success: true, ReturnData: noChildren},
+		},
+		{
+			title: "not found reason",
+			result: multicallResult{
+				ReturnData: revertData(t, "Node not found")},
+		},
+		{
+			title:  "not found custom error",
+			result: multicallResult{ReturnData: customNotFound},
+		},
+		{
+			title: "other reason",
+			result: multicallResult{
+				ReturnData: revertData(t, "Invalid node type")},
+			wantErr: "execution reverted: Invalid node type",
+		},
+		{
+			title:   "other custom error",
+			result:  multicallResult{ReturnData: otherCustom},
+			wantErr: "execution reverted: " + hexutil.Encode(otherCustom),
+		},
+		{
+			title:   "panic",
+			result:  multicallResult{ReturnData: panicData},
+			wantErr: "execution reverted: " + hexutil.Encode(panicData),
+		},
+		{
+			title:   "no data",
+			result:  multicallResult{},
+			wantErr: "execution reverted without data",
+		},
+		{
+			title: "malformed output",
+			result: multicallResult{Success: true,
+				ReturnData: []byte{1, 2, 3}},
+			wantErr: `abi: improperly formatted output: "\x01\x02\x03" - Bytes: [1 2 3]`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			exists, err := nodeExists(a, tc.result)
+			if tc.wantErr != "" {
+				require.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, exists)
+		})
+	}
+}
+
+func TestMulticallRoundTrip(t *testing.T) {
+	a := rhsABI(t)
+	hashes := []*merkletree.Hash{testtree.Hash(t, 1), testtree.Hash(t, 2),
+		testtree.Hash(t, 3)}
+	input, err := packGetNodeCalls(a, testContractAddress, hashes)
+	require.NoError(t, err)
+
+	method, err := multicallABI.MethodById(input)
+	require.NoError(t, err)
+	require.Equal(t, "aggregate3", method.Name)
+	args, err := method.Inputs.Unpack(input[4:])
+	require.NoError(t, err)
+	calls := *ethabi.ConvertType(args[0],
+		new([]multicallCall)).(*[]multicallCall)
+	require.Len(t, calls, len(hashes))
+	for i, c := range calls {
+		require.Equal(t, testContractAddress, c.Target)
+		require.True(t, c.AllowFailure)
+		want, err := a.Pack("getNode", hashes[i].BigInt())
+		require.NoError(t, err)
+		require.Equal(t, want, c.CallData)
+	}
+
+	node, err := a.Methods["getNode"].Outputs.Pack(
+		[]*big.Int{big.NewInt(4), big.NewInt(5)})
+	require.NoError(t, err)
+	results := []multicallResult{
+		{Success: true, ReturnData: node},
+		{ReturnData: revertData(t, "Node not found")},
+		{Success: true, ReturnData: node},
+	}
+	out, err := method.Outputs.Pack(results)
+	require.NoError(t, err)
+	present, err := unpackGetNodeResults(a, out, hashes)
+	require.NoError(t, err)
+	require.Equal(t, []bool{true, false, true}, present)
+
+	_, err = unpackGetNodeResults(a, out, hashes[:2])
+	require.EqualError(t, err, "multicall returned 3 results, expected 2")
+
+	results[1] = multicallResult{}
+	out, err = method.Outputs.Pack(results)
+	require.NoError(t, err)
+	_, err = unpackGetNodeResults(a, out, hashes)
+	require.EqualError(t, err, "getNode("+hashes[1].Hex()+
+		"): execution reverted without data")
+}
+
+// fakeChain serves eth_call for an RHS contract storing nodes and, if
+// multicall is set, a Multicall3 contract.
+type fakeChain struct {
+	t         testing.TB
+	nodes     map[string][]*big.Int
+	multicall bool
+	calls     int32
+}
+
+type callArgs struct {
+	To    *ethcommon.Address `json:"to"`
+	Input hexutil.Bytes      `json:"input"`
+	Data  hexutil.Bytes      `json:"data"`
+}
+
+// rpcRevertError is a revert as reported by Ethereum nodes.
+type rpcRevertError struct {
+	reason string
+	data   []byte
+}
+
+func (e rpcRevertError) Error() string          { return "execution reverted: " + e.reason }
+func (e rpcRevertError) ErrorCode() int         { return 3 }
+func (e rpcRevertError) ErrorData() interface{} { return hexutil.Encode(e.data) }
+
+// Call implements eth_call.
+func (c *fakeChain) Call(args callArgs, _ string) (hexutil.Bytes, error) {
+	atomic.AddInt32(&c.calls, 1)
+	input := args.Input
+	if len(input) == 0 {
+		input = args.Data
+	}
+
+	switch *args.To {
+	case testContractAddress:
+		r := c.getNode(input)
+		if !r.Success {
+			return nil, rpcRevertError{reason: "Node not found",
+				data: r.ReturnData}
+		}
+		return r.ReturnData, nil
+	case testMulticallAddress:
+		if !c.multicall {
+			return nil, nil
+		}
+		method, err := multicallABI.MethodById(input)
+		if err != nil {
+			return nil, err
+		}
+		args, err := method.Inputs.Unpack(input[4:])
+		if err != nil {
+			return nil, err
+		}
+		calls := *ethabi.ConvertType(args[0],
+			new([]multicallCall)).(*[]multicallCall)
+		results := make([]multicallResult, len(calls))
+		for i, call := range calls {
+			results[i] = c.getNode(call.CallData)
+		}
+		return method.Outputs.Pack(results)
+	default:
+		return nil, nil
+	}
+}
+
+func (c *fakeChain) getNode(input []byte) multicallResult {
+	a := rhsABI(c.t)
+	args, err := a.Methods["getNode"].Inputs.Unpack(input[4:])
+	if err != nil {
+		return multicallResult{}
+	}
+	children, ok := c.nodes[args[0].(*big.Int).String()]
+	if !ok {
+		return multicallResult{
+			ReturnData: revertData(c.t, "Node not found")}
+	}
+	out, err := a.Methods["getNode"].Outputs.Pack(children)
+	if err != nil {
+		return multicallResult{}
+	}
+	return multicallResult{Success: true, ReturnData: out}
+}
+
+func newFakeChainCli(t *testing.T, chain *fakeChain) *ReverseHashCli {
+	srv := rpc.NewServer()
+	require.NoError(t, srv.RegisterName("eth", chain))
+	t.Cleanup(srv.Stop)
+	ethClient := ethclient.NewClient(rpc.DialInProc(srv))
+	t.Cleanup(ethClient.Close)
+
+	cli, err := NewReverseHashCli(ethClient, testContractAddress,
+		ethcommon.Address{}, nil, WithMulticallAddress(testMulticallAddress))
+	require.NoError(t, err)
+	return cli
+}
+
+func TestReverseHashCli_HasNodes(t *testing.T) {
+	ctx := context.Background()
+	hashes := make([]*merkletree.Hash, multicallBatchSize+3)
+	nodes := make(map[string][]*big.Int)
+	want := make([]bool, len(hashes))
+	for i := range hashes {
+		hashes[i] = testtree.Hash(t, int64(i+1))
+		if i%3 != 1 {
+			nodes[hashes[i].BigInt().String()] = []*big.Int{
+				big.NewInt(1), big.NewInt(2)}
+			want[i] = true
+		}
+	}
+
+	t.Run("multicall", func(t *testing.T) {
+		chain := &fakeChain{t: t, nodes: nodes, multicall: true}
+		cli := newFakeChainCli(t, chain)
+		present, err := cli.HasNodes(ctx, hashes)
+		require.NoError(t, err)
+		require.Equal(t, want, present)
+		// one call per batch
+		require.Equal(t, int32(2), atomic.LoadInt32(&chain.calls))
+	})
+
+	t.Run("no multicall contract", func(t *testing.T) {
+		chain := &fakeChain{t: t, nodes: nodes}
+		cli := newFakeChainCli(t, chain)
+		present, err := cli.HasNodes(ctx, hashes)
+		require.NoError(t, err)
+		require.Equal(t, want, present)
+		// the multicall contract is tried once, then getNode per node
+		require.Equal(t, int32(1+len(hashes)), atomic.LoadInt32(&chain.calls))
+	})
+
+	t.Run("nil hash", func(t *testing.T) {
+		cli := newFakeChainCli(t, &fakeChain{t: t, nodes: nodes})
+		_, err := cli.HasNodes(ctx, []*merkletree.Hash{nil})
+		require.EqualError(t, err, "hash is nil")
+	})
+}
@@ -13,15 +13,21 @@ import (
 	ethcommon "github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/ethereum/go-ethereum/log"
 	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
 	"github.com/iden3/contracts-abi/rhs-storage/go/abi"
 	"github.com/iden3/go-merkletree-sql/v2"
 	merkletree_proof "github.com/iden3/merkletree-proof"
 )
 
+var _ merkletree_proof.ReverseHashCli = (*ReverseHashCli)(nil)
+
+// backendName is the name of the client reported to observers.
+const backendName = "eth"
+
 type ReverseHashCli struct {
 	contract             *abi.IRHSStorage
+	contractAddress      ethcommon.Address
+	multicallAddress     ethcommon.Address
 	ethClient            *ethclient.Client
 	from                 ethcommon.Address
 	signer               bind.SignerFn
@@ -29,6 +35,9 @@ type ReverseHashCli struct {
 	needWaitReceipt      bool
 	txReceiptTimeout     time.Duration
 	waitReceiptCycleTime time.Duration
+	observer             merkletree_proof.Observer
+	logger               merkletree_proof.Logger
+	limiter              *merkletree_proof.Limiter
 }
 
 type Option func(cli *ReverseHashCli) error
@@ -61,12 +70,51 @@ func WithWaitReceiptCycleTime(cycleTime time.Duration) Option {
 	}
 }
 
+// WithObserver sets an observer notified about every GetNode and SaveNodes
+// call and every generated proof.
+func WithObserver(observer merkletree_proof.Observer) Option {
+	return func(cli *ReverseHashCli) error {
+		cli.observer = observer
+		return nil
+	}
+}
+
+// WithLogger sets a logger for calls, sent transactions and receipt polling.
+// By default or if the logger is nil, nothing is logged.
+func WithLogger(logger merkletree_proof.Logger) Option {
+	return func(cli *ReverseHashCli) error {
+		cli.logger = logger
+		return nil
+	}
+}
+
+// WithLimiter limits the rate and concurrency of RPC requests. The limiter
+// may be shared with other clients using the same RPC provider. Sending a
+// transaction and each receipt poll count as one request.
+func WithLimiter(limiter *merkletree_proof.Limiter) Option {
+	return func(cli *ReverseHashCli) error {
+		cli.limiter = limiter
+		return nil
+	}
+}
+
+// WithMulticallAddress sets the address of the Multicall3 contract used by
+// HasNodes. The default is DefaultMulticallAddress.
+func WithMulticallAddress(address ethcommon.Address) Option {
+	return func(cli *ReverseHashCli) error {
+		cli.multicallAddress = address
+		return nil
+	}
+}
+
 func NewReverseHashCli(ethClient *ethclient.Client,
 	contractAddress ethcommon.Address, from ethcommon.Address, signerFn bind.SignerFn,
 	opts ...Option) (*ReverseHashCli, error) {
 
 	rhc := &ReverseHashCli{
 		ethClient:            ethClient,
+		contractAddress:      contractAddress,
+		multicallAddress:     DefaultMulticallAddress,
 		from:                 from,
 		signer:               signerFn,
 		rpcTimeout:           30 * time.Second,
@@ -81,6 +129,12 @@ func NewReverseHashCli(ethClient *ethclient.Client,
 			return nil, err
 		}
 	}
+	if rhc.logger != nil {
+		rhc.observer = merkletree_proof.JoinObservers(rhc.observer,
+			merkletree_proof.LogObserver{Logger: rhc.logger})
+	} else {
+		rhc.logger = merkletree_proof.NopLogger{}
+	}
 
 	contract, err := abi.NewIRHSStorage(contractAddress, rhc.ethClient)
 	if err != nil {
@@ -95,14 +149,40 @@ func (cli *ReverseHashCli) GenerateProof(ctx context.Context,
 	treeRoot *merkletree.Hash,
 	key *merkletree.Hash) (*merkletree.Proof, error) {
 
-	return merkletree_proof.GenerateProof(ctx, cli, treeRoot, key)
+	return merkletree_proof.GenerateProof(ctx, cli, treeRoot, key,
+		merkletree_proof.WithProofObserver(cli.observer))
 }
 
 func (cli *ReverseHashCli) GetNode(ctx context.Context,
 	hash *merkletree.Hash) (merkletree_proof.Node, error) {
 
+	if cli.observer == nil {
+		return cli.getNode(ctx, hash)
+	}
+
+	start := time.Now()
+	n, err := cli.getNode(ctx, hash)
+	cli.observer.ObserveCall(ctx, merkletree_proof.CallInfo{
+		Backend:  backendName,
+		Op:       merkletree_proof.OpGetNode,
+		Hash:     hash,
+		Duration: time.Since(start),
+		Err:      err,
+	})
+	return n, err
+}
+
+func (cli *ReverseHashCli) getNode(ctx context.Context,
+	hash *merkletree.Hash) (merkletree_proof.Node, error) {
+
 	id := hash.BigInt()
 
+	release, err := cli.limiter.Acquire(cli.ctx(ctx))
+	if err != nil {
+		return merkletree_proof.Node{}, err
+	}
+	defer release()
+
 	ctx, cancel := cli.ctxWithRPCTimeout(ctx)
 	defer cancel()
 
@@ -132,6 +212,25 @@ func (cli *ReverseHashCli) GetNode(ctx context.Context,
 func (cli *ReverseHashCli) SaveNodes(ctx context.Context,
 	nodes []merkletree_proof.Node) error {
 
+	if cli.observer == nil {
+		return cli.saveNodes(ctx, nodes)
+	}
+
+	start := time.Now()
+	err := cli.saveNodes(ctx, nodes)
+	cli.observer.ObserveCall(ctx, merkletree_proof.CallInfo{
+		Backend:  backendName,
+		Op:       merkletree_proof.OpSaveNodes,
+		Nodes:    len(nodes),
+		Duration: time.Since(start),
+		Err:      err,
+	})
+	return err
+}
+
+func (cli *ReverseHashCli) saveNodes(ctx context.Context,
+	nodes []merkletree_proof.Node) error {
+
 	nodesBigInt := make([][]*big.Int, len(nodes))
 	for i, node := range nodes {
 		nodesBigInt[i] = make([]*big.Int, len(node.Children))
@@ -140,24 +239,40 @@ func (cli *ReverseHashCli) SaveNodes(ctx context.Context,
 		}
 	}
 
-	ctxRPC, cancelRPC := cli.ctxWithRPCTimeout(ctx)
-	defer cancelRPC()
-
-	txOpts, err := cli.txOptions(ctx, ctxRPC)
+	tx, err := cli.sendSaveNodesTx(ctx, nodesBigInt)
 	if err != nil {
 		return err
 	}
+	cli.logger.Debug("SaveNodes transaction sent",
+		merkletree_proof.LogKeyBackend, backendName,
+		merkletree_proof.LogKeyTxHash, tx.Hash().Hex(),
+		merkletree_proof.LogKeyNodes, len(nodes))
 
-	tx, err := cli.contract.SaveNodes(txOpts, nodesBigInt)
+	_, err = cli.waitReceipt(ctx, cli.ethClient, tx)
 	if err != nil {
 		return err
 	}
+	return nil
+}
 
-	_, err = cli.waitReceipt(ctx, cli.ethClient, tx)
+func (cli *ReverseHashCli) sendSaveNodesTx(ctx context.Context,
+	nodes [][]*big.Int) (*types.Transaction, error) {
+
+	release, err := cli.limiter.Acquire(cli.ctx(ctx))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	defer release()
+
+	ctxRPC, cancelRPC := cli.ctxWithRPCTimeout(ctx)
+	defer cancelRPC()
+
+	txOpts, err := cli.txOptions(ctx, ctxRPC)
+	if err != nil {
+		return nil, err
+	}
+
+	return cli.contract.SaveNodes(txOpts, nodes)
 }
 
 func (cli *ReverseHashCli) txOptions(ctx, ctxRPC context.Context) (*bind.TransactOpts, error) {
@@ -218,17 +333,26 @@ func (cli *ReverseHashCli) waitReceipt(ctx context.Context,
 	queryTicker := time.NewTicker(cli.waitReceiptCycleTime)
 	defer queryTicker.Stop()
 
-	logger := log.New("hash", tx.Hash())
+	start := time.Now()
 	for {
-		receipt, err := cl.TransactionReceipt(ctx, tx.Hash())
+		receipt, err := cli.transactionReceipt(ctx, cl, tx)
 		if err == nil {
+			cli.logger.Debug("transaction mined",
+				merkletree_proof.LogKeyBackend, backendName,
+				merkletree_proof.LogKeyTxHash, tx.Hash().Hex(),
+				merkletree_proof.LogKeyDuration, time.Since(start))
 			return receipt, nil
 		}
 
 		if errors.Is(err, ethereum.NotFound) {
-			logger.Trace("Transaction not yet mined")
+			cli.logger.Debug("transaction not yet mined",
+				merkletree_proof.LogKeyBackend, backendName,
+				merkletree_proof.LogKeyTxHash, tx.Hash().Hex())
 		} else {
-			logger.Trace("Receipt retrieval failed", "err", err)
+			cli.logger.Warn("receipt retrieval failed",
+				merkletree_proof.LogKeyBackend, backendName,
+				merkletree_proof.LogKeyTxHash, tx.Hash().Hex(),
+				merkletree_proof.LogKeyError, err)
 			return nil, err
 		}
 
@@ -241,6 +365,18 @@ func (cli *ReverseHashCli) waitReceipt(ctx context.Context,
 	}
 }
 
+func (cli *ReverseHashCli) transactionReceipt(ctx context.Context,
+	cl *ethclient.Client, tx *types.Transaction) (*types.Receipt, error) {
+
+	release, err := cli.limiter.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return cl.TransactionReceipt(ctx, tx.Hash())
+}
+
 func (cli *ReverseHashCli) suggestGasTipCap(ctx context.Context) (*big.Int, error) {
 	ctxRPC, cancel := cli.ctxWithRPCTimeout(ctx)
 	defer cancel()
@@ -249,7 +385,9 @@ func (cli *ReverseHashCli) suggestGasTipCap(ctx context.Context) (*big.Int, erro
 	// since hardhat doesn't support 'eth_maxPriorityFeePerGas' rpc call.
 	// we should hard code 0 as a mainer tips. More information: https://github.com/NomicFoundation/hardhat/issues/1664#issuecomment-1149006010
 	if err != nil && strings.Contains(err.Error(), "eth_maxPriorityFeePerGas") {
-		log.Trace("failed get suggest gas tip. Use 0 instead", "err", err)
+		cli.logger.Debug("failed get suggest gas tip, use 0 instead",
+			merkletree_proof.LogKeyBackend, backendName,
+			merkletree_proof.LogKeyError, err)
 		tip = big.NewInt(0)
 	} else if err != nil {
 		return nil, fmt.Errorf("failed get suggest gas tip: %w", err)
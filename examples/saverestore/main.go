@@ -56,7 +56,7 @@ func main() {
 	fmt.Println("OK: trees are equal")
 }
 
-func restoreTree(cli *mtpHttp.ReverseHashCli,
+func restoreTree(cli mtp.NodeReader,
 	root *merkletree.Hash) *merkletree.MerkleTree {
 	mt := newEmptyTree()
 	walkRHSLeafs(cli, root, func(key, value *merkletree.Hash) {
@@ -68,7 +68,7 @@ func restoreTree(cli *mtpHttp.ReverseHashCli,
 	return mt
 }
 
-func walkRHSLeafs(cli *mtpHttp.ReverseHashCli, root *merkletree.Hash,
+func walkRHSLeafs(cli mtp.NodeReader, root *merkletree.Hash,
 	fn func(key, value *merkletree.Hash)) {
 
 	node, err := cli.GetNode(context.Background(), root)
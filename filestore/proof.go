@@ -0,0 +1,624 @@
+// Package filestore implements a reverse hash service backend that persists
+// nodes in a single append-only file. It needs no database server and can be
+// used directly or served over the RHS HTTP protocol with http.NewHandler.
+//
+// Every SaveNodes call is written as one checksummed record, so a batch is
+// either saved completely or not at all. On open, an incomplete record at the
+// end of the file, left by a crash, is truncated. A complete record with a
+// bad checksum fails the open instead, as its data can't be recovered.
+//
+// The file must not be opened by more than one ReverseHashCli at a time.
+package filestore
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+)
+
+var _ merkletree_proof.ReverseHashCli = (*ReverseHashCli)(nil)
+var _ merkletree_proof.PrunableStore = (*ReverseHashCli)(nil)
+var _ merkletree_proof.NodeChecker = (*ReverseHashCli)(nil)
+
+// ErrClosed is returned when the store is used after Close.
+var ErrClosed = errors.New("file store is closed")
+
+// ErrFailed is returned by writes after a failed write could not be rolled
+// back or the compacted file could not be opened. Reopening the store
+// recovers the file.
+var ErrFailed = errors.New("file store failed")
+
+const (
+	recordHeaderLen = 8
+	hashLen         = len(merkletree.Hash{})
+	maxChildren     = 3
+
+	entryPut    byte = 1
+	entryDelete byte = 2
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ReverseHashCli stores nodes in a file. It is safe for concurrent use.
+type ReverseHashCli struct {
+	path        string
+	syncWrites  bool
+	compactPath string
+
+	mu      sync.RWMutex
+	f       *os.File
+	size    int64
+	index   map[merkletree.Hash]nodeLocation
+	garbage int
+	// failed is set when the file may end with a record not in the index
+	failed error
+}
+
+type nodeLocation struct {
+	// offset of the first child in the file
+	offset   int64
+	children uint8
+}
+
+type Option func(cli *ReverseHashCli) error
+
+// WithSyncWrites sets whether every SaveNodes call waits for the data to be
+// flushed to disk. It is enabled by default. Disabling it makes writes
+// faster, but the last saved batches may be lost on power failure.
+func WithSyncWrites(sync bool) Option {
+	return func(cli *ReverseHashCli) error {
+		cli.syncWrites = sync
+		return nil
+	}
+}
+
+// NewReverseHashCli opens the store file at path, creating it if it does not
+// exist, and recovers it after a crash if needed.
+func NewReverseHashCli(path string, opts ...Option) (*ReverseHashCli, error) {
+	cli := &ReverseHashCli{
+		path:        path,
+		syncWrites:  true,
+		compactPath: path + ".compact",
+	}
+	for _, o := range opts {
+		err := o(cli)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// leftover of an interrupted compaction, the original file is intact
+	err := os.Remove(cli.compactPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	err = cli.open()
+	if err != nil {
+		return nil, err
+	}
+	return cli, nil
+}
+
+// open opens the file and loads its index. The store is left unchanged on
+// error.
+func (cli *ReverseHashCli) open() error {
+	f, err := os.OpenFile(cli.path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	index, size, garbage, err := loadIndex(f)
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to load %v: %w", cli.path, err)
+	}
+
+	// drop a torn record at the end of the file
+	err = f.Truncate(size)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	cli.f = f
+	cli.size = size
+	cli.index = index
+	cli.garbage = garbage
+	cli.failed = nil
+	return nil
+}
+
+func (cli *ReverseHashCli) GenerateProof(ctx context.Context,
+	treeRoot *merkletree.Hash,
+	key *merkletree.Hash) (*merkletree.Proof, error) {
+
+	return merkletree_proof.GenerateProof(ctx, cli, treeRoot, key)
+}
+
+func (cli *ReverseHashCli) GetNode(_ context.Context,
+	hash *merkletree.Hash) (merkletree_proof.Node, error) {
+
+	if hash == nil {
+		return merkletree_proof.Node{}, errors.New("hash is nil")
+	}
+
+	cli.mu.RLock()
+	defer cli.mu.RUnlock()
+
+	if cli.f == nil {
+		return merkletree_proof.Node{}, ErrClosed
+	}
+
+	loc, ok := cli.index[*hash]
+	if !ok {
+		return merkletree_proof.Node{}, abicsr.ErrNodeNotFound
+	}
+
+	buf := make([]byte, int(loc.children)*hashLen)
+	_, err := cli.f.ReadAt(buf, loc.offset)
+	if err != nil {
+		return merkletree_proof.Node{}, err
+	}
+
+	h := *hash
+	n := merkletree_proof.Node{
+		Hash:     &h,
+		Children: make([]*merkletree.Hash, loc.children),
+	}
+	for i := range n.Children {
+		var c merkletree.Hash
+		copy(c[:], buf[i*hashLen:])
+		n.Children[i] = &c
+	}
+	return n, nil
+}
+
+// HasNodes reports which of the nodes are stored, using only the in-memory
+// index.
+func (cli *ReverseHashCli) HasNodes(_ context.Context,
+	hashes []*merkletree.Hash) ([]bool, error) {
+
+	cli.mu.RLock()
+	defer cli.mu.RUnlock()
+
+	if cli.f == nil {
+		return nil, ErrClosed
+	}
+
+	present := make([]bool, len(hashes))
+	for i, h := range hashes {
+		if h == nil {
+			return nil, errors.New("hash is nil")
+		}
+		_, present[i] = cli.index[*h]
+	}
+	return present, nil
+}
+
+// SaveNodes validates nodes and atomically appends the ones not stored yet
+// to the file.
+func (cli *ReverseHashCli) SaveNodes(_ context.Context,
+	nodes []merkletree_proof.Node) error {
+
+	for i, n := range nodes {
+		if err := n.Validate(); err != nil {
+			return fmt.Errorf("invalid node #%v: %w", i, err)
+		}
+	}
+
+	cli.mu.Lock()
+	defer cli.mu.Unlock()
+
+	if cli.f == nil {
+		return ErrClosed
+	}
+
+	var payload []byte
+	var added []merkletree_proof.Node
+	seen := make(map[merkletree.Hash]bool, len(nodes))
+	for _, n := range nodes {
+		if _, ok := cli.index[*n.Hash]; ok || seen[*n.Hash] {
+			continue
+		}
+		seen[*n.Hash] = true
+		payload = appendPutEntry(payload, n)
+		added = append(added, n)
+	}
+	if len(added) == 0 {
+		return nil
+	}
+
+	offset := cli.size + recordHeaderLen
+	err := cli.appendRecord(payload)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range added {
+		offset += 1 + int64(hashLen) + 1
+		cli.index[*n.Hash] = nodeLocation{
+			offset:   offset,
+			children: uint8(len(n.Children)),
+		}
+		offset += int64(len(n.Children) * hashLen)
+	}
+	return nil
+}
+
+// DeleteNodes atomically deletes nodes by their hashes. Missing nodes are
+// ignored. The space is reclaimed by Compact.
+func (cli *ReverseHashCli) DeleteNodes(_ context.Context,
+	hashes []*merkletree.Hash) error {
+
+	for _, h := range hashes {
+		if h == nil {
+			return errors.New("hash is nil")
+		}
+	}
+
+	cli.mu.Lock()
+	defer cli.mu.Unlock()
+
+	if cli.f == nil {
+		return ErrClosed
+	}
+
+	var payload []byte
+	var deleted []merkletree.Hash
+	for _, h := range hashes {
+		if _, ok := cli.index[*h]; !ok {
+			continue
+		}
+		payload = append(payload, entryDelete)
+		payload = append(payload, h[:]...)
+		deleted = append(deleted, *h)
+	}
+	if len(deleted) == 0 {
+		return nil
+	}
+
+	err := cli.appendRecord(payload)
+	if err != nil {
+		return err
+	}
+
+	for _, h := range deleted {
+		if _, ok := cli.index[h]; ok {
+			delete(cli.index, h)
+			// both the put entry and the tombstone
+			cli.garbage += 2
+		}
+	}
+	return nil
+}
+
+// ForEachNode calls fn for hashes of all stored nodes. fn may modify the
+// store. Nodes deleted before fn is called for them are skipped, and nodes
+// saved during the call may be skipped too.
+func (cli *ReverseHashCli) ForEachNode(ctx context.Context,
+	fn func(hash *merkletree.Hash) error) error {
+
+	cli.mu.RLock()
+	defer cli.mu.RUnlock()
+
+	if cli.f == nil {
+		return ErrClosed
+	}
+
+	// the index is ranged over with the lock held and the lock is released
+	// while fn runs; a map may be modified while it is ranged over, so no
+	// copy of the hashes is needed. Compact replaces the index, so hashes
+	// are looked up in the current one before fn is called.
+	for h := range cli.index {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if cli.f == nil {
+			return ErrClosed
+		}
+		if _, ok := cli.index[h]; !ok {
+			continue
+		}
+		h := h
+		if err := cli.callUnlocked(fn, &h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// callUnlocked calls fn with the read lock released.
+func (cli *ReverseHashCli) callUnlocked(
+	fn func(hash *merkletree.Hash) error, hash *merkletree.Hash) error {
+
+	cli.mu.RUnlock()
+	defer cli.mu.RLock()
+	return fn(hash)
+}
+
+// appendRecord writes the payload as one record at the end of the file.
+func (cli *ReverseHashCli) appendRecord(payload []byte) error {
+	if cli.failed != nil {
+		return cli.failed
+	}
+
+	record := newRecord(payload)
+	_, err := cli.f.WriteAt(record, cli.size)
+	if err == nil && cli.syncWrites {
+		err = cli.f.Sync()
+	}
+	if err != nil {
+		// drop the record, written completely or not, so only a crash can
+		// leave a record not in the index at the end of the file
+		truncErr := cli.f.Truncate(cli.size)
+		if truncErr != nil {
+			cli.failed = fmt.Errorf("%w: %v", ErrFailed, truncErr)
+		}
+		return err
+	}
+	cli.size += int64(len(record))
+	return nil
+}
+
+// Len returns the number of stored nodes.
+func (cli *ReverseHashCli) Len() int {
+	cli.mu.RLock()
+	defer cli.mu.RUnlock()
+	return len(cli.index)
+}
+
+// Compact rewrites the file keeping only live nodes. The new file replaces
+// the old one atomically, so a crash during compaction does not lose data.
+func (cli *ReverseHashCli) Compact(ctx context.Context) error {
+	cli.mu.Lock()
+	defer cli.mu.Unlock()
+
+	if cli.f == nil {
+		return ErrClosed
+	}
+
+	err := cli.writeCompacted(ctx)
+	if err != nil {
+		_ = os.Remove(cli.compactPath)
+		return err
+	}
+
+	err = os.Rename(cli.compactPath, cli.path)
+	if err != nil {
+		// the original file is intact and still open
+		_ = os.Remove(cli.compactPath)
+		return err
+	}
+	syncDir(filepath.Dir(cli.path))
+
+	old := cli.f
+	err = cli.open()
+	if err != nil {
+		// the old file is still open for reads, but it was replaced, so
+		// writes to it would be lost
+		cli.failed = fmt.Errorf("%w: %v", ErrFailed, err)
+		return err
+	}
+	// its data is in the compacted file, so a close error loses nothing
+	_ = old.Close()
+	return nil
+}
+
+// Garbage returns the number of superseded entries in the file that
+// Compact would drop.
+func (cli *ReverseHashCli) Garbage() int {
+	cli.mu.RLock()
+	defer cli.mu.RUnlock()
+	return cli.garbage
+}
+
+func (cli *ReverseHashCli) writeCompacted(ctx context.Context) error {
+	out, err := os.OpenFile(cli.compactPath,
+		os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = out.Close() }()
+
+	w := bufio.NewWriter(out)
+	const batchSize = 1000
+	var payload []byte
+	var count int
+	for hash, loc := range cli.index {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		buf := make([]byte, int(loc.children)*hashLen)
+		_, err = cli.f.ReadAt(buf, loc.offset)
+		if err != nil {
+			return err
+		}
+		payload = append(payload, entryPut)
+		payload = append(payload, hash[:]...)
+		payload = append(payload, loc.children)
+		payload = append(payload, buf...)
+
+		count++
+		if count%batchSize == 0 {
+			if _, err = w.Write(newRecord(payload)); err != nil {
+				return err
+			}
+			payload = payload[:0]
+		}
+	}
+	if len(payload) != 0 {
+		if _, err = w.Write(newRecord(payload)); err != nil {
+			return err
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	return out.Sync()
+}
+
+// Close closes the file. The store can't be used after Close.
+func (cli *ReverseHashCli) Close() error {
+	cli.mu.Lock()
+	defer cli.mu.Unlock()
+
+	if cli.f == nil {
+		return ErrClosed
+	}
+	err := cli.f.Close()
+	cli.f = nil
+	cli.index = nil
+	return err
+}
+
+// syncDir makes a rename in the directory durable. Not all platforms support
+// syncing a directory, so errors are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
+
+func appendPutEntry(payload []byte, n merkletree_proof.Node) []byte {
+	payload = append(payload, entryPut)
+	payload = append(payload, n.Hash[:]...)
+	payload = append(payload, uint8(len(n.Children)))
+	for _, c := range n.Children {
+		payload = append(payload, c[:]...)
+	}
+	return payload
+}
+
+// newRecord frames the payload with its length and checksum.
+func newRecord(payload []byte) []byte {
+	record := make([]byte, recordHeaderLen+len(payload))
+	binary.LittleEndian.PutUint32(record, uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:],
+		crc32.Checksum(payload, crcTable))
+	copy(record[recordHeaderLen:], payload)
+	return record
+}
+
+// loadIndex reads all records and returns the index of nodes and the size
+// of the valid part of the file. Reading stops at an incomplete or corrupted
+// last record. A corrupted record followed by other data is an error, as it
+// can't be caused by a crash during write. garbage is the number of entries
+// that are superseded by later entries.
+func loadIndex(f *os.File) (index map[merkletree.Hash]nodeLocation,
+	size int64, garbage int, err error) {
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	index = make(map[merkletree.Hash]nodeLocation)
+	r := bufio.NewReader(io.NewSectionReader(f, 0, fi.Size()))
+	header := make([]byte, recordHeaderLen)
+	for {
+		_, err = io.ReadFull(r, header)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return index, size, garbage, nil
+		} else if err != nil {
+			return nil, 0, 0, err
+		}
+
+		payloadLen := int64(binary.LittleEndian.Uint32(header))
+		if size+recordHeaderLen+payloadLen > fi.Size() {
+			// torn record at the end of the file
+			return index, size, garbage, nil
+		}
+		payload := make([]byte, payloadLen)
+		_, err = io.ReadFull(r, payload)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return index, size, garbage, nil
+		} else if err != nil {
+			return nil, 0, 0, err
+		}
+
+		// a complete record was written, so a mismatch is not a torn write
+		if crc32.Checksum(payload, crcTable) !=
+			binary.LittleEndian.Uint32(header[4:]) {
+			return nil, 0, 0, fmt.Errorf("corrupted record at offset %v",
+				size)
+		}
+
+		entries, ok := parsePayload(payload, size+recordHeaderLen)
+		if !ok {
+			return nil, 0, 0, fmt.Errorf("corrupted record at offset %v",
+				size)
+		}
+		for _, e := range entries {
+			_, exists := index[e.hash]
+			switch {
+			case e.deleted && exists:
+				delete(index, e.hash)
+				garbage += 2
+			case e.deleted:
+				garbage++
+			case exists:
+				garbage++
+				index[e.hash] = e.loc
+			default:
+				index[e.hash] = e.loc
+			}
+		}
+		size += int64(recordHeaderLen + len(payload))
+	}
+}
+
+type entry struct {
+	hash    merkletree.Hash
+	loc     nodeLocation
+	deleted bool
+}
+
+// parsePayload parses entries of the record. offset is the position of the
+// payload in the file.
+func parsePayload(payload []byte, offset int64) ([]entry, bool) {
+	var entries []entry
+	pos := 0
+	for pos < len(payload) {
+		if len(payload)-pos >= 1+hashLen && payload[pos] == entryDelete {
+			e := entry{deleted: true}
+			copy(e.hash[:], payload[pos+1:])
+			pos += 1 + hashLen
+			entries = append(entries, e)
+			continue
+		}
+
+		if len(payload)-pos < 1+hashLen+1 || payload[pos] != entryPut {
+			return nil, false
+		}
+		var e entry
+		copy(e.hash[:], payload[pos+1:])
+		e.loc.children = payload[pos+1+hashLen]
+		pos += 1 + hashLen + 1
+
+		childrenLen := int(e.loc.children) * hashLen
+		if e.loc.children > maxChildren || len(payload)-pos < childrenLen {
+			return nil, false
+		}
+		e.loc.offset = offset + int64(pos)
+		pos += childrenLen
+		entries = append(entries, e)
+	}
+	return entries, true
+}
@@ -0,0 +1,322 @@
+package filestore
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+	"github.com/iden3/merkletree-proof/internal/testtree"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReverseHashCli(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "nodes.db")
+	mt := testtree.Build(t, 1, 5, 7, 100, 12345)
+	nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+
+	cli, err := NewReverseHashCli(path)
+	require.NoError(t, err)
+	require.NoError(t, cli.SaveNodes(ctx, nodes[:3]))
+	require.NoError(t, cli.SaveNodes(ctx, nodes))
+	require.Equal(t, len(nodes), cli.Len())
+	require.NoError(t, cli.Close())
+
+	// all nodes are available after reopening
+	cli, err = NewReverseHashCli(path)
+	require.NoError(t, err)
+	defer func() { _ = cli.Close() }()
+	require.Equal(t, len(nodes), cli.Len())
+	for _, n := range nodes {
+		got, err := cli.GetNode(ctx, n.Hash)
+		require.NoError(t, err)
+		require.Equal(t, n, got)
+	}
+
+	for _, k := range []int64{1, 100, 3} {
+		key, err := merkletree.NewHashFromBigInt(big.NewInt(k))
+		require.NoError(t, err)
+		wantProof, _, err := mt.GenerateProof(ctx, big.NewInt(k), nil)
+		require.NoError(t, err)
+		proof, err := cli.GenerateProof(ctx, mt.Root(), key)
+		require.NoError(t, err)
+		require.Equal(t, wantProof, proof)
+	}
+
+	_, err = cli.GetNode(ctx, &merkletree.HashZero)
+	require.ErrorIs(t, err, abicsr.ErrNodeNotFound)
+
+	invalid := nodes[0]
+	invalid.Hash = &merkletree.HashZero
+	err = cli.SaveNodes(ctx, []merkletree_proof.Node{invalid})
+	require.ErrorContains(t, err, "invalid node #0")
+}
+
+func TestReverseHashCli_TornWrite(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "nodes.db")
+	nodes, err := merkletree_proof.NodesFromTree(ctx,
+		testtree.Build(t, 1, 5, 7), nil)
+	require.NoError(t, err)
+
+	cli, err := NewReverseHashCli(path)
+	require.NoError(t, err)
+	require.NoError(t, cli.SaveNodes(ctx, nodes[:1]))
+	require.NoError(t, cli.SaveNodes(ctx, nodes[1:]))
+	require.NoError(t, cli.Close())
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	// simulate a crash in the middle of writing the second batch
+	require.NoError(t, os.Truncate(path, fi.Size()-10))
+
+	cli, err = NewReverseHashCli(path)
+	require.NoError(t, err)
+	require.Equal(t, 1, cli.Len())
+	_, err = cli.GetNode(ctx, nodes[0].Hash)
+	require.NoError(t, err)
+
+	// the store is writable after recovery
+	require.NoError(t, cli.SaveNodes(ctx, nodes[1:]))
+	require.NoError(t, cli.Close())
+
+	cli, err = NewReverseHashCli(path)
+	require.NoError(t, err)
+	require.Equal(t, len(nodes), cli.Len())
+	require.NoError(t, cli.Close())
+}
+
+func TestReverseHashCli_WriteError(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "nodes.db")
+	nodes, err := merkletree_proof.NodesFromTree(ctx,
+		testtree.Build(t, 1, 5, 7), nil)
+	require.NoError(t, err)
+
+	cli, err := NewReverseHashCli(path)
+	require.NoError(t, err)
+	require.NoError(t, cli.SaveNodes(ctx, nodes[:1]))
+
+	// a file that can't be written or truncated, so the failed write can't
+	// be rolled back
+	rw := cli.f
+	ro, err := os.Open(path)
+	require.NoError(t, err)
+	cli.f = ro
+	require.Error(t, cli.SaveNodes(ctx, nodes[1:]))
+	err = cli.SaveNodes(ctx, nodes[1:])
+	require.ErrorIs(t, err, ErrFailed)
+	err = cli.DeleteNodes(ctx, []*merkletree.Hash{nodes[0].Hash})
+	require.ErrorIs(t, err, ErrFailed)
+	// reads still work
+	_, err = cli.GetNode(ctx, nodes[0].Hash)
+	require.NoError(t, err)
+	cli.f = rw
+	require.NoError(t, ro.Close())
+	require.NoError(t, cli.Close())
+
+	// reopening recovers the store
+	cli, err = NewReverseHashCli(path)
+	require.NoError(t, err)
+	require.Equal(t, 1, cli.Len())
+	require.NoError(t, cli.SaveNodes(ctx, nodes[1:]))
+	require.Equal(t, len(nodes), cli.Len())
+	require.NoError(t, cli.Close())
+}
+
+func TestReverseHashCli_Corrupted(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "nodes.db")
+	nodes, err := merkletree_proof.NodesFromTree(ctx,
+		testtree.Build(t, 1, 5, 7), nil)
+	require.NoError(t, err)
+
+	cli, err := NewReverseHashCli(path)
+	require.NoError(t, err)
+	require.NoError(t, cli.SaveNodes(ctx, nodes[:1]))
+	require.NoError(t, cli.SaveNodes(ctx, nodes[1:]))
+	require.NoError(t, cli.Close())
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{0xff}, recordHeaderLen+5)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = NewReverseHashCli(path)
+	require.ErrorContains(t, err, "corrupted record at offset 0")
+
+	// a complete last record with a bad checksum is not a torn write
+	require.NoError(t, os.Remove(path))
+	cli, err = NewReverseHashCli(path)
+	require.NoError(t, err)
+	require.NoError(t, cli.SaveNodes(ctx, nodes[:1]))
+	offset := cli.size
+	require.NoError(t, cli.SaveNodes(ctx, nodes[1:]))
+	require.NoError(t, cli.Close())
+
+	f, err = os.OpenFile(path, os.O_RDWR, 0)
+	require.NoError(t, err)
+	fi, err := f.Stat()
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{0xff}, fi.Size()-1)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = NewReverseHashCli(path)
+	require.ErrorContains(t, err,
+		fmt.Sprintf("corrupted record at offset %v", offset))
+	fi, err = os.Stat(path)
+	require.NoError(t, err)
+	require.Greater(t, fi.Size(), offset)
+}
+
+func TestReverseHashCli_Compact(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "nodes.db")
+	mt := testtree.Build(t, 1, 5, 7, 100, 12345)
+	nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+
+	cli, err := NewReverseHashCli(path, WithSyncWrites(false))
+	require.NoError(t, err)
+	defer func() { _ = cli.Close() }()
+	for _, n := range nodes {
+		require.NoError(t, cli.SaveNodes(ctx, []merkletree_proof.Node{n}))
+	}
+
+	require.NoError(t, cli.Compact(ctx))
+	require.Equal(t, len(nodes), cli.Len())
+	require.Equal(t, 0, cli.Garbage())
+	for _, n := range nodes {
+		got, err := cli.GetNode(ctx, n.Hash)
+		require.NoError(t, err)
+		require.Equal(t, n, got)
+	}
+	_, err = os.Stat(path + ".compact")
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// the store is writable after compaction
+	more, err := merkletree_proof.NodesFromTree(ctx, testtree.Build(t, 2, 3), nil)
+	require.NoError(t, err)
+	require.NoError(t, cli.SaveNodes(ctx, more))
+	require.Equal(t, len(nodes)+len(more), cli.Len())
+
+	// the original file stays open if it can't be replaced
+	dir := filepath.Join(t.TempDir(), "dir")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "file"), 0o755))
+	cli.path = dir
+	require.Error(t, cli.Compact(ctx))
+	cli.path = path
+	_, err = os.Stat(path + ".compact")
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.Equal(t, len(nodes)+len(more), cli.Len())
+	require.NoError(t, cli.DeleteNodes(ctx, []*merkletree.Hash{more[0].Hash}))
+	require.NoError(t, cli.Close())
+
+	cli, err = NewReverseHashCli(path)
+	require.NoError(t, err)
+	require.Equal(t, len(nodes)+len(more)-1, cli.Len())
+}
+
+func TestReverseHashCli_ForEachNode(t *testing.T) {
+	ctx := context.Background()
+	live, err := merkletree_proof.NodesFromTree(ctx,
+		testtree.Build(t, 1, 5, 7), nil)
+	require.NoError(t, err)
+	dead, err := merkletree_proof.NodesFromTree(ctx,
+		testtree.Build(t, 2, 3), nil)
+	require.NoError(t, err)
+
+	cli, err := NewReverseHashCli(filepath.Join(t.TempDir(), "nodes.db"))
+	require.NoError(t, err)
+	defer func() { _ = cli.Close() }()
+	require.NoError(t, cli.SaveNodes(ctx, live))
+	require.NoError(t, cli.SaveNodes(ctx, dead))
+
+	// the store is compacted and nodes are deleted while iterating
+	seen := make(map[merkletree.Hash]int)
+	err = cli.ForEachNode(ctx, func(hash *merkletree.Hash) error {
+		seen[*hash]++
+		if len(seen) > 1 {
+			return nil
+		}
+		if err := cli.Compact(ctx); err != nil {
+			return err
+		}
+		hashes := make([]*merkletree.Hash, len(dead))
+		for i, n := range dead {
+			hashes[i] = n.Hash
+		}
+		return cli.DeleteNodes(ctx, hashes)
+	})
+	require.NoError(t, err)
+
+	for _, n := range live {
+		require.Equal(t, 1, seen[*n.Hash])
+	}
+	// only a dead node passed to fn before the deletion can be seen
+	require.LessOrEqual(t, len(seen), len(live)+1)
+}
+
+func TestReverseHashCli_DeleteNodes(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "nodes.db")
+	live := testtree.Build(t, 1, 5, 7)
+	liveNodes, err := merkletree_proof.NodesFromTree(ctx, live, nil)
+	require.NoError(t, err)
+	dead := testtree.Build(t, 2, 3)
+	deadNodes, err := merkletree_proof.NodesFromTree(ctx, dead, nil)
+	require.NoError(t, err)
+
+	cli, err := NewReverseHashCli(path)
+	require.NoError(t, err)
+	require.NoError(t, cli.SaveNodes(ctx, liveNodes))
+	require.NoError(t, cli.SaveNodes(ctx, deadNodes))
+
+	result, err := merkletree_proof.Prune(ctx, cli,
+		[]*merkletree.Hash{live.Root()})
+	require.NoError(t, err)
+	require.Equal(t, len(deadNodes), result.Deleted)
+	require.Equal(t, len(liveNodes), cli.Len())
+	require.Equal(t, 2*len(deadNodes), cli.Garbage())
+	require.NoError(t, cli.Close())
+
+	// deletions survive reopening
+	cli, err = NewReverseHashCli(path)
+	require.NoError(t, err)
+	defer func() { _ = cli.Close() }()
+	require.Equal(t, len(liveNodes), cli.Len())
+	require.Equal(t, 2*len(deadNodes), cli.Garbage())
+	_, err = cli.GetNode(ctx, dead.Root())
+	require.ErrorIs(t, err, abicsr.ErrNodeNotFound)
+	present, err := cli.HasNodes(ctx,
+		[]*merkletree.Hash{live.Root(), dead.Root()})
+	require.NoError(t, err)
+	require.Equal(t, []bool{true, false}, present)
+
+	fiBefore, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, cli.Compact(ctx))
+	fiAfter, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Less(t, fiAfter.Size(), fiBefore.Size())
+	require.Equal(t, 0, cli.Garbage())
+	require.Equal(t, len(liveNodes), cli.Len())
+
+	// deleted nodes can be saved again
+	require.NoError(t, cli.SaveNodes(ctx, deadNodes))
+	_, err = cli.GetNode(ctx, dead.Root())
+	require.NoError(t, err)
+
+	err = cli.DeleteNodes(ctx, []*merkletree.Hash{live.Root(), nil})
+	require.EqualError(t, err, "hash is nil")
+	require.Equal(t, len(liveNodes)+len(deadNodes), cli.Len())
+}
@@ -8,6 +8,7 @@ require (
 	github.com/iden3/contracts-abi/rhs-storage/go/abi v0.0.0-20231006141557-7d13ef7e3c48
 	github.com/iden3/contracts-abi/state/go/abi v1.1.0
 	github.com/iden3/go-iden3-core/v2 v2.3.1
+	github.com/iden3/go-iden3-crypto v0.0.17
 	github.com/iden3/go-merkletree-sql/v2 v2.0.4
 	github.com/iden3/go-schema-processor/v2 v2.4.0
 	github.com/jarcoal/httpmock v1.3.1
@@ -30,7 +31,6 @@ require (
 	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/holiman/uint256 v1.2.3 // indirect
 	github.com/huin/goupnp v1.2.0 // indirect
-	github.com/iden3/go-iden3-crypto v0.0.17 // indirect
 	github.com/klauspost/compress v1.16.5 // indirect
 	github.com/mattn/go-isatty v0.0.19 // indirect
 	github.com/mr-tron/base58 v1.2.0 // indirect
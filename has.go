@@ -0,0 +1,44 @@
+package merkletree_proof
+
+import (
+	"context"
+	"errors"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+)
+
+// NodeChecker is implemented by backends that can check which nodes they
+// store without fetching them.
+type NodeChecker interface {
+	// HasNodes returns a presence bitmap: the i-th value reports whether
+	// the node with hashes[i] is stored.
+	HasNodes(ctx context.Context, hashes []*merkletree.Hash) ([]bool, error)
+}
+
+// HasNodes returns a presence bitmap of the nodes in the reader: the i-th
+// value reports whether the node with hashes[i] is stored. It uses
+// NodeChecker if the reader implements it and fetches nodes with GetNode
+// one by one otherwise.
+func HasNodes(ctx context.Context, cli NodeReader,
+	hashes []*merkletree.Hash) ([]bool, error) {
+
+	if checker, ok := cli.(NodeChecker); ok {
+		return checker.HasNodes(ctx, hashes)
+	}
+
+	present := make([]bool, len(hashes))
+	for i, h := range hashes {
+		if h == nil {
+			return nil, errors.New("hash is nil")
+		}
+		_, err := cli.GetNode(ctx, h)
+		if errors.Is(err, abicsr.ErrNodeNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		present[i] = true
+	}
+	return present, nil
+}
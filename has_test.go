@@ -0,0 +1,49 @@
+package merkletree_proof
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/iden3/go-merkletree-sql/v2"
+	"github.com/iden3/merkletree-proof/internal/testtree"
+	"github.com/stretchr/testify/require"
+)
+
+type testChecker struct {
+	testBackend
+}
+
+func (c *testChecker) HasNodes(_ context.Context,
+	hashes []*merkletree.Hash) ([]bool, error) {
+
+	return []bool{true}, nil
+}
+
+func TestHasNodes(t *testing.T) {
+	ctx := context.Background()
+	mt := testtree.Build(t, 1, 2, 3)
+	nodes, err := NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+
+	backend := &testBackend{}
+	require.NoError(t, backend.SaveNodes(ctx, nodes[:2]))
+	present, err := HasNodes(ctx, backend, []*merkletree.Hash{
+		nodes[0].Hash, nodes[2].Hash, nodes[1].Hash})
+	require.NoError(t, err)
+	require.Equal(t, []bool{true, false, true}, present)
+
+	_, err = HasNodes(ctx, backend, []*merkletree.Hash{nil})
+	require.Error(t, err)
+
+	errDown := errors.New("backend is down")
+	_, err = HasNodes(ctx, &switchableReader{err: errDown},
+		[]*merkletree.Hash{nodes[0].Hash})
+	require.ErrorIs(t, err, errDown)
+
+	// NodeChecker is used if implemented
+	present, err = HasNodes(ctx, &testChecker{},
+		[]*merkletree.Hash{nodes[2].Hash})
+	require.NoError(t, err)
+	require.Equal(t, []bool{true}, present)
+}
@@ -0,0 +1,248 @@
+package http
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CachedResponse is a GetNode response body stored in a ResponseCache along
+// with its validators.
+type CachedResponse struct {
+	Body         []byte
+	ETag         string
+	LastModified string
+	// Expires is the time until which the response may be used without
+	// revalidation. The zero time means it must always be revalidated.
+	Expires time.Time
+}
+
+// ResponseCache stores GetNode responses keyed by request URL. It must be
+// safe for concurrent use. Only successful responses are stored, as a node
+// missing now may be saved later.
+type ResponseCache interface {
+	Get(key string) (CachedResponse, bool)
+	Set(key string, resp CachedResponse)
+}
+
+// WithResponseCache sets ReverseHashCli.Cache.
+func WithResponseCache(cache ResponseCache) Option {
+	return func(cli *ReverseHashCli) error {
+		cli.Cache = cache
+		return nil
+	}
+}
+
+// MemoryResponseCache is a ResponseCache keeping a limited number of
+// recently used responses in memory.
+type MemoryResponseCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	lru        *list.List
+}
+
+type memoryCacheEntry struct {
+	key  string
+	resp CachedResponse
+}
+
+// NewMemoryResponseCache returns a cache keeping up to maxEntries responses.
+func NewMemoryResponseCache(maxEntries int) *MemoryResponseCache {
+	if maxEntries <= 0 {
+		maxEntries = 1
+	}
+	return &MemoryResponseCache{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+func (c *MemoryResponseCache) Get(key string) (CachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return CachedResponse{}, false
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*memoryCacheEntry).resp, true
+}
+
+func (c *MemoryResponseCache) Set(key string, resp CachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*memoryCacheEntry).resp = resp
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&memoryCacheEntry{key: key, resp: resp})
+	for c.lru.Len() > c.maxEntries {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.entries, el.Value.(*memoryCacheEntry).key)
+	}
+}
+
+// Len returns the number of cached responses.
+func (c *MemoryResponseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// cachedResponse returns the cache entry for a successful response, or false
+// if the response must not be cached.
+func cachedResponse(h http.Header, body []byte,
+	now time.Time) (CachedResponse, bool) {
+
+	cc := parseCacheControl(h.Get("Cache-Control"))
+	if _, ok := cc["no-store"]; ok {
+		return CachedResponse{}, false
+	}
+
+	resp := CachedResponse{
+		Body:         body,
+		ETag:         h.Get("ETag"),
+		LastModified: h.Get("Last-Modified"),
+		Expires:      freshUntil(h, cc, now),
+	}
+	if resp.Expires.IsZero() && resp.ETag == "" && resp.LastModified == "" {
+		// neither fresh nor revalidatable
+		return CachedResponse{}, false
+	}
+	return resp, true
+}
+
+// freshUntil returns the time until which the response is fresh according
+// to the Cache-Control max-age or Expires headers.
+func freshUntil(h http.Header, cc map[string]string, now time.Time) time.Time {
+	if _, ok := cc["no-cache"]; ok {
+		return time.Time{}
+	}
+
+	if v, ok := cc["max-age"]; ok {
+		maxAge, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || maxAge <= 0 {
+			return time.Time{}
+		}
+		age, _ := strconv.ParseInt(h.Get("Age"), 10, 64)
+		if age >= maxAge {
+			return time.Time{}
+		}
+		return now.Add(time.Duration(maxAge-age) * time.Second)
+	}
+
+	if v := h.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil || !expires.After(now) {
+			return time.Time{}
+		}
+		return expires
+	}
+	return time.Time{}
+}
+
+// parseCacheControl returns Cache-Control directives with lowercase names.
+func parseCacheControl(v string) map[string]string {
+	cc := make(map[string]string)
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(part, "=")
+		cc[strings.ToLower(strings.TrimSpace(name))] =
+			strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return cc
+}
+
+// acceptEncoding is sent with requests that ask for compressed responses.
+// The responses are decompressed by decompressBody.
+const acceptEncoding = "gzip, deflate"
+
+// acceptEncoding returns the Accept-Encoding header of a request. Without
+// Discover, compressed responses are always asked for, as services that
+// don't compress ignore the header. With Discover, they are asked for only
+// if the service reports FeatureCompression. Requests made to fetch the
+// service info always ask for them.
+func (cli *ReverseHashCli) acceptEncoding(ctx context.Context) string {
+	if !cli.Discover || ctx.Value(fetchingInfoKey{}) != nil ||
+		cli.supports(ctx, FeatureCompression) {
+
+		return acceptEncoding
+	}
+	return "identity"
+}
+
+// decompressBody wraps the response body to decode its Content-Encoding.
+// The received counter, if not nil, counts bytes as they come over the
+// wire.
+func decompressBody(resp *http.Response, received *int64) error {
+	raw := resp.Body
+	var r io.Reader = &byteCounter{r: raw, n: received}
+
+	encoding := strings.ToLower(resp.Header.Get("Content-Encoding"))
+	if resp.StatusCode == http.StatusNotModified ||
+		resp.StatusCode == http.StatusNoContent || resp.ContentLength == 0 ||
+		(resp.Request != nil && resp.Request.Method == http.MethodHead) {
+
+		// no body to decode; the Content-Length of a HEAD response is the
+		// one of the GET response
+		encoding = ""
+	}
+
+	switch encoding {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		r = zr
+	case "deflate":
+		// the deflate encoding is zlib-wrapped, but some servers send raw
+		// deflate data
+		br := bufio.NewReader(r)
+		header, err := br.Peek(2)
+		if err == nil && isZlibHeader(header) {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return err
+			}
+			r = zr
+		} else {
+			r = flate.NewReader(br)
+		}
+	default:
+		return fmt.Errorf("unsupported content encoding: %v",
+			resp.Header.Get("Content-Encoding"))
+	}
+
+	resp.Body = readCloser{Reader: r, Closer: raw}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	return nil
+}
+
+func isZlibHeader(b []byte) bool {
+	return b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
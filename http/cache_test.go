@@ -0,0 +1,236 @@
+package http
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+	"github.com/iden3/merkletree-proof/internal/testtree"
+	mpmemory "github.com/iden3/merkletree-proof/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReverseHashCli_Cache(t *testing.T) {
+	ctx := context.Background()
+	mt := testtree.Build(t, 1, 5, 7)
+	store, err := mpmemory.NewReverseHashCliFromTree(ctx, mt)
+	require.NoError(t, err)
+	h, err := NewHandler(store)
+	require.NoError(t, err)
+
+	var requests, notModified int32
+	cacheControl := ""
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			if cacheControl != "" {
+				w = &headerOverride{ResponseWriter: w,
+					key: "Cache-Control", value: cacheControl}
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code == http.StatusNotModified {
+				atomic.AddInt32(&notModified, 1)
+			}
+			for k, v := range rec.Header() {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(rec.Code)
+			_, _ = w.Write(rec.Body.Bytes())
+		}))
+	defer srv.Close()
+
+	cache := NewMemoryResponseCache(100)
+	cli, err := NewReverseHashCli(srv.URL, WithResponseCache(cache))
+	require.NoError(t, err)
+
+	// fresh responses are served from the cache
+	n1, err := cli.GetNode(ctx, mt.Root())
+	require.NoError(t, err)
+	n2, err := cli.GetNode(ctx, mt.Root())
+	require.NoError(t, err)
+	require.Equal(t, n1, n2)
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	require.Equal(t, 1, cache.Len())
+
+	// missing nodes are not cached
+	missing, err := merkletree.NewHashFromBigInt(big.NewInt(100))
+	require.NoError(t, err)
+	_, err = cli.GetNode(ctx, missing)
+	require.ErrorIs(t, err, abicsr.ErrNodeNotFound)
+	require.Equal(t, 1, cache.Len())
+
+	// responses that must be revalidated are requested with If-None-Match
+	cacheControl = "no-cache"
+	cache = NewMemoryResponseCache(100)
+	cli.Cache = cache
+	atomic.StoreInt32(&requests, 0)
+	n1, err = cli.GetNode(ctx, mt.Root())
+	require.NoError(t, err)
+	n2, err = cli.GetNode(ctx, mt.Root())
+	require.NoError(t, err)
+	require.Equal(t, n1, n2)
+	require.Equal(t, int32(2), atomic.LoadInt32(&requests))
+	require.Equal(t, int32(1), atomic.LoadInt32(&notModified))
+
+	// no-store responses are not cached
+	cacheControl = "no-store"
+	cache = NewMemoryResponseCache(100)
+	cli.Cache = cache
+	_, err = cli.GetNode(ctx, mt.Root())
+	require.NoError(t, err)
+	require.Equal(t, 0, cache.Len())
+}
+
+type headerOverride struct {
+	http.ResponseWriter
+	key, value string
+}
+
+func (w *headerOverride) WriteHeader(code int) {
+	w.ResponseWriter.Header().Set(w.key, w.value)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func TestReverseHashCli_Compression(t *testing.T) {
+	ctx := context.Background()
+	mt := testtree.Build(t, 1, 5)
+	store, err := mpmemory.NewReverseHashCliFromTree(ctx, mt)
+	require.NoError(t, err)
+	h, err := NewHandler(store)
+	require.NoError(t, err)
+
+	compressors := map[string]func(w io.Writer) io.WriteCloser{
+		"gzip": func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		"deflate": func(w io.Writer) io.WriteCloser {
+			return zlib.NewWriter(w)
+		},
+		"raw deflate": func(w io.Writer) io.WriteCloser {
+			fw, err := flate.NewWriter(w, flate.DefaultCompression)
+			require.NoError(t, err)
+			return fw
+		},
+	}
+
+	for name, compress := range compressors {
+		t.Run(name, func(t *testing.T) {
+			var gotEncoding string
+			srv := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					gotEncoding = r.Header.Get("Accept-Encoding")
+					rec := httptest.NewRecorder()
+					h.ServeHTTP(rec, r)
+					var buf bytes.Buffer
+					cw := compress(&buf)
+					_, _ = cw.Write(rec.Body.Bytes())
+					_ = cw.Close()
+					if name == "raw deflate" {
+						w.Header().Set("Content-Encoding", "deflate")
+					} else {
+						w.Header().Set("Content-Encoding", name)
+					}
+					w.WriteHeader(rec.Code)
+					_, _ = w.Write(buf.Bytes())
+				}))
+			defer srv.Close()
+
+			var received int64
+			cli := &ReverseHashCli{URL: srv.URL,
+				Observer: merkletree_proof.ObserverFuncs{
+					Call: func(_ context.Context,
+						info merkletree_proof.CallInfo) {
+						received = info.BytesReceived
+					},
+				}}
+			n, err := cli.GetNode(ctx, mt.Root())
+			require.NoError(t, err)
+			require.Equal(t, mt.Root(), n.Hash)
+			require.Equal(t, "gzip, deflate", gotEncoding)
+			require.Positive(t, received)
+
+			nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+			require.NoError(t, err)
+			require.NoError(t, cli.SaveNodes(ctx, nodes))
+		})
+	}
+}
+
+func TestCachedResponse(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	body := []byte("{}")
+
+	testCases := []struct {
+		title       string
+		header      http.Header
+		wantOK      bool
+		wantExpires time.Time
+	}{
+		{
+			title: "max-age with age",
+			header: http.Header{"Cache-Control": {"public, max-age=60"},
+				"Age": {"20"}},
+			wantOK:      true,
+			wantExpires: now.Add(40 * time.Second),
+		},
+		{
+			title: "expires",
+			header: http.Header{"Expires": {
+				now.Add(time.Hour).Format(http.TimeFormat)}},
+			wantOK:      true,
+			wantExpires: now.Add(time.Hour),
+		},
+		{
+			title: "no-cache with etag",
+			header: http.Header{"Cache-Control": {"no-cache, max-age=60"},
+				"Etag": {`"x"`}},
+			wantOK: true,
+		},
+		{
+			title:  "no-store",
+			header: http.Header{"Cache-Control": {"no-store"}, "Etag": {`"x"`}},
+		},
+		{
+			title:  "no validators",
+			header: http.Header{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			resp, ok := cachedResponse(tc.header, body, now)
+			require.Equal(t, tc.wantOK, ok)
+			if ok {
+				require.Equal(t, tc.wantExpires, resp.Expires)
+				require.Equal(t, body, resp.Body)
+			}
+		})
+	}
+}
+
+func TestMemoryResponseCache(t *testing.T) {
+	c := NewMemoryResponseCache(2)
+	c.Set("a", CachedResponse{ETag: "a"})
+	c.Set("b", CachedResponse{ETag: "b"})
+	_, ok := c.Get("a")
+	require.True(t, ok)
+	c.Set("c", CachedResponse{ETag: "c"})
+
+	require.Equal(t, 2, c.Len())
+	_, ok = c.Get("b")
+	require.False(t, ok)
+	resp, ok := c.Get("a")
+	require.True(t, ok)
+	require.Equal(t, "a", resp.ETag)
+}
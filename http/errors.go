@@ -0,0 +1,89 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/iden3/go-merkletree-sql/v2"
+)
+
+// maxErrorBodySize limits how much of an error response body is read.
+const maxErrorBodySize = 64 << 10
+
+// ResponseError is returned by ReverseHashCli when the service responds with
+// an unexpected HTTP status or an unexpected RHS status. Use errors.As to
+// tell apart, for example, authentication failures (401, 403), rejected
+// nodes (400) and outages (5xx).
+type ResponseError struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	// Status is the "status" field of the RHS response, if the response
+	// body is an RHS JSON response.
+	Status string
+	// Message is the "error" field of the RHS response, or the beginning
+	// of the response body if it is not an RHS JSON response.
+	Message string
+	Method  string
+	URL     string
+	// Hash is the requested node hash for GetNode and nil for SaveNodes.
+	Hash *merkletree.Hash
+	// RetryAfter is the delay from the Retry-After header, or 0.
+	RetryAfter time.Duration
+}
+
+func (e *ResponseError) Error() string {
+	var s string
+	if e.StatusCode == http.StatusOK {
+		s = fmt.Sprintf("unexpected RHS response status: %s", e.Status)
+	} else {
+		s = fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+	}
+	if e.Message != "" {
+		s += ": " + e.Message
+	}
+	return s
+}
+
+// newResponseError builds a ResponseError from the response, reading the
+// rest of its body from body.
+func newResponseError(resp *http.Response, body io.Reader,
+	hash *merkletree.Hash) *ResponseError {
+
+	e := &ResponseError{
+		StatusCode: resp.StatusCode,
+		Hash:       hash,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+	if resp.Request != nil {
+		e.Method = resp.Request.Method
+		e.URL = resp.Request.URL.String()
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, maxErrorBodySize))
+	if err != nil && len(data) == 0 {
+		return e
+	}
+	e.setBody(data)
+	return e
+}
+
+// setBody fills Status and Message from the response body.
+func (e *ResponseError) setBody(data []byte) {
+	var resp statusResponse
+	if json.Unmarshal(data, &resp) == nil && resp.Status != "" {
+		e.Status = resp.Status
+		e.Message = resp.Error
+		return
+	}
+
+	msg := strings.TrimSpace(string(data))
+	const maxMessageLen = 512
+	if len(msg) > maxMessageLen {
+		msg = msg[:maxMessageLen] + "..."
+	}
+	e.Message = msg
+}
@@ -0,0 +1,121 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/iden3/go-merkletree-sql/v2"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseError(t *testing.T) {
+	ctx := context.Background()
+	hash := &merkletree.HashZero
+	testCases := []struct {
+		title   string
+		handler http.HandlerFunc
+		save    bool
+		want    ResponseError
+		wantMsg string
+	}{
+		{
+			title: "RHS error",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				writeError(w, http.StatusUnauthorized,
+					errors.New("invalid token"))
+			},
+			want: ResponseError{
+				StatusCode: http.StatusUnauthorized,
+				Status:     statusError,
+				Message:    "invalid token",
+				Method:     http.MethodGet,
+				Hash:       hash,
+			},
+			wantMsg: "unexpected status code: 401: invalid token",
+		},
+		{
+			title: "proxy error",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Retry-After", "30")
+				w.WriteHeader(http.StatusBadGateway)
+				_, _ = w.Write([]byte("<html>Bad Gateway</html>\n"))
+			},
+			want: ResponseError{
+				StatusCode: http.StatusBadGateway,
+				Message:    "<html>Bad Gateway</html>",
+				Method:     http.MethodGet,
+				Hash:       hash,
+				RetryAfter: 30e9,
+			},
+			wantMsg: "unexpected status code: 502: <html>Bad Gateway</html>",
+		},
+		{
+			title: "not found without RHS status",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				http.NotFound(w, nil)
+			},
+			want: ResponseError{
+				StatusCode: http.StatusNotFound,
+				Message:    "404 page not found",
+				Method:     http.MethodGet,
+				Hash:       hash,
+			},
+			wantMsg: "unexpected status code: 404: 404 page not found",
+		},
+		{
+			title: "rejected nodes",
+			save:  true,
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				writeError(w, http.StatusBadRequest,
+					errors.New("invalid node #0"))
+			},
+			want: ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Status:     statusError,
+				Message:    "invalid node #0",
+				Method:     http.MethodPost,
+			},
+			wantMsg: "unexpected status code: 400: invalid node #0",
+		},
+		{
+			title: "unexpected RHS status",
+			save:  true,
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				writeJSON(w, http.StatusOK,
+					statusResponse{Status: "pending"})
+			},
+			want: ResponseError{
+				StatusCode: http.StatusOK,
+				Status:     "pending",
+				Method:     http.MethodPost,
+			},
+			wantMsg: "unexpected RHS response status: pending",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			srv := httptest.NewServer(tc.handler)
+			defer srv.Close()
+			cli := &ReverseHashCli{URL: srv.URL}
+
+			var err error
+			if tc.save {
+				err = cli.SaveNodes(ctx, []merkletree_proof.Node{})
+				tc.want.URL = srv.URL + "/node"
+			} else {
+				_, err = cli.GetNode(ctx, hash)
+				tc.want.URL = srv.URL + "/node/" + hash.Hex()
+			}
+
+			var respErr *ResponseError
+			require.ErrorAs(t, err, &respErr)
+			require.Equal(t, tc.want, *respErr)
+			require.EqualError(t, err, tc.wantMsg)
+		})
+	}
+}
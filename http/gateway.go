@@ -0,0 +1,205 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+)
+
+// defaultUpstreamTimeout is the default timeout of upstream requests for
+// missing nodes.
+const defaultUpstreamTimeout = 30 * time.Second
+
+type gatewayConfig struct {
+	forwardTo       merkletree_proof.NodeWriter
+	upstreamTimeout time.Duration
+	handler         []HandlerOption
+}
+
+type GatewayOption func(cfg *gatewayConfig) error
+
+// WithForwardWrites makes the gateway accept POST /node requests. Nodes are
+// saved to the upstream writer first and cached locally only if the
+// upstream accepted them. Without this option the gateway is read-only.
+func WithForwardWrites(upstream merkletree_proof.NodeWriter) GatewayOption {
+	return func(cfg *gatewayConfig) error {
+		if upstream == nil {
+			return errors.New("upstream writer is nil")
+		}
+		cfg.forwardTo = upstream
+		return nil
+	}
+}
+
+// WithUpstreamTimeout sets the timeout of fetching a missing node from the
+// upstream reader. The fetch is shared by all requests for the node and is
+// not canceled when they are. The default is 30 seconds.
+func WithUpstreamTimeout(timeout time.Duration) GatewayOption {
+	return func(cfg *gatewayConfig) error {
+		if timeout <= 0 {
+			return errors.New("upstream timeout must be positive")
+		}
+		cfg.upstreamTimeout = timeout
+		return nil
+	}
+}
+
+// WithGatewayHandlerOptions passes options to the underlying Handler.
+func WithGatewayHandlerOptions(opts ...HandlerOption) GatewayOption {
+	return func(cfg *gatewayConfig) error {
+		cfg.handler = append(cfg.handler, opts...)
+		return nil
+	}
+}
+
+// NewGateway returns a read-through caching Handler. Nodes are served from
+// the local store. Missing nodes are fetched from the upstream reader, for
+// example *ReverseHashCli or *eth.ReverseHashCli, checked against their
+// hashes and cached in the local store.
+func NewGateway(local NodeStore, upstream merkletree_proof.NodeReader,
+	opts ...GatewayOption) (*Handler, error) {
+
+	if local == nil {
+		return nil, errors.New("local node store is nil")
+	}
+	if upstream == nil {
+		return nil, errors.New("upstream node reader is nil")
+	}
+
+	cfg := gatewayConfig{upstreamTimeout: defaultUpstreamTimeout}
+	for _, o := range opts {
+		err := o(&cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	store := &gatewayStore{
+		local:           local,
+		upstream:        upstream,
+		forwardTo:       cfg.forwardTo,
+		upstreamTimeout: cfg.upstreamTimeout,
+		inflight:        make(map[merkletree.Hash]*inflightGet),
+	}
+	h, err := NewHandler(store, cfg.handler...)
+	if err != nil {
+		return nil, err
+	}
+	h.readOnly = cfg.forwardTo == nil
+	return h, nil
+}
+
+type gatewayStore struct {
+	local           NodeStore
+	upstream        merkletree_proof.NodeReader
+	forwardTo       merkletree_proof.NodeWriter
+	upstreamTimeout time.Duration
+
+	mu       sync.Mutex
+	inflight map[merkletree.Hash]*inflightGet
+}
+
+// inflightGet lets concurrent requests for the same missing node share one
+// upstream request.
+type inflightGet struct {
+	done chan struct{}
+	node merkletree_proof.Node
+	err  error
+}
+
+func (s *gatewayStore) GetNode(ctx context.Context,
+	hash *merkletree.Hash) (merkletree_proof.Node, error) {
+
+	n, err := s.local.GetNode(ctx, hash)
+	if !errors.Is(err, abicsr.ErrNodeNotFound) {
+		return n, err
+	}
+
+	s.mu.Lock()
+	req, ok := s.inflight[*hash]
+	if !ok {
+		req = &inflightGet{done: make(chan struct{})}
+		s.inflight[*hash] = req
+		go s.fetchShared(ctx, *hash, req)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-req.done:
+		return req.node, req.err
+	case <-ctx.Done():
+		return merkletree_proof.Node{}, ctx.Err()
+	}
+}
+
+// fetchShared fetches the node for all requests waiting for it. It runs
+// under a context detached from the request that started it, so that
+// request going away does not fail the others.
+func (s *gatewayStore) fetchShared(ctx context.Context, hash merkletree.Hash,
+	req *inflightGet) {
+
+	ctx, cancel := context.WithTimeout(detachedContext{ctx},
+		s.upstreamTimeout)
+	defer cancel()
+
+	req.node, req.err = s.fetch(ctx, &hash)
+	s.mu.Lock()
+	delete(s.inflight, hash)
+	s.mu.Unlock()
+	close(req.done)
+}
+
+// detachedContext keeps the values of the context but not its deadline and
+// cancellation.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (s *gatewayStore) fetch(ctx context.Context,
+	hash *merkletree.Hash) (merkletree_proof.Node, error) {
+
+	n, err := s.upstream.GetNode(ctx, hash)
+	if err != nil {
+		return merkletree_proof.Node{}, err
+	}
+
+	if n.Hash == nil || !n.Hash.Equals(hash) {
+		return merkletree_proof.Node{}, fmt.Errorf(
+			"upstream returned wrong node for %v", hash.Hex())
+	}
+	err = n.Validate()
+	if err != nil {
+		return merkletree_proof.Node{}, fmt.Errorf(
+			"upstream returned invalid node: %w", err)
+	}
+
+	err = s.local.SaveNodes(ctx, []merkletree_proof.Node{n})
+	if err != nil {
+		return merkletree_proof.Node{}, fmt.Errorf(
+			"failed to cache node: %w", err)
+	}
+	return n, nil
+}
+
+func (s *gatewayStore) SaveNodes(ctx context.Context,
+	nodes []merkletree_proof.Node) error {
+
+	if s.forwardTo == nil {
+		return errors.New("gateway is read-only")
+	}
+	err := s.forwardTo.SaveNodes(ctx, nodes)
+	if err != nil {
+		return fmt.Errorf("failed to forward nodes upstream: %w", err)
+	}
+	return s.local.SaveNodes(ctx, nodes)
+}
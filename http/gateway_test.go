@@ -0,0 +1,176 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+	"github.com/iden3/merkletree-proof/internal/testrhs"
+	"github.com/iden3/merkletree-proof/internal/testtree"
+	mpmemory "github.com/iden3/merkletree-proof/memory"
+	"github.com/stretchr/testify/require"
+)
+
+type tamperingReader struct {
+	merkletree_proof.NodeReader
+}
+
+func (r tamperingReader) GetNode(ctx context.Context,
+	hash *merkletree.Hash) (merkletree_proof.Node, error) {
+
+	n, err := r.NodeReader.GetNode(ctx, hash)
+	if err == nil {
+		n.Children[0], n.Children[1] = n.Children[1], n.Children[0]
+	}
+	return n, err
+}
+
+func TestGateway(t *testing.T) {
+	ctx := context.Background()
+	mt := testtree.Build(t, 1, 5, 7, 100, 12345)
+	upstream, err := mpmemory.NewReverseHashCliFromTree(ctx, mt)
+	require.NoError(t, err)
+	reader := &testrhs.CountingReader{NodeReader: upstream}
+	local := mpmemory.NewReverseHashCli()
+
+	h, err := NewGateway(local, reader)
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	cli := &ReverseHashCli{URL: srv.URL}
+
+	var wg sync.WaitGroup
+	results := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, err := cli.GetNode(ctx, mt.Root())
+			if err == nil && !n.Hash.Equals(mt.Root()) {
+				err = fmt.Errorf("got node %v", n.Hash.Hex())
+			}
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+	for err := range results {
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1, reader.Calls())
+	require.Equal(t, 1, local.Len())
+
+	calls := reader.Calls()
+	_, err = cli.GetNode(ctx, mt.Root())
+	require.NoError(t, err)
+	require.Equal(t, calls, reader.Calls())
+
+	_, err = cli.GetNode(ctx, &merkletree.HashZero)
+	require.ErrorIs(t, err, abicsr.ErrNodeNotFound)
+
+	err = cli.SaveNodes(ctx, nil)
+	require.EqualError(t, err,
+		"unexpected status code: 405: method not allowed")
+}
+
+// gatedReader blocks GetNode calls until release is closed and reports
+// their start on started.
+type gatedReader struct {
+	merkletree_proof.NodeReader
+	started chan struct{}
+	release chan struct{}
+}
+
+func (r gatedReader) GetNode(ctx context.Context,
+	hash *merkletree.Hash) (merkletree_proof.Node, error) {
+
+	r.started <- struct{}{}
+	select {
+	case <-r.release:
+	case <-ctx.Done():
+		return merkletree_proof.Node{}, ctx.Err()
+	}
+	return r.NodeReader.GetNode(ctx, hash)
+}
+
+func TestGateway_FirstRequestCanceled(t *testing.T) {
+	ctx := context.Background()
+	mt := testtree.Build(t, 1, 5)
+	upstream, err := mpmemory.NewReverseHashCliFromTree(ctx, mt)
+	require.NoError(t, err)
+	gate := gatedReader{NodeReader: upstream,
+		started: make(chan struct{}, 1), release: make(chan struct{})}
+	reader := &testrhs.CountingReader{NodeReader: gate}
+
+	h, err := NewGateway(mpmemory.NewReverseHashCli(), reader)
+	require.NoError(t, err)
+	store := h.store
+
+	firstCtx, cancel := context.WithCancel(ctx)
+	first := make(chan error, 1)
+	go func() {
+		_, err := store.GetNode(firstCtx, mt.Root())
+		first <- err
+	}()
+	<-gate.started
+
+	others := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, err := store.GetNode(ctx, mt.Root())
+			others <- err
+		}()
+	}
+
+	// the first request goes away while the upstream request is in flight
+	cancel()
+	require.ErrorIs(t, <-first, context.Canceled)
+	close(gate.release)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, <-others)
+	}
+	require.Equal(t, 1, reader.Calls())
+}
+
+func TestGateway_InvalidUpstreamNode(t *testing.T) {
+	ctx := context.Background()
+	mt := testtree.Build(t, 1, 5)
+	upstream, err := mpmemory.NewReverseHashCliFromTree(ctx, mt)
+	require.NoError(t, err)
+	local := mpmemory.NewReverseHashCli()
+
+	h, err := NewGateway(local, tamperingReader{upstream})
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	cli := &ReverseHashCli{URL: srv.URL}
+
+	_, err = cli.GetNode(ctx, mt.Root())
+	require.Error(t, err)
+	require.Equal(t, 0, local.Len())
+}
+
+func TestGateway_ForwardWrites(t *testing.T) {
+	ctx := context.Background()
+	upstream := mpmemory.NewReverseHashCli()
+	local := mpmemory.NewReverseHashCli()
+
+	h, err := NewGateway(local, upstream, WithForwardWrites(upstream))
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	cli := &ReverseHashCli{URL: srv.URL}
+
+	mt := testtree.Build(t, 1, 5)
+	nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+	require.NoError(t, cli.SaveNodes(ctx, nodes))
+	require.Equal(t, len(nodes), upstream.Len())
+	require.Equal(t, len(nodes), local.Len())
+}
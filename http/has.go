@@ -0,0 +1,254 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/iden3/go-merkletree-sql/v2"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+)
+
+// hasNodesBatchSize is the max number of hashes checked with one POST /has
+// request. The request body takes about 70 bytes per hash.
+const hasNodesBatchSize = 1000
+
+var _ merkletree_proof.NodeChecker = (*ReverseHashCli)(nil)
+
+type hasNodesResponse struct {
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Present []bool `json:"present,omitempty"`
+}
+
+// hasNodes responds with the presence bitmap of the nodes whose hashes are
+// sent as a JSON array of hex strings.
+func (h *Handler) hasNodes(w http.ResponseWriter, r *http.Request) {
+	var hexes []string
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, h.maxBodySize))
+	err := dec.Decode(&hexes)
+	if err != nil {
+		writeError(w, http.StatusBadRequest,
+			fmt.Errorf("can't decode hashes: %w", err))
+		return
+	}
+
+	hashes := make([]*merkletree.Hash, len(hexes))
+	for i, s := range hexes {
+		hashes[i], err = merkletree.NewHashFromHex(s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest,
+				fmt.Errorf("invalid hash #%v: %w", i, err))
+			return
+		}
+	}
+
+	present, err := merkletree_proof.HasNodes(r.Context(), h.store, hashes)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK,
+		hasNodesResponse{Status: statusOK, Present: present})
+}
+
+// HasNodes returns a presence bitmap of the nodes: the i-th value reports
+// whether the service stores the node with hashes[i]. Nodes are checked in
+// batches with POST /has requests, without transferring them. Services
+// without that endpoint, or not reporting FeatureHasNodes when Discover is
+// set, are sent a HEAD request per node. Nodes with fresh cached responses
+// are present without a request.
+func (cli *ReverseHashCli) HasNodes(ctx context.Context,
+	hashes []*merkletree.Hash) ([]bool, error) {
+
+	observer := cli.observer()
+	if observer == nil {
+		return cli.hasNodes(ctx, hashes, nil, nil)
+	}
+
+	start := time.Now()
+	var sent, received int64
+	present, err := cli.hasNodes(ctx, hashes, &sent, &received)
+	observer.ObserveCall(ctx, merkletree_proof.CallInfo{
+		Backend:       backendName,
+		Op:            merkletree_proof.OpHasNodes,
+		Nodes:         len(hashes),
+		Duration:      time.Since(start),
+		BytesSent:     sent,
+		BytesReceived: received,
+		Err:           err,
+	})
+	return present, err
+}
+
+func (cli *ReverseHashCli) hasNodes(ctx context.Context,
+	hashes []*merkletree.Hash, sent, received *int64) ([]bool, error) {
+
+	if cli.URL == "" {
+		return nil, errors.New("HTTP reverse hash service url is not specified")
+	}
+	for _, h := range hashes {
+		if h == nil {
+			return nil, errors.New("hash is nil")
+		}
+	}
+
+	present := make([]bool, len(hashes))
+	batch := !cli.Discover || cli.supports(ctx, FeatureHasNodes)
+	for start := 0; start < len(hashes); start += hasNodesBatchSize {
+		end := start + hasNodesBatchSize
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+
+		if batch {
+			var p []bool
+			err := cli.Retry.do(ctx, func() error {
+				var err error
+				p, err = cli.hasNodesOnce(ctx, hashes[start:end], sent,
+					received)
+				return err
+			})
+			var respErr *ResponseError
+			switch {
+			case err == nil:
+				copy(present[start:end], p)
+				continue
+			case errors.As(err, &respErr) &&
+				(respErr.StatusCode == http.StatusNotFound ||
+					respErr.StatusCode == http.StatusMethodNotAllowed):
+				// an RHS without the batch endpoint
+				batch = false
+			default:
+				return nil, err
+			}
+		}
+
+		for i := start; i < end; i++ {
+			err := cli.Retry.do(ctx, func() error {
+				var err error
+				present[i], err = cli.headNode(ctx, hashes[i], received)
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return present, nil
+}
+
+func (cli *ReverseHashCli) hasNodesOnce(ctx context.Context,
+	hashes []*merkletree.Hash, sent, received *int64) ([]bool, error) {
+
+	hexes := make([]string, len(hashes))
+	for i, h := range hashes {
+		hexes[i] = h.Hex()
+	}
+	reqBytes, err := json.Marshal(hexes)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := cli.Limiter.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cli.getHttpTimeout())
+		defer cancel()
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		cli.baseURL()+"/has", bytes.NewReader(reqBytes))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := cli.do(ctx, httpReq, received)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = httpResp.Body.Close() }()
+	if sent != nil {
+		*sent += int64(len(reqBytes))
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, newResponseError(httpResp, httpResp.Body, nil)
+	}
+
+	var resp hasNodesResponse
+	err = json.NewDecoder(httpResp.Body).Decode(&resp)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode RHS response: %w", err)
+	}
+	if resp.Status != statusOK {
+		return nil, &ResponseError{
+			StatusCode: httpResp.StatusCode,
+			Status:     resp.Status,
+			Message:    resp.Error,
+			Method:     httpReq.Method,
+			URL:        httpReq.URL.String(),
+		}
+	}
+	if len(resp.Present) != len(hashes) {
+		return nil, fmt.Errorf(
+			"RHS returned presence of %v nodes, requested %v",
+			len(resp.Present), len(hashes))
+	}
+	return resp.Present, nil
+}
+
+// headNode checks if the node exists with a HEAD request.
+func (cli *ReverseHashCli) headNode(ctx context.Context,
+	hash *merkletree.Hash, received *int64) (bool, error) {
+
+	nodeURL := cli.nodeURL(hash)
+	if cli.Cache != nil {
+		cached, ok := cli.Cache.Get(nodeURL)
+		if ok && time.Now().Before(cached.Expires) {
+			return true, nil
+		}
+	}
+
+	release, err := cli.Limiter.Acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cli.getHttpTimeout())
+		defer cancel()
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodHead, nodeURL,
+		http.NoBody)
+	if err != nil {
+		return false, err
+	}
+	httpResp, err := cli.do(ctx, httpReq, received)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = httpResp.Body.Close() }()
+
+	switch httpResp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, newResponseError(httpResp, httpResp.Body, hash)
+	}
+}
@@ -0,0 +1,154 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/iden3/go-merkletree-sql/v2"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+	"github.com/iden3/merkletree-proof/internal/testtree"
+	mpmemory "github.com/iden3/merkletree-proof/memory"
+	"github.com/stretchr/testify/require"
+)
+
+// methodCounter counts requests by method and path.
+type methodCounter struct {
+	handler http.Handler
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (c *methodCounter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	if c.counts == nil {
+		c.counts = make(map[string]int)
+	}
+	path := r.URL.Path
+	if r.Method == http.MethodHead {
+		path = "/node/{hash}"
+	}
+	c.counts[r.Method+" "+path]++
+	c.mu.Unlock()
+	c.handler.ServeHTTP(w, r)
+}
+
+func (c *methodCounter) count(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[key]
+}
+
+func TestReverseHashCli_HasNodes(t *testing.T) {
+	ctx := context.Background()
+	store := mpmemory.NewReverseHashCli()
+	mt := testtree.Build(t, 1, 2, 3)
+	nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.SaveNodes(ctx, nodes[:2]))
+
+	h, err := NewHandler(store)
+	require.NoError(t, err)
+	counter := &methodCounter{handler: h}
+	srv := httptest.NewServer(counter)
+	defer srv.Close()
+
+	var calls []merkletree_proof.CallInfo
+	cli := &ReverseHashCli{URL: srv.URL, Observer: merkletree_proof.ObserverFuncs{
+		Call: func(_ context.Context, info merkletree_proof.CallInfo) {
+			calls = append(calls, info)
+		},
+	}}
+	hashes := []*merkletree.Hash{nodes[0].Hash, nodes[2].Hash, nodes[1].Hash}
+	present, err := cli.HasNodes(ctx, hashes)
+	require.NoError(t, err)
+	require.Equal(t, []bool{true, false, true}, present)
+	require.Equal(t, 1, counter.count("POST /has"))
+	require.Len(t, calls, 1)
+	require.Equal(t, merkletree_proof.OpHasNodes, calls[0].Op)
+	require.Equal(t, 3, calls[0].Nodes)
+	require.Positive(t, calls[0].BytesSent)
+
+	// large requests are split into batches
+	many := make([]*merkletree.Hash, hasNodesBatchSize+1)
+	for i := range many {
+		many[i] = nodes[i%len(nodes)].Hash
+	}
+	present, err = cli.HasNodes(ctx, many)
+	require.NoError(t, err)
+	require.Len(t, present, len(many))
+	for i := range many {
+		require.Equal(t, i%len(nodes) < 2, present[i])
+	}
+	require.Equal(t, 3, counter.count("POST /has"))
+
+	_, err = cli.HasNodes(ctx, []*merkletree.Hash{nil})
+	require.Error(t, err)
+}
+
+func TestReverseHashCli_HasNodesLegacy(t *testing.T) {
+	ctx := context.Background()
+	store := mpmemory.NewReverseHashCli()
+	mt := testtree.Build(t, 1, 2, 3)
+	nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.SaveNodes(ctx, nodes[:2]))
+
+	h, err := NewHandler(store)
+	require.NoError(t, err)
+	mux := http.NewServeMux()
+	mux.Handle("/node", h)
+	mux.Handle("/node/", h)
+	counter := &methodCounter{handler: mux}
+	srv := httptest.NewServer(counter)
+	defer srv.Close()
+
+	hashes := []*merkletree.Hash{nodes[0].Hash, nodes[2].Hash, nodes[1].Hash}
+	cli := &ReverseHashCli{URL: srv.URL}
+	present, err := cli.HasNodes(ctx, hashes)
+	require.NoError(t, err)
+	require.Equal(t, []bool{true, false, true}, present)
+	require.Equal(t, 1, counter.count("POST /has"))
+	require.Equal(t, 3, counter.count("HEAD /node/{hash}"))
+
+	// with discovery the batch endpoint is not tried
+	cli = &ReverseHashCli{URL: srv.URL, Discover: true}
+	present, err = cli.HasNodes(ctx, hashes)
+	require.NoError(t, err)
+	require.Equal(t, []bool{true, false, true}, present)
+	require.Equal(t, 1, counter.count("POST /has"))
+	require.Equal(t, 6, counter.count("HEAD /node/{hash}"))
+}
+
+func TestReverseHashCli_HasNodesCompressedHead(t *testing.T) {
+	ctx := context.Background()
+	store := mpmemory.NewReverseHashCli()
+	mt := testtree.Build(t, 1, 2, 3)
+	nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.SaveNodes(ctx, nodes[:1]))
+
+	h, err := NewHandler(store)
+	require.NoError(t, err)
+	// a compressing proxy without the batch endpoint
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodHead {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Set("Content-Length", "123")
+			h.ServeHTTP(w, r)
+		}))
+	defer srv.Close()
+
+	cli := &ReverseHashCli{URL: srv.URL}
+	present, err := cli.HasNodes(ctx,
+		[]*merkletree.Hash{nodes[0].Hash, nodes[1].Hash})
+	require.NoError(t, err)
+	require.Equal(t, []bool{true, false}, present)
+}
@@ -0,0 +1,250 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+)
+
+// APIVersion is the version of the protocol served by Handler.
+const APIVersion = "1"
+
+// Features a reverse hash service may report in ServiceInfo.
+const (
+	// FeatureConditionalGet means node responses carry ETag and
+	// Cache-Control headers and conditional requests are supported.
+	FeatureConditionalGet = "conditional-get"
+	// FeatureStreaming means the service accepts node streams at POST
+	// /nodes and exports subtrees at GET /nodes/{hash}.
+	FeatureStreaming = "ndjson-stream"
+	// FeatureHasNodes means the service checks which of a batch of nodes it
+	// stores at POST /has.
+	FeatureHasNodes = "has-nodes"
+	// FeatureSignedWrites means the service accepts only signed POST /node
+	// requests, see ReverseHashCli.Signer.
+	FeatureSignedWrites = "signed-writes"
+	// FeatureCompression means the service compresses responses with gzip
+	// if the request accepts it.
+	FeatureCompression = "compression"
+)
+
+// ServiceInfo describes a reverse hash service.
+type ServiceInfo struct {
+	Version  string   `json:"version"`
+	Features []string `json:"features"`
+	// MaxBodySize is the max size of a POST /node request body, or 0 if
+	// unknown.
+	MaxBodySize int64 `json:"max_body_size,omitempty"`
+	ReadOnly    bool  `json:"read_only,omitempty"`
+	// Legacy is true if the service does not serve GET /info, like older
+	// RHS versions. Such services support only the basic protocol.
+	Legacy bool `json:"-"`
+}
+
+// Supports reports whether the service reported the feature.
+func (i ServiceInfo) Supports(feature string) bool {
+	for _, f := range i.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+type infoResponse struct {
+	Status string `json:"status"`
+	ServiceInfo
+}
+
+// infoCache holds the last ServiceInfo fetched by ReverseHashCli.Info and
+// the fetch in flight, which concurrent calls share.
+type infoCache struct {
+	mu        sync.Mutex
+	info      ServiceInfo
+	fetchedAt time.Time
+	url       string
+	call      *infoCall
+}
+
+type infoCall struct {
+	done chan struct{}
+	url  string
+	info ServiceInfo
+	err  error
+}
+
+// infoCache returns the cache of the client, creating it on first use, so
+// that ReverseHashCli literals need no initialization and may be copied.
+// Copies made after the first use share the cache.
+func (cli *ReverseHashCli) infoCache() *infoCache {
+	if c, ok := cli.info.Load().(*infoCache); ok {
+		return c
+	}
+	cli.info.CompareAndSwap(nil, &infoCache{})
+	return cli.info.Load().(*infoCache)
+}
+
+// Ping checks that the service at URL answers the reverse hash service
+// protocol by requesting an empty node, which is never stored.
+func (cli *ReverseHashCli) Ping(ctx context.Context) error {
+	if cli.URL == "" {
+		return errors.New("HTTP reverse hash service url is not specified")
+	}
+	_, err := cli.getNodeOnce(ctx, &merkletree.HashZero, nil)
+	if err == nil || errors.Is(err, abicsr.ErrNodeNotFound) {
+		return nil
+	}
+	return fmt.Errorf("reverse hash service is not available: %w", err)
+}
+
+// Info returns the description of the service. The result is cached for
+// InfoTTL. Services that do not serve GET /info but answer Ping are
+// reported as Legacy. Concurrent calls share one request.
+func (cli *ReverseHashCli) Info(ctx context.Context) (ServiceInfo, error) {
+	c := cli.infoCache()
+	for {
+		c.mu.Lock()
+		if !c.fetchedAt.IsZero() && c.url == cli.URL &&
+			time.Since(c.fetchedAt) < cli.infoTTL() {
+
+			info := c.info
+			c.mu.Unlock()
+			return info, nil
+		}
+
+		call := c.call
+		if call == nil || call.url != cli.URL {
+			call = &infoCall{done: make(chan struct{}), url: cli.URL}
+			c.call = call
+			c.mu.Unlock()
+
+			call.info, call.err = cli.fetchInfo(ctx)
+			c.mu.Lock()
+			if call.err == nil {
+				c.info, c.fetchedAt, c.url = call.info, time.Now(), call.url
+			}
+			if c.call == call {
+				c.call = nil
+			}
+			c.mu.Unlock()
+			close(call.done)
+			return call.info, call.err
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return ServiceInfo{}, ctx.Err()
+		}
+		if isContextErr(call.err) && ctx.Err() == nil {
+			// the context of the call that made the request is done, not
+			// this one
+			continue
+		}
+		return call.info, call.err
+	}
+}
+
+// RefreshInfo drops the cached ServiceInfo and fetches it again.
+func (cli *ReverseHashCli) RefreshInfo(ctx context.Context) (ServiceInfo,
+	error) {
+
+	c := cli.infoCache()
+	c.mu.Lock()
+	c.fetchedAt = time.Time{}
+	c.mu.Unlock()
+	return cli.Info(ctx)
+}
+
+// fetchingInfoKey marks the context of requests made by fetchInfo, which
+// must not wait for the service info.
+type fetchingInfoKey struct{}
+
+func isContextErr(err error) bool {
+	return errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
+
+// supports reports whether the service supports the feature. Errors
+// fetching the service info are treated as no support.
+func (cli *ReverseHashCli) supports(ctx context.Context, feature string) bool {
+	info, err := cli.Info(ctx)
+	return err == nil && info.Supports(feature)
+}
+
+func (cli *ReverseHashCli) infoTTL() time.Duration {
+	if cli.InfoTTL == 0 {
+		return 5 * time.Minute
+	}
+	return cli.InfoTTL
+}
+
+func (cli *ReverseHashCli) fetchInfo(ctx context.Context) (ServiceInfo,
+	error) {
+
+	if cli.URL == "" {
+		return ServiceInfo{}, errors.New(
+			"HTTP reverse hash service url is not specified")
+	}
+
+	release, err := cli.Limiter.Acquire(ctx)
+	if err != nil {
+		return ServiceInfo{}, err
+	}
+	defer release()
+
+	ctx = context.WithValue(ctx, fetchingInfoKey{}, true)
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cli.getHttpTimeout())
+		defer cancel()
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		cli.baseURL()+"/info", http.NoBody)
+	if err != nil {
+		return ServiceInfo{}, err
+	}
+	httpResp, err := cli.do(ctx, httpReq, nil)
+	if err != nil {
+		return ServiceInfo{}, err
+	}
+	defer func() { _ = httpResp.Body.Close() }()
+
+	switch httpResp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		// an RHS without the info endpoint
+		release()
+		err = cli.Ping(ctx)
+		if err != nil {
+			return ServiceInfo{}, err
+		}
+		return ServiceInfo{Legacy: true}, nil
+	default:
+		return ServiceInfo{}, newResponseError(httpResp, httpResp.Body, nil)
+	}
+
+	var resp infoResponse
+	err = json.NewDecoder(httpResp.Body).Decode(&resp)
+	if err != nil {
+		return ServiceInfo{}, fmt.Errorf("unable to decode RHS info: %w", err)
+	}
+	if resp.Status != statusOK {
+		return ServiceInfo{}, &ResponseError{
+			StatusCode: httpResp.StatusCode,
+			Status:     resp.Status,
+			Method:     httpReq.Method,
+			URL:        httpReq.URL.String(),
+		}
+	}
+	return resp.ServiceInfo, nil
+}
@@ -0,0 +1,202 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	merkletree_proof "github.com/iden3/merkletree-proof"
+	"github.com/iden3/merkletree-proof/internal/testtree"
+	mpmemory "github.com/iden3/merkletree-proof/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReverseHashCli_Info(t *testing.T) {
+	ctx := context.Background()
+	h, err := NewHandler(mpmemory.NewReverseHashCli(), WithMaxBodySize(1000))
+	require.NoError(t, err)
+
+	var infoRequests int32
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/info" {
+				atomic.AddInt32(&infoRequests, 1)
+			}
+			h.ServeHTTP(w, r)
+		}))
+	defer srv.Close()
+
+	cli := &ReverseHashCli{URL: srv.URL}
+	require.NoError(t, cli.Ping(ctx))
+
+	info, err := cli.Info(ctx)
+	require.NoError(t, err)
+	require.Equal(t, ServiceInfo{
+		Version: APIVersion,
+		Features: []string{FeatureConditionalGet, FeatureHasNodes,
+			FeatureStreaming},
+		MaxBodySize: 1000,
+	}, info)
+	require.True(t, info.Supports(FeatureConditionalGet))
+	require.False(t, info.Supports(FeatureSignedWrites))
+
+	// the info is cached
+	_, err = cli.Info(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&infoRequests))
+	_, err = cli.RefreshInfo(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&infoRequests))
+}
+
+func TestReverseHashCli_InfoConcurrent(t *testing.T) {
+	ctx := context.Background()
+	h, err := NewHandler(mpmemory.NewReverseHashCli())
+	require.NoError(t, err)
+
+	var infoRequests int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/info" {
+				atomic.AddInt32(&infoRequests, 1)
+				<-release
+			}
+			h.ServeHTTP(w, r)
+		}))
+	defer srv.Close()
+
+	cli := &ReverseHashCli{URL: srv.URL}
+
+	// the first caller goes away while the request is in flight
+	firstCtx, cancel := context.WithCancel(ctx)
+	first := make(chan error, 1)
+	go func() {
+		_, err := cli.Info(firstCtx)
+		first <- err
+	}()
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&infoRequests) == 1
+	}, 5*time.Second, time.Millisecond)
+
+	others := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			info, err := cli.Info(ctx)
+			if err == nil && info.Version != APIVersion {
+				err = fmt.Errorf("unexpected info: %+v", info)
+			}
+			others <- err
+		}()
+	}
+	cancel()
+	require.ErrorIs(t, <-first, context.Canceled)
+	close(release)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, <-others)
+	}
+	// the canceled request is repeated once for all waiting callers
+	require.Equal(t, int32(2), atomic.LoadInt32(&infoRequests))
+
+	// copies share the cache
+	cp := *cli
+	_, err = cp.Info(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&infoRequests))
+}
+
+func TestReverseHashCli_InfoLegacy(t *testing.T) {
+	ctx := context.Background()
+	h, err := NewHandler(mpmemory.NewReverseHashCli())
+	require.NoError(t, err)
+	mux := http.NewServeMux()
+	mux.Handle("/node", h)
+	mux.Handle("/node/", h)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cli := &ReverseHashCli{URL: srv.URL}
+	info, err := cli.Info(ctx)
+	require.NoError(t, err)
+	require.Equal(t, ServiceInfo{Legacy: true}, info)
+
+	// not an RHS at all
+	other := httptest.NewServer(http.NotFoundHandler())
+	defer other.Close()
+	cli = &ReverseHashCli{URL: other.URL}
+	require.Error(t, cli.Ping(ctx))
+	_, err = cli.Info(ctx)
+	require.Error(t, err)
+}
+
+func TestReverseHashCli_DiscoverChunkSize(t *testing.T) {
+	ctx := context.Background()
+	store := mpmemory.NewReverseHashCli()
+	h, err := NewHandler(store, WithMaxBodySize(2000))
+	require.NoError(t, err)
+	srv := &uploadServer{handler: h}
+	httpSrv := httptest.NewServer(srv)
+	defer httpSrv.Close()
+
+	mt := testtree.Build(t, 1, 2, 3, 4, 5, 6, 7, 8)
+	nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+
+	// without discovery the body is too large
+	cli := &ReverseHashCli{URL: httpSrv.URL}
+	require.Error(t, cli.SaveNodes(ctx, nodes))
+
+	cli.Discover = true
+	require.NoError(t, cli.SaveNodes(ctx, nodes))
+	require.Equal(t, len(nodes), store.Len())
+	require.Equal(t, 1+(len(nodes)+5)/6, srv.posts)
+}
+
+func TestReverseHashCli_DiscoverCompression(t *testing.T) {
+	ctx := context.Background()
+	mt := testtree.Build(t, 1, 5)
+	store, err := mpmemory.NewReverseHashCliFromTree(ctx, mt)
+	require.NoError(t, err)
+
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compression %v", compress), func(t *testing.T) {
+			var opts []HandlerOption
+			if compress {
+				opts = append(opts, WithCompression())
+			}
+			h, err := NewHandler(store, opts...)
+			require.NoError(t, err)
+
+			var acceptEncoding, contentEncoding string
+			srv := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					h.ServeHTTP(w, r)
+					if r.URL.Path != "/info" {
+						acceptEncoding = r.Header.Get("Accept-Encoding")
+						contentEncoding = w.Header().Get("Content-Encoding")
+					}
+				}))
+			defer srv.Close()
+
+			cli := &ReverseHashCli{URL: srv.URL, Discover: true}
+			info, err := cli.Info(ctx)
+			require.NoError(t, err)
+			require.Equal(t, compress, info.Supports(FeatureCompression))
+
+			n, err := cli.GetNode(ctx, mt.Root())
+			require.NoError(t, err)
+			require.Equal(t, mt.Root(), n.Hash)
+			if compress {
+				require.Equal(t, "gzip, deflate", acceptEncoding)
+				require.Equal(t, "gzip", contentEncoding)
+			} else {
+				require.Equal(t, "identity", acceptEncoding)
+				require.Empty(t, contentEncoding)
+			}
+		})
+	}
+}
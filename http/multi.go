@@ -0,0 +1,502 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+)
+
+// Balancing is a strategy of choosing the endpoint of a
+// MultiReverseHashCli request among healthy endpoints.
+type Balancing byte
+
+const (
+	// BalanceRoundRobin sends requests to healthy endpoints in turn.
+	BalanceRoundRobin Balancing = iota
+	// BalanceLeastLatency sends requests to the healthy endpoint with the
+	// lowest average latency. Endpoints without measured latency are tried
+	// first.
+	BalanceLeastLatency
+	// BalancePriority sends requests to the first healthy endpoint in the
+	// order they were given.
+	BalancePriority
+	// BalanceRandom sends requests to a random healthy endpoint.
+	BalanceRandom
+)
+
+func (b Balancing) String() string {
+	switch b {
+	case BalanceRoundRobin:
+		return "round-robin"
+	case BalanceLeastLatency:
+		return "least-latency"
+	case BalancePriority:
+		return "priority"
+	case BalanceRandom:
+		return "random"
+	default:
+		return "unknown"
+	}
+}
+
+// EndpointStatus is a snapshot of the health of a MultiReverseHashCli
+// endpoint.
+type EndpointStatus struct {
+	URL     string
+	Healthy bool
+	// ConsecutiveFailures is the number of failed requests since the last
+	// successful one.
+	ConsecutiveFailures int
+	LastError           error
+	LastFailure         time.Time
+	// Latency is the moving average of successful request durations, or 0
+	// if no request succeeded yet.
+	Latency time.Duration
+	// InFlight is the number of requests being sent to the endpoint.
+	InFlight int
+}
+
+var _ merkletree_proof.ReverseHashCli = (*MultiReverseHashCli)(nil)
+var _ merkletree_proof.NodeChecker = (*MultiReverseHashCli)(nil)
+
+// MultiReverseHashCli is a client of several replicas of the reverse hash
+// service, which are expected to share storage. Each request goes to a
+// healthy endpoint chosen by the balancing strategy and fails over to the
+// next endpoint on transient errors (see IsRetryable). A missing node is a
+// definitive answer and is not retried on other endpoints.
+//
+// An endpoint becomes unhealthy after several consecutive failures and is
+// tried only after all healthy endpoints until the cooldown passes. GetNode
+// requests may be hedged: if an endpoint does not answer within the hedge
+// delay, the same request is sent to the next endpoint and the first node or
+// not found answer wins. Other errors are returned only after the hedged
+// requests in flight end without an answer. SaveNodes requests are never
+// hedged.
+type MultiReverseHashCli struct {
+	endpoints        []*endpoint
+	endpointOpts     []Option
+	balancing        Balancing
+	hedgeDelay       time.Duration
+	failureThreshold int
+	cooldown         time.Duration
+	isFailure        func(err error) bool
+	now              func() time.Time
+
+	mu   sync.Mutex
+	next int // round-robin counter
+}
+
+type endpoint struct {
+	cli *ReverseHashCli
+
+	// guarded by MultiReverseHashCli.mu
+	failures    int
+	lastErr     error
+	lastFailure time.Time
+	latency     time.Duration
+	inFlight    int
+}
+
+type MultiOption func(m *MultiReverseHashCli) error
+
+// WithEndpointOptions applies the options to the client of every endpoint,
+// for example to set authentication, retries or an observer.
+func WithEndpointOptions(opts ...Option) MultiOption {
+	return func(m *MultiReverseHashCli) error {
+		m.endpointOpts = append(m.endpointOpts, opts...)
+		return nil
+	}
+}
+
+// WithBalancing sets the balancing strategy. The default is
+// BalanceRoundRobin.
+func WithBalancing(balancing Balancing) MultiOption {
+	return func(m *MultiReverseHashCli) error {
+		if balancing > BalanceRandom {
+			return fmt.Errorf("unknown balancing strategy: %d", balancing)
+		}
+		m.balancing = balancing
+		return nil
+	}
+}
+
+// WithHedgeDelay enables hedged GetNode requests: if no answer comes within
+// the delay, the request is also sent to the next endpoint. Hedging is
+// disabled by default.
+func WithHedgeDelay(delay time.Duration) MultiOption {
+	return func(m *MultiReverseHashCli) error {
+		if delay < 0 {
+			return errors.New("hedge delay must not be negative")
+		}
+		m.hedgeDelay = delay
+		return nil
+	}
+}
+
+// WithUnhealthyThreshold sets the number of consecutive failures after
+// which an endpoint is unhealthy. The default is 3.
+func WithUnhealthyThreshold(n int) MultiOption {
+	return func(m *MultiReverseHashCli) error {
+		if n <= 0 {
+			return errors.New("unhealthy threshold must be positive")
+		}
+		m.failureThreshold = n
+		return nil
+	}
+}
+
+// WithUnhealthyCooldown sets how long an unhealthy endpoint is tried only as
+// a last resort. After the cooldown it gets requests again, and the first
+// success makes it healthy. The default is 30 seconds.
+func WithUnhealthyCooldown(cooldown time.Duration) MultiOption {
+	return func(m *MultiReverseHashCli) error {
+		if cooldown <= 0 {
+			return errors.New("cooldown must be positive")
+		}
+		m.cooldown = cooldown
+		return nil
+	}
+}
+
+// WithFailoverPredicate overrides which errors make a request fail over to
+// the next endpoint and count as endpoint failures. The default is
+// IsRetryable.
+func WithFailoverPredicate(isFailure func(err error) bool) MultiOption {
+	return func(m *MultiReverseHashCli) error {
+		if isFailure == nil {
+			return errors.New("failover predicate is nil")
+		}
+		m.isFailure = isFailure
+		return nil
+	}
+}
+
+// NewMultiReverseHashCli returns a client of the services at the urls.
+func NewMultiReverseHashCli(urls []string,
+	opts ...MultiOption) (*MultiReverseHashCli, error) {
+
+	if len(urls) == 0 {
+		return nil, errors.New(
+			"HTTP reverse hash service urls are not specified")
+	}
+
+	m := &MultiReverseHashCli{
+		balancing:        BalanceRoundRobin,
+		failureThreshold: 3,
+		cooldown:         30 * time.Second,
+		isFailure:        IsRetryable,
+		now:              time.Now,
+	}
+	for _, o := range opts {
+		err := o(m)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, url := range urls {
+		cli, err := NewReverseHashCli(url, m.endpointOpts...)
+		if err != nil {
+			return nil, err
+		}
+		m.endpoints = append(m.endpoints, &endpoint{cli: cli})
+	}
+	return m, nil
+}
+
+// Endpoints returns the health of the endpoints in the order they were
+// given.
+func (m *MultiReverseHashCli) Endpoints() []EndpointStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	statuses := make([]EndpointStatus, len(m.endpoints))
+	for i, ep := range m.endpoints {
+		statuses[i] = EndpointStatus{
+			URL:                 ep.cli.URL,
+			Healthy:             m.healthy(ep, now),
+			ConsecutiveFailures: ep.failures,
+			LastError:           ep.lastErr,
+			LastFailure:         ep.lastFailure,
+			Latency:             ep.latency,
+			InFlight:            ep.inFlight,
+		}
+	}
+	return statuses
+}
+
+// CheckHealth pings all endpoints at once, updates their health and returns
+// it. An endpoint that fails the ping becomes unhealthy at once. It may be
+// called periodically to detect failures and recoveries before requests
+// do.
+func (m *MultiReverseHashCli) CheckHealth(
+	ctx context.Context) []EndpointStatus {
+
+	var wg sync.WaitGroup
+	for _, ep := range m.endpoints {
+		wg.Add(1)
+		go func(ep *endpoint) {
+			defer wg.Done()
+			start := m.now()
+			err := ep.cli.Ping(ctx)
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if err == nil {
+				m.recordSuccess(ep, m.now().Sub(start))
+				return
+			}
+			m.recordFailure(ep, err)
+			if ep.failures < m.failureThreshold {
+				ep.failures = m.failureThreshold
+			}
+		}(ep)
+	}
+	wg.Wait()
+	return m.Endpoints()
+}
+
+// GenerateProof generates proof of existence or in-existence of a key in
+// a tree identified by a treeRoot.
+func (m *MultiReverseHashCli) GenerateProof(ctx context.Context,
+	treeRoot *merkletree.Hash,
+	key *merkletree.Hash) (*merkletree.Proof, error) {
+
+	return merkletree_proof.GenerateProof(ctx, m, treeRoot, key)
+}
+
+type multiResult struct {
+	node     merkletree_proof.Node
+	err      error
+	failover bool
+}
+
+func (m *MultiReverseHashCli) GetNode(ctx context.Context,
+	hash *merkletree.Hash) (merkletree_proof.Node, error) {
+
+	if hash == nil {
+		return merkletree_proof.Node{}, errors.New("hash is nil")
+	}
+
+	eps := m.order()
+	// hedged requests that lose are canceled on return
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan multiResult, len(eps))
+	launched, pending := 0, 0
+	launch := func() {
+		ep := eps[launched]
+		launched++
+		pending++
+		m.begin(ep)
+		go func() {
+			start := m.now()
+			n, err := ep.cli.GetNode(ctx, hash)
+			failover := m.end(ctx, ep, err, m.now().Sub(start))
+			results <- multiResult{node: n, err: err, failover: failover}
+		}()
+	}
+
+	var hedge *time.Timer
+	defer func() {
+		if hedge != nil {
+			hedge.Stop()
+		}
+	}()
+	hedgeC := func() <-chan time.Time {
+		if m.hedgeDelay <= 0 || launched >= len(eps) {
+			return nil
+		}
+		if hedge == nil {
+			hedge = time.NewTimer(m.hedgeDelay)
+		} else {
+			hedge.Reset(m.hedgeDelay)
+		}
+		return hedge.C
+	}
+
+	launch()
+	timeout := hedgeC()
+	// finalErr is an error that must not fail over, returned unless a
+	// hedged request still in flight answers
+	var lastErr, finalErr error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil || errors.Is(r.err, abicsr.ErrNodeNotFound) ||
+				ctx.Err() != nil {
+
+				return r.node, r.err
+			}
+			if !r.failover {
+				if finalErr == nil {
+					finalErr = r.err
+				}
+				timeout = nil
+				continue
+			}
+			lastErr = r.err
+			if finalErr == nil && launched < len(eps) {
+				if hedge != nil && !hedge.Stop() {
+					select {
+					case <-hedge.C:
+					default:
+					}
+				}
+				launch()
+				timeout = hedgeC()
+			}
+		case <-timeout:
+			launch()
+			timeout = hedgeC()
+		}
+	}
+	if finalErr != nil {
+		return merkletree_proof.Node{}, finalErr
+	}
+	return merkletree_proof.Node{}, lastErr
+}
+
+// SaveNodes saves the nodes to one endpoint, failing over to the next one on
+// transient errors.
+func (m *MultiReverseHashCli) SaveNodes(ctx context.Context,
+	nodes []merkletree_proof.Node) error {
+
+	var err error
+	for _, ep := range m.order() {
+		m.begin(ep)
+		start := m.now()
+		err = ep.cli.SaveNodes(ctx, nodes)
+		if !m.end(ctx, ep, err, m.now().Sub(start)) {
+			return err
+		}
+	}
+	return err
+}
+
+// HasNodes checks the nodes on one endpoint, failing over to the next one
+// on transient errors. See ReverseHashCli.HasNodes.
+func (m *MultiReverseHashCli) HasNodes(ctx context.Context,
+	hashes []*merkletree.Hash) ([]bool, error) {
+
+	var present []bool
+	var err error
+	for _, ep := range m.order() {
+		m.begin(ep)
+		start := m.now()
+		present, err = ep.cli.HasNodes(ctx, hashes)
+		if !m.end(ctx, ep, err, m.now().Sub(start)) {
+			return present, err
+		}
+	}
+	return present, err
+}
+
+// order returns the endpoints in the order they should be tried: healthy
+// endpoints ordered by the balancing strategy, then unhealthy ones from the
+// longest failing.
+func (m *MultiReverseHashCli) order() []*endpoint {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	var healthy, unhealthy []*endpoint
+	for _, ep := range m.endpoints {
+		if m.healthy(ep, now) {
+			healthy = append(healthy, ep)
+		} else {
+			unhealthy = append(unhealthy, ep)
+		}
+	}
+
+	switch m.balancing {
+	case BalanceRoundRobin:
+		if len(healthy) > 1 {
+			shift := m.next % len(healthy)
+			healthy = append(healthy[shift:], healthy[:shift]...)
+		}
+		m.next++
+	case BalanceLeastLatency:
+		sort.SliceStable(healthy, func(i, j int) bool {
+			return healthy[i].latency < healthy[j].latency
+		})
+	case BalanceRandom:
+		rand.Shuffle(len(healthy), func(i, j int) {
+			healthy[i], healthy[j] = healthy[j], healthy[i]
+		})
+	}
+
+	sort.SliceStable(unhealthy, func(i, j int) bool {
+		return unhealthy[i].lastFailure.Before(unhealthy[j].lastFailure)
+	})
+	return append(healthy, unhealthy...)
+}
+
+func (m *MultiReverseHashCli) healthy(ep *endpoint, now time.Time) bool {
+	return ep.failures < m.failureThreshold ||
+		now.Sub(ep.lastFailure) >= m.cooldown
+}
+
+func (m *MultiReverseHashCli) begin(ep *endpoint) {
+	m.mu.Lock()
+	ep.inFlight++
+	m.mu.Unlock()
+}
+
+// end records the result of a request to the endpoint made with the
+// context and reports whether the request should fail over to the next
+// endpoint.
+func (m *MultiReverseHashCli) end(ctx context.Context, ep *endpoint,
+	err error, d time.Duration) bool {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ep.inFlight--
+
+	switch {
+	case err == nil || errors.Is(err, abicsr.ErrNodeNotFound):
+		m.recordSuccess(ep, d)
+		return false
+	case ctx.Err() != nil || errors.Is(err, context.Canceled):
+		// canceled or timed out by the caller, or canceled as a losing
+		// hedged request
+		return false
+	case !m.isFailure(err):
+		return false
+	}
+	m.recordFailure(ep, err)
+	return true
+}
+
+// latencyWeight is the weight of a new sample in the latency moving average.
+const latencyWeight = 0.2
+
+func (m *MultiReverseHashCli) recordSuccess(ep *endpoint, d time.Duration) {
+	ep.failures = 0
+	if ep.latency == 0 {
+		ep.latency = d
+	} else {
+		ep.latency = time.Duration(latencyWeight*float64(d) +
+			(1-latencyWeight)*float64(ep.latency))
+	}
+}
+
+func (m *MultiReverseHashCli) recordFailure(ep *endpoint, err error) {
+	ep.failures++
+	ep.lastErr = err
+	ep.lastFailure = m.now()
+}
@@ -0,0 +1,296 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+	"github.com/iden3/merkletree-proof/internal/testtree"
+	mpmemory "github.com/iden3/merkletree-proof/memory"
+	"github.com/stretchr/testify/require"
+)
+
+// replica serves the handler, counting requests, optionally delaying them
+// or failing them with 503. If status is set, requests are answered with it
+// after the delay.
+type replica struct {
+	handler http.Handler
+	delay   int64 // time.Duration
+	failing int32
+	status  int32
+	count   int32
+}
+
+func (r *replica) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	atomic.AddInt32(&r.count, 1)
+	if atomic.LoadInt32(&r.failing) != 0 {
+		writeError(w, http.StatusServiceUnavailable,
+			errors.New("service unavailable"))
+		return
+	}
+	if delay := time.Duration(atomic.LoadInt64(&r.delay)); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return
+		}
+	}
+	if status := atomic.LoadInt32(&r.status); status != 0 {
+		writeError(w, int(status), errors.New("bad request"))
+		return
+	}
+	r.handler.ServeHTTP(w, req)
+}
+
+func (r *replica) requests() int {
+	return int(atomic.LoadInt32(&r.count))
+}
+
+// startReplicas starts n replicas sharing the store, which contains nodes
+// of a small tree.
+func startReplicas(t testing.TB, n int) ([]*replica, []string,
+	[]merkletree_proof.Node) {
+
+	ctx := context.Background()
+	store := mpmemory.NewReverseHashCli()
+	mt := testtree.Build(t, 1, 2, 3)
+	nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.SaveNodes(ctx, nodes))
+	h, err := NewHandler(store)
+	require.NoError(t, err)
+
+	var replicas []*replica
+	var urls []string
+	for i := 0; i < n; i++ {
+		r := &replica{handler: h}
+		srv := httptest.NewServer(r)
+		t.Cleanup(srv.Close)
+		replicas = append(replicas, r)
+		urls = append(urls, srv.URL)
+	}
+	return replicas, urls, nodes
+}
+
+func TestMultiReverseHashCli_RoundRobin(t *testing.T) {
+	ctx := context.Background()
+	replicas, urls, nodes := startReplicas(t, 3)
+	cli, err := NewMultiReverseHashCli(urls)
+	require.NoError(t, err)
+
+	for i := 0; i < 6; i++ {
+		n, err := cli.GetNode(ctx, nodes[0].Hash)
+		require.NoError(t, err)
+		require.Equal(t, nodes[0], n)
+	}
+	for _, r := range replicas {
+		require.Equal(t, 2, r.requests())
+	}
+
+	// missing nodes are not looked up on other replicas
+	_, err = cli.GetNode(ctx, &hashOne)
+	require.ErrorIs(t, err, abicsr.ErrNodeNotFound)
+	require.Equal(t, 7, replicas[0].requests()+replicas[1].requests()+
+		replicas[2].requests())
+}
+
+func TestMultiReverseHashCli_Failover(t *testing.T) {
+	ctx := context.Background()
+	replicas, urls, nodes := startReplicas(t, 2)
+	atomic.StoreInt32(&replicas[0].failing, 1)
+
+	now := time.Now()
+	cli, err := NewMultiReverseHashCli(urls,
+		WithBalancing(BalancePriority), WithUnhealthyThreshold(2),
+		WithUnhealthyCooldown(time.Minute))
+	require.NoError(t, err)
+	cli.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		n, err := cli.GetNode(ctx, nodes[0].Hash)
+		require.NoError(t, err)
+		require.Equal(t, nodes[0], n)
+	}
+	require.Equal(t, 2, replicas[0].requests())
+	require.Equal(t, 2, replicas[1].requests())
+
+	statuses := cli.Endpoints()
+	require.False(t, statuses[0].Healthy)
+	require.Equal(t, 2, statuses[0].ConsecutiveFailures)
+	var respErr *ResponseError
+	require.ErrorAs(t, statuses[0].LastError, &respErr)
+	require.Equal(t, http.StatusServiceUnavailable, respErr.StatusCode)
+	require.True(t, statuses[1].Healthy)
+
+	// the unhealthy endpoint is skipped
+	require.NoError(t, cli.SaveNodes(ctx, nodes))
+	require.Equal(t, 2, replicas[0].requests())
+	require.Equal(t, 3, replicas[1].requests())
+	present, err := cli.HasNodes(ctx, []*merkletree.Hash{nodes[0].Hash})
+	require.NoError(t, err)
+	require.Equal(t, []bool{true}, present)
+	require.Equal(t, 2, replicas[0].requests())
+	require.Equal(t, 4, replicas[1].requests())
+
+	// after the cooldown it gets requests again and recovers
+	atomic.StoreInt32(&replicas[0].failing, 0)
+	now = now.Add(time.Minute)
+	_, err = cli.GetNode(ctx, nodes[0].Hash)
+	require.NoError(t, err)
+	require.Equal(t, 3, replicas[0].requests())
+	require.True(t, cli.Endpoints()[0].Healthy)
+	require.Equal(t, 0, cli.Endpoints()[0].ConsecutiveFailures)
+
+	// all endpoints failing
+	atomic.StoreInt32(&replicas[0].failing, 1)
+	atomic.StoreInt32(&replicas[1].failing, 1)
+	_, err = cli.GetNode(ctx, nodes[0].Hash)
+	require.ErrorAs(t, err, &respErr)
+}
+
+func TestMultiReverseHashCli_CallerDeadline(t *testing.T) {
+	replicas, urls, nodes := startReplicas(t, 2)
+	for _, r := range replicas {
+		atomic.StoreInt64(&r.delay, int64(time.Second))
+	}
+	cli, err := NewMultiReverseHashCli(urls,
+		WithBalancing(BalancePriority), WithUnhealthyThreshold(1))
+	require.NoError(t, err)
+
+	// a short deadline of the caller is not a failure of the endpoint
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(),
+			10*time.Millisecond)
+		_, err = cli.GetNode(ctx, nodes[0].Hash)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		err = cli.SaveNodes(ctx, nodes)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		cancel()
+	}
+	require.Equal(t, 0, replicas[1].requests())
+	for _, s := range cli.Endpoints() {
+		require.True(t, s.Healthy)
+		require.Equal(t, 0, s.ConsecutiveFailures)
+	}
+}
+
+func TestMultiReverseHashCli_Hedging(t *testing.T) {
+	ctx := context.Background()
+	replicas, urls, nodes := startReplicas(t, 2)
+	atomic.StoreInt64(&replicas[0].delay, int64(time.Second))
+
+	cli, err := NewMultiReverseHashCli(urls,
+		WithBalancing(BalancePriority),
+		WithHedgeDelay(20*time.Millisecond))
+	require.NoError(t, err)
+
+	start := time.Now()
+	n, err := cli.GetNode(ctx, nodes[0].Hash)
+	require.NoError(t, err)
+	require.Equal(t, nodes[0], n)
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+	require.Equal(t, 1, replicas[0].requests())
+	require.Equal(t, 1, replicas[1].requests())
+
+	// the losing request is canceled and is not a failure
+	require.Eventually(t, func() bool {
+		return cli.Endpoints()[0].InFlight == 0
+	}, time.Second, 5*time.Millisecond)
+	require.True(t, cli.Endpoints()[0].Healthy)
+	require.Equal(t, 0, cli.Endpoints()[0].ConsecutiveFailures)
+
+	// without hedging the slow endpoint is waited for
+	atomic.StoreInt64(&replicas[0].delay, int64(50*time.Millisecond))
+	cli, err = NewMultiReverseHashCli(urls, WithBalancing(BalancePriority))
+	require.NoError(t, err)
+	_, err = cli.GetNode(ctx, nodes[0].Hash)
+	require.NoError(t, err)
+	require.Equal(t, 2, replicas[0].requests())
+	require.Equal(t, 1, replicas[1].requests())
+}
+
+func TestMultiReverseHashCli_HedgingNonFailoverError(t *testing.T) {
+	ctx := context.Background()
+	replicas, urls, nodes := startReplicas(t, 2)
+	atomic.StoreInt64(&replicas[0].delay, int64(50*time.Millisecond))
+	atomic.StoreInt32(&replicas[0].status, http.StatusBadRequest)
+	atomic.StoreInt64(&replicas[1].delay, int64(150*time.Millisecond))
+
+	cli, err := NewMultiReverseHashCli(urls,
+		WithBalancing(BalancePriority),
+		WithHedgeDelay(20*time.Millisecond))
+	require.NoError(t, err)
+
+	// the error of the first endpoint doesn't cancel the hedged request
+	n, err := cli.GetNode(ctx, nodes[0].Hash)
+	require.NoError(t, err)
+	require.Equal(t, nodes[0], n)
+	require.Equal(t, 1, replicas[0].requests())
+	require.Equal(t, 1, replicas[1].requests())
+
+	// the error is returned if no hedged request answers
+	atomic.StoreInt32(&replicas[1].status, http.StatusBadRequest)
+	_, err = cli.GetNode(ctx, nodes[0].Hash)
+	var respErr *ResponseError
+	require.ErrorAs(t, err, &respErr)
+	require.Equal(t, http.StatusBadRequest, respErr.StatusCode)
+}
+
+func TestMultiReverseHashCli_LeastLatency(t *testing.T) {
+	ctx := context.Background()
+	replicas, urls, nodes := startReplicas(t, 2)
+	atomic.StoreInt64(&replicas[0].delay, int64(30*time.Millisecond))
+
+	cli, err := NewMultiReverseHashCli(urls,
+		WithBalancing(BalanceLeastLatency))
+	require.NoError(t, err)
+
+	// both endpoints are measured first
+	for i := 0; i < 6; i++ {
+		_, err = cli.GetNode(ctx, nodes[0].Hash)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1, replicas[0].requests())
+	require.Equal(t, 5, replicas[1].requests())
+}
+
+func TestMultiReverseHashCli_CheckHealth(t *testing.T) {
+	ctx := context.Background()
+	replicas, urls, _ := startReplicas(t, 2)
+	atomic.StoreInt32(&replicas[1].failing, 1)
+
+	cli, err := NewMultiReverseHashCli(urls)
+	require.NoError(t, err)
+	statuses := cli.CheckHealth(ctx)
+	require.True(t, statuses[0].Healthy)
+	require.False(t, statuses[1].Healthy)
+
+	atomic.StoreInt32(&replicas[1].failing, 0)
+	statuses = cli.CheckHealth(ctx)
+	require.True(t, statuses[1].Healthy)
+}
+
+func TestNewMultiReverseHashCli(t *testing.T) {
+	_, err := NewMultiReverseHashCli(nil)
+	require.Error(t, err)
+	_, err = NewMultiReverseHashCli([]string{"http://a", ""})
+	require.Error(t, err)
+	_, err = NewMultiReverseHashCli([]string{"http://a"}, WithBalancing(10))
+	require.Error(t, err)
+
+	cli, err := NewMultiReverseHashCli([]string{"http://a", "http://b"},
+		WithEndpointOptions(WithBearerToken("secret"),
+			WithHTTPTimeout(time.Second)))
+	require.NoError(t, err)
+	for _, ep := range cli.endpoints {
+		require.Equal(t, time.Second, ep.cli.HTTPTimeout)
+		require.Len(t, ep.cli.RequestEditors, 1)
+	}
+}
@@ -6,9 +6,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math/big"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
@@ -26,12 +28,205 @@ func init() {
 
 var hashOne merkletree.Hash
 
+// backendName is the name of the client reported to observers.
+const backendName = "http"
+
 // Deprecated: use github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi ErrNodeNotFound instead.
 var ErrNodeNotFound = errors.New("node not found")
 
+var _ merkletree_proof.ReverseHashCli = (*ReverseHashCli)(nil)
+
+// ReverseHashCli is a client of the reverse hash service HTTP API. It may be
+// created with NewReverseHashCli or as a struct literal.
 type ReverseHashCli struct {
 	URL         string
 	HTTPTimeout time.Duration
+	// HTTPClient is used to send requests. http.DefaultClient is used if it
+	// is nil.
+	HTTPClient *http.Client
+	// RequestEditors are applied in order to every request before it is
+	// sent, for example to add authentication headers.
+	RequestEditors []RequestEditor
+	// Observer, if set, is notified about every GetNode and SaveNodes call
+	// and every generated proof.
+	Observer merkletree_proof.Observer
+	// Logger, if set, receives GetNode and SaveNodes calls and generated
+	// proofs at the debug level and failures at the warn level.
+	Logger merkletree_proof.Logger
+	// Retry, if set, makes the client retry GetNode requests, and SaveNodes
+	// requests if enabled by the policy, after transient failures. The
+	// HTTPTimeout applies to each attempt.
+	Retry *RetryPolicy
+	// Limiter, if set, limits the rate and concurrency of HTTP requests,
+	// including retries. Waiting for the limiter does not count towards the
+	// HTTPTimeout.
+	Limiter *merkletree_proof.Limiter
+	// SaveChunkSize, if positive, makes SaveNodes split nodes into chunks of
+	// this size, each saved with a separate request. See Upload.
+	SaveChunkSize int
+	// SaveParallelism is the max number of chunks uploaded at once. The
+	// default is 1.
+	SaveParallelism int
+	// Cache, if set, stores GetNode responses according to their
+	// Cache-Control, Expires, ETag and Last-Modified headers. Fresh
+	// responses are served without a request; stale ones are revalidated
+	// with a conditional request.
+	Cache ResponseCache
+	// Discover makes the client fetch ServiceInfo with Info and adapt to
+	// the service, for example by splitting SaveNodes into chunks that fit
+	// the max request body size, or by asking for compressed responses only
+	// if the service reports FeatureCompression.
+	Discover bool
+	// InfoTTL is how long ServiceInfo is cached. The default is 5 minutes.
+	InfoTTL time.Duration
+	// Signer, if set, signs SaveNodes requests, so the service can verify
+	// who saved the nodes with VerifyRequest. SaveNodesStream then saves
+	// nodes with SaveNodes requests, as streams are not signed.
+	Signer RequestSigner
+	// SignatureAudience identifies the service in request signatures, so
+	// they are not valid for other services. It defaults to the host of
+	// URL, like "rhs.example.com".
+	SignatureAudience string
+
+	// info holds the *infoCache
+	info atomic.Value
+}
+
+// RequestEditor modifies a request before it is sent. A returned error aborts
+// the request.
+type RequestEditor func(ctx context.Context, req *http.Request) error
+
+type Option func(cli *ReverseHashCli) error
+
+// WithHTTPClient sets the HTTP client, for example one with custom TLS
+// configuration, proxy or client certificates.
+func WithHTTPClient(client *http.Client) Option {
+	return func(cli *ReverseHashCli) error {
+		if client == nil {
+			return errors.New("HTTP client is nil")
+		}
+		cli.HTTPClient = client
+		return nil
+	}
+}
+
+// WithHTTPTimeout sets the timeout of a request if the context has no
+// deadline. The default is 10 seconds.
+func WithHTTPTimeout(timeout time.Duration) Option {
+	return func(cli *ReverseHashCli) error {
+		cli.HTTPTimeout = timeout
+		return nil
+	}
+}
+
+// WithRequestEditor adds a function applied to every request before it is
+// sent.
+func WithRequestEditor(editor RequestEditor) Option {
+	return func(cli *ReverseHashCli) error {
+		if editor == nil {
+			return errors.New("request editor is nil")
+		}
+		cli.RequestEditors = append(cli.RequestEditors, editor)
+		return nil
+	}
+}
+
+// WithHeader sets a header on every request.
+func WithHeader(key, value string) Option {
+	return WithRequestEditor(func(_ context.Context, req *http.Request) error {
+		req.Header.Set(key, value)
+		return nil
+	})
+}
+
+// WithHeaderProvider sets headers returned by fn on every request. fn is
+// called for each request, so it may return short-lived credentials.
+func WithHeaderProvider(
+	fn func(ctx context.Context) (http.Header, error)) Option {
+
+	return WithRequestEditor(func(ctx context.Context, req *http.Request) error {
+		h, err := fn(ctx)
+		if err != nil {
+			return err
+		}
+		for k, vs := range h {
+			req.Header.Del(k)
+			for _, v := range vs {
+				req.Header.Add(k, v)
+			}
+		}
+		return nil
+	})
+}
+
+// WithBearerToken authenticates requests with the bearer token.
+func WithBearerToken(token string) Option {
+	return WithHeader("Authorization", "Bearer "+token)
+}
+
+// WithAPIKey sends the API key in the header, for example "X-API-Key".
+func WithAPIKey(header, key string) Option {
+	return WithHeader(header, key)
+}
+
+// WithBasicAuth authenticates requests with HTTP basic authentication.
+func WithBasicAuth(username, password string) Option {
+	return WithRequestEditor(func(_ context.Context, req *http.Request) error {
+		req.SetBasicAuth(username, password)
+		return nil
+	})
+}
+
+// WithUserAgent sets the User-Agent header.
+func WithUserAgent(userAgent string) Option {
+	return WithHeader("User-Agent", userAgent)
+}
+
+// WithObserver sets ReverseHashCli.Observer.
+func WithObserver(observer merkletree_proof.Observer) Option {
+	return func(cli *ReverseHashCli) error {
+		cli.Observer = observer
+		return nil
+	}
+}
+
+// WithLogger sets ReverseHashCli.Logger. A nil logger disables logging.
+func WithLogger(logger merkletree_proof.Logger) Option {
+	return func(cli *ReverseHashCli) error {
+		cli.Logger = logger
+		return nil
+	}
+}
+
+// WithRetryPolicy sets ReverseHashCli.Retry.
+func WithRetryPolicy(policy *RetryPolicy) Option {
+	return func(cli *ReverseHashCli) error {
+		cli.Retry = policy
+		return nil
+	}
+}
+
+// WithLimiter sets ReverseHashCli.Limiter.
+func WithLimiter(limiter *merkletree_proof.Limiter) Option {
+	return func(cli *ReverseHashCli) error {
+		cli.Limiter = limiter
+		return nil
+	}
+}
+
+func NewReverseHashCli(url string, opts ...Option) (*ReverseHashCli, error) {
+	if url == "" {
+		return nil, errors.New("HTTP reverse hash service url is not specified")
+	}
+
+	cli := &ReverseHashCli{URL: url}
+	for _, o := range opts {
+		err := o(cli)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cli, nil
 }
 
 // GenerateProof generates proof of existence or in-existence of a key in
@@ -44,7 +239,17 @@ func (cli *ReverseHashCli) GenerateProof(ctx context.Context,
 		return nil, errors.New("HTTP reverse hash service url is not specified")
 	}
 
-	return merkletree_proof.GenerateProof(ctx, cli, treeRoot, key)
+	return merkletree_proof.GenerateProof(ctx, cli, treeRoot, key,
+		merkletree_proof.WithProofObserver(cli.observer()))
+}
+
+// observer returns the observer to notify about calls, or nil.
+func (cli *ReverseHashCli) observer() merkletree_proof.Observer {
+	if cli.Logger == nil {
+		return cli.Observer
+	}
+	return merkletree_proof.JoinObservers(cli.Observer,
+		merkletree_proof.LogObserver{Logger: cli.Logger})
 }
 
 func (cli *ReverseHashCli) nodeURL(node *merkletree.Hash) string {
@@ -59,6 +264,51 @@ func (cli *ReverseHashCli) baseURL() string {
 	return strings.TrimSuffix(cli.URL, "/")
 }
 
+// do applies request editors to the request and sends it. See send.
+func (cli *ReverseHashCli) do(ctx context.Context, req *http.Request,
+	received *int64) (*http.Response, error) {
+
+	err := cli.editRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return cli.send(req, received)
+}
+
+func (cli *ReverseHashCli) editRequest(ctx context.Context,
+	req *http.Request) error {
+
+	req.Header.Set("Accept-Encoding", cli.acceptEncoding(ctx))
+	for _, edit := range cli.RequestEditors {
+		err := edit(ctx, req)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send sends the request. The response body is decompressed and its size on
+// the wire is added to received if it is not nil.
+func (cli *ReverseHashCli) send(req *http.Request,
+	received *int64) (*http.Response, error) {
+
+	client := cli.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	err = decompressBody(resp, received)
+	if err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
 func (cli *ReverseHashCli) getHttpTimeout() time.Duration {
 	if cli.HTTPTimeout == 0 {
 		return 10 * time.Second
@@ -69,10 +319,62 @@ func (cli *ReverseHashCli) getHttpTimeout() time.Duration {
 func (cli *ReverseHashCli) GetNode(ctx context.Context,
 	hash *merkletree.Hash) (merkletree_proof.Node, error) {
 
+	observer := cli.observer()
+	if observer == nil {
+		return cli.getNode(ctx, hash, nil)
+	}
+
+	start := time.Now()
+	var received int64
+	n, err := cli.getNode(ctx, hash, &received)
+	observer.ObserveCall(ctx, merkletree_proof.CallInfo{
+		Backend:       backendName,
+		Op:            merkletree_proof.OpGetNode,
+		Hash:          hash,
+		Duration:      time.Since(start),
+		BytesReceived: received,
+		Err:           err,
+	})
+	return n, err
+}
+
+// getNode fetches the node, retrying according to the retry policy, and
+// adds the size of response bodies to received if it is not nil.
+func (cli *ReverseHashCli) getNode(ctx context.Context,
+	hash *merkletree.Hash, received *int64) (merkletree_proof.Node, error) {
+
+	var n merkletree_proof.Node
+	err := cli.Retry.do(ctx, func() error {
+		var err error
+		n, err = cli.getNodeOnce(ctx, hash, received)
+		return err
+	})
+	return n, err
+}
+
+func (cli *ReverseHashCli) getNodeOnce(ctx context.Context,
+	hash *merkletree.Hash, received *int64) (merkletree_proof.Node, error) {
+
 	if hash == nil {
 		return merkletree_proof.Node{}, errors.New("hash is nil")
 	}
 
+	nodeURL := cli.nodeURL(hash)
+	var cached CachedResponse
+	var haveCached bool
+	if cli.Cache != nil {
+		cached, haveCached = cli.Cache.Get(nodeURL)
+		if haveCached && time.Now().Before(cached.Expires) {
+			return decodeNodeResponse(cached.Body)
+		}
+	}
+
+	release, err := cli.Limiter.Acquire(ctx)
+	if err != nil {
+		return merkletree_proof.Node{}, err
+	}
+	defer release()
+
 	if _, ok := ctx.Deadline(); !ok {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, cli.getHttpTimeout())
@@ -80,53 +382,141 @@ func (cli *ReverseHashCli) GetNode(ctx context.Context,
 	}
 
 	httpReq, err := http.NewRequestWithContext(
-		ctx, http.MethodGet, cli.nodeURL(hash), http.NoBody)
+		ctx, http.MethodGet, nodeURL, http.NoBody)
 	if err != nil {
 		return merkletree_proof.Node{}, err
 	}
+	if haveCached {
+		if cached.ETag != "" {
+			httpReq.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			httpReq.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
 
-	httpResp, err := http.DefaultClient.Do(httpReq)
+	httpResp, err := cli.do(ctx, httpReq, received)
 	if err != nil {
 		return merkletree_proof.Node{}, err
 	}
 	defer func() { _ = httpResp.Body.Close() }()
 
-	if httpResp.StatusCode == http.StatusNotFound {
-		var resp map[string]interface{}
-		dec := json.NewDecoder(httpResp.Body)
-		err := dec.Decode(&resp)
-		if err != nil {
-			return merkletree_proof.Node{}, err
-		}
-		if resp["status"] == "not found" {
+	switch {
+	case httpResp.StatusCode == http.StatusNotModified && haveCached:
+		cc := parseCacheControl(httpResp.Header.Get("Cache-Control"))
+		cached.Expires = freshUntil(httpResp.Header, cc, time.Now())
+		cli.Cache.Set(nodeURL, cached)
+		return decodeNodeResponse(cached.Body)
+	case httpResp.StatusCode == http.StatusNotFound:
+		respErr := newResponseError(httpResp, httpResp.Body, hash)
+		if respErr.Status == statusNotFound {
 			return merkletree_proof.Node{}, abicsr.ErrNodeNotFound
-		} else {
-			return merkletree_proof.Node{},
-				errors.New("unexpected response")
 		}
-	} else if httpResp.StatusCode != http.StatusOK {
-		return merkletree_proof.Node{}, fmt.Errorf(
-			"unexpected response: %v", httpResp.StatusCode)
+		return merkletree_proof.Node{}, respErr
+	case httpResp.StatusCode != http.StatusOK:
+		return merkletree_proof.Node{}, newResponseError(httpResp,
+			httpResp.Body, hash)
 	}
 
-	var nodeResp nodeResponse
-	dec := json.NewDecoder(httpResp.Body)
-	err = dec.Decode(&nodeResp)
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return merkletree_proof.Node{}, err
+	}
+	n, err := decodeNodeResponse(data)
 	if err != nil {
 		return merkletree_proof.Node{}, err
 	}
 
+	if cli.Cache != nil {
+		entry, ok := cachedResponse(httpResp.Header, data, time.Now())
+		if ok {
+			cli.Cache.Set(nodeURL, entry)
+		}
+	}
+	return n, nil
+}
+
+func decodeNodeResponse(data []byte) (merkletree_proof.Node, error) {
+	var nodeResp nodeResponse
+	err := json.Unmarshal(data, &nodeResp)
+	if err != nil {
+		return merkletree_proof.Node{}, err
+	}
 	return nodeResp.Node, nil
 }
 
+// SaveNodes saves the nodes. If there are more nodes than SaveChunkSize, or
+// than fit the max body size of the service when Discover is set, they are
+// saved in chunks with Upload, and a failure is reported as an
+// *UploadError.
 func (cli *ReverseHashCli) SaveNodes(ctx context.Context,
 	nodes []merkletree_proof.Node) error {
 
+	chunkSize := cli.SaveChunkSize
+	if chunkSize == 0 && cli.Discover {
+		chunkSize = cli.discoveredChunkSize(ctx)
+	}
+	if chunkSize > 0 && len(nodes) > chunkSize {
+		_, err := cli.upload(ctx, nodes, chunkSize)
+		return err
+	}
+	return cli.saveNodesObserved(ctx, nodes)
+}
+
+// saveNodesObserved saves the nodes with one request and notifies the
+// observer.
+func (cli *ReverseHashCli) saveNodesObserved(ctx context.Context,
+	nodes []merkletree_proof.Node) error {
+
+	observer := cli.observer()
+	if observer == nil {
+		return cli.saveNodes(ctx, nodes, nil, nil)
+	}
+
+	start := time.Now()
+	var sent, received int64
+	err := cli.saveNodes(ctx, nodes, &sent, &received)
+	observer.ObserveCall(ctx, merkletree_proof.CallInfo{
+		Backend:       backendName,
+		Op:            merkletree_proof.OpSaveNodes,
+		Nodes:         len(nodes),
+		Duration:      time.Since(start),
+		BytesSent:     sent,
+		BytesReceived: received,
+		Err:           err,
+	})
+	return err
+}
+
+// saveNodes saves the nodes, retrying if the retry policy allows it, and
+// adds sizes of request and response bodies to sent and received if they
+// are not nil.
+func (cli *ReverseHashCli) saveNodes(ctx context.Context,
+	nodes []merkletree_proof.Node, sent, received *int64) error {
+
 	reqBytes, err := json.Marshal(nodes)
 	if err != nil {
 		return err
 	}
 
+	retry := cli.Retry
+	if retry != nil && !retry.RetrySaveNodes {
+		retry = nil
+	}
+	return retry.do(ctx, func() error {
+		return cli.saveNodesOnce(ctx, reqBytes, sent, received)
+	})
+}
+
+func (cli *ReverseHashCli) saveNodesOnce(ctx context.Context,
+	reqBytes []byte, sent, received *int64) error {
+
+	release, err := cli.Limiter.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	// if no timeout set on context, set it here
 	if _, ok := ctx.Deadline(); !ok {
 		var cancel context.CancelFunc
@@ -140,26 +530,47 @@ func (cli *ReverseHashCli) SaveNodes(ctx context.Context,
 	if err != nil {
 		return err
 	}
+	err = cli.editRequest(ctx, httpReq)
+	if err != nil {
+		return err
+	}
+	// signed after the editors, so they can't replace the signature
+	if cli.Signer != nil {
+		err = signRequest(cli.Signer, httpReq, cli.SignatureAudience,
+			operationSaveNodes, reqBytes, time.Now())
+		if err != nil {
+			return err
+		}
+	}
 
-	httpResp, err := http.DefaultClient.Do(httpReq)
+	httpResp, err := cli.send(httpReq, received)
 	if err != nil {
 		return err
 	}
 	defer func() { _ = httpResp.Body.Close() }()
+	if sent != nil {
+		*sent += int64(len(reqBytes))
+	}
 
 	if httpResp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", httpResp.StatusCode)
+		return newResponseError(httpResp, httpResp.Body, nil)
 	}
 
 	dec := json.NewDecoder(httpResp.Body)
-	var respM map[string]interface{}
-	err = dec.Decode(&respM)
+	var resp statusResponse
+	err = dec.Decode(&resp)
 	if err != nil {
 		return fmt.Errorf("unable to decode RHS response: %w", err)
 	}
 
-	if respM["status"] != "OK" {
-		return fmt.Errorf("unexpected RHS response status: %s", respM["status"])
+	if resp.Status != statusOK {
+		return &ResponseError{
+			StatusCode: httpResp.StatusCode,
+			Status:     resp.Status,
+			Message:    resp.Error,
+			Method:     httpReq.Method,
+			URL:        httpReq.URL.String(),
+		}
 	}
 
 	return nil
@@ -169,3 +580,17 @@ type nodeResponse struct {
 	Node   merkletree_proof.Node `json:"node"`
 	Status string                `json:"status"`
 }
+
+// byteCounter adds the number of bytes read to n if it is not nil.
+type byteCounter struct {
+	r io.Reader
+	n *int64
+}
+
+func (r *byteCounter) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if r.n != nil {
+		*r.n += int64(n)
+	}
+	return n, err
+}
@@ -0,0 +1,227 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+	"github.com/iden3/merkletree-proof/internal/testtree"
+	mpmemory "github.com/iden3/merkletree-proof/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReverseHashCli_Observer(t *testing.T) {
+	ctx := context.Background()
+	h, err := NewHandler(mpmemory.NewReverseHashCli())
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	var calls []merkletree_proof.CallInfo
+	var proofs []merkletree_proof.ProofInfo
+	cli := &ReverseHashCli{URL: srv.URL, Observer: merkletree_proof.ObserverFuncs{
+		Call: func(_ context.Context, info merkletree_proof.CallInfo) {
+			calls = append(calls, info)
+		},
+		Proof: func(_ context.Context, info merkletree_proof.ProofInfo) {
+			proofs = append(proofs, info)
+		},
+	}}
+
+	mt := testtree.Build(t, 1, 5, 7)
+	nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+	require.NoError(t, cli.SaveNodes(ctx, nodes))
+	require.Len(t, calls, 1)
+	require.Equal(t, "http", calls[0].Backend)
+	require.Equal(t, merkletree_proof.OpSaveNodes, calls[0].Op)
+	require.Equal(t, len(nodes), calls[0].Nodes)
+	require.Positive(t, calls[0].BytesSent)
+	require.Positive(t, calls[0].BytesReceived)
+	require.NoError(t, calls[0].Err)
+
+	key, err := merkletree.NewHashFromBigInt(big.NewInt(5))
+	require.NoError(t, err)
+	_, err = cli.GenerateProof(ctx, mt.Root(), key)
+	require.NoError(t, err)
+	require.Len(t, proofs, 1)
+	require.Equal(t, len(calls)-1, proofs[0].Nodes)
+	for _, c := range calls[1:] {
+		require.Equal(t, merkletree_proof.OpGetNode, c.Op)
+		require.Positive(t, c.BytesReceived)
+		require.Zero(t, c.BytesSent)
+	}
+
+	_, err = cli.GetNode(ctx, &merkletree.HashZero)
+	require.ErrorIs(t, err, abicsr.ErrNodeNotFound)
+	require.ErrorIs(t, calls[len(calls)-1].Err, abicsr.ErrNodeNotFound)
+}
+
+type testLogger struct {
+	debug, warn []string
+}
+
+func (l *testLogger) Debug(msg string, _ ...any) { l.debug = append(l.debug, msg) }
+func (l *testLogger) Info(string, ...any)        {}
+func (l *testLogger) Warn(msg string, _ ...any)  { l.warn = append(l.warn, msg) }
+func (l *testLogger) Error(string, ...any)       {}
+
+func TestReverseHashCli_Logger(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, http.StatusBadGateway, errors.New("upstream down"))
+		}))
+	defer srv.Close()
+
+	logger := &testLogger{}
+	cli := &ReverseHashCli{URL: srv.URL, Logger: logger}
+	_, err := cli.GetNode(ctx, &merkletree.HashZero)
+	require.Error(t, err)
+	require.Equal(t, []string{"GetNode failed"}, logger.warn)
+
+	_, err = cli.GenerateProof(ctx, &merkletree.HashZero, &merkletree.HashZero)
+	require.NoError(t, err)
+	require.Equal(t, []string{"proof generated"}, logger.debug)
+}
+
+func TestReverseHashCli_Limiter(t *testing.T) {
+	ctx := context.Background()
+	store := mpmemory.NewReverseHashCli()
+	h, err := NewHandler(store)
+	require.NoError(t, err)
+	mt := testtree.Build(t, 1, 5, 7)
+	nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.SaveNodes(ctx, nodes))
+
+	var mu sync.Mutex
+	var inFlight, maxInFlight int
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			h.ServeHTTP(w, r)
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}))
+	defer srv.Close()
+
+	limiter, err := merkletree_proof.NewLimiter(
+		merkletree_proof.WithMaxInFlight(2))
+	require.NoError(t, err)
+	cli := &ReverseHashCli{URL: srv.URL, Limiter: limiter}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cli.GetNode(ctx, mt.Root())
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 2, maxInFlight)
+}
+
+type countingTransport struct {
+	requests int32
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&t.requests, 1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestNewReverseHashCli(t *testing.T) {
+	ctx := context.Background()
+	h, err := NewHandler(mpmemory.NewReverseHashCli())
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var headers []http.Header
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			headers = append(headers, r.Header.Clone())
+			mu.Unlock()
+			h.ServeHTTP(w, r)
+		}))
+	defer srv.Close()
+
+	transport := &countingTransport{}
+	var provided int
+	cli, err := NewReverseHashCli(srv.URL,
+		WithHTTPClient(&http.Client{Transport: transport}),
+		WithBearerToken("secret"),
+		WithAPIKey("X-API-Key", "key1"),
+		WithUserAgent("rhs-test/1.0"),
+		WithHeaderProvider(func(_ context.Context) (http.Header, error) {
+			provided++
+			h := http.Header{}
+			h.Set("X-Request-Number", strconv.Itoa(provided))
+			return h, nil
+		}))
+	require.NoError(t, err)
+
+	mt := testtree.Build(t, 1, 5)
+	nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+	require.NoError(t, cli.SaveNodes(ctx, nodes))
+	_, err = cli.GetNode(ctx, mt.Root())
+	require.NoError(t, err)
+
+	require.Equal(t, int32(2), atomic.LoadInt32(&transport.requests))
+	require.Len(t, headers, 2)
+	for i, h := range headers {
+		require.Equal(t, "Bearer secret", h.Get("Authorization"))
+		require.Equal(t, "key1", h.Get("X-API-Key"))
+		require.Equal(t, "rhs-test/1.0", h.Get("User-Agent"))
+		require.Equal(t, strconv.Itoa(i+1), h.Get("X-Request-Number"))
+	}
+
+	// basic auth
+	headers = nil
+	cli, err = NewReverseHashCli(srv.URL, WithBasicAuth("user", "pass"))
+	require.NoError(t, err)
+	_, err = cli.GetNode(ctx, mt.Root())
+	require.NoError(t, err)
+	req := &http.Request{Header: headers[0]}
+	user, pass, ok := req.BasicAuth()
+	require.True(t, ok)
+	require.Equal(t, "user", user)
+	require.Equal(t, "pass", pass)
+
+	// an editor error aborts the request
+	errNoToken := errors.New("no token")
+	cli, err = NewReverseHashCli(srv.URL, WithRequestEditor(
+		func(_ context.Context, _ *http.Request) error { return errNoToken }))
+	require.NoError(t, err)
+	_, err = cli.GetNode(ctx, mt.Root())
+	require.ErrorIs(t, err, errNoToken)
+	require.Len(t, headers, 1)
+
+	_, err = NewReverseHashCli("")
+	require.Error(t, err)
+	_, err = NewReverseHashCli(srv.URL, WithHTTPClient(nil))
+	require.Error(t, err)
+}
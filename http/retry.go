@@ -0,0 +1,196 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy configures retries of failed requests with exponential backoff.
+// The delay before retry n (starting from 1) is InitialBackoff multiplied by
+// Multiplier n-1 times, capped at MaxBackoff and reduced by a random
+// fraction up to Jitter. If the server sends a Retry-After header, the client
+// waits at least that long, up to MaxRetryAfter. No retry is made if the
+// delay would exceed the context deadline.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts including the first
+	// one. Values below 2 disable retries.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Multiplier defaults to 2 if not greater than 1.
+	Multiplier float64
+	// Jitter is a fraction of the delay in [0, 1] that is randomly
+	// subtracted from it.
+	Jitter float64
+	// MaxRetryAfter caps the delay requested by a Retry-After header. It
+	// defaults to MaxBackoff, or to 1 minute if MaxBackoff is not set.
+	MaxRetryAfter time.Duration
+	// RetrySaveNodes enables retries of SaveNodes. Only GetNode requests are
+	// retried by default.
+	RetrySaveNodes bool
+	// Retryable overrides IsRetryable to decide which errors are transient.
+	Retryable func(err error) bool
+}
+
+// DefaultRetryPolicy returns a policy making up to 4 attempts with delays
+// starting from 100ms, capped at 5s, with 20% jitter.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// do calls op until it succeeds, fails with a permanent error or attempts
+// are exhausted. A nil policy calls op once.
+func (p *RetryPolicy) do(ctx context.Context, op func() error) error {
+	if p == nil {
+		return op()
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= p.MaxAttempts || !p.retryable(err) ||
+			ctx.Err() != nil {
+
+			return err
+		}
+
+		wait := p.backoff(attempt)
+		var respErr *ResponseError
+		if errors.As(err, &respErr) && respErr.RetryAfter > wait {
+			wait = respErr.RetryAfter
+			if max := p.maxRetryAfter(); wait > max {
+				wait = max
+			}
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return err
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+// defaultMaxRetryAfter caps Retry-After delays if neither MaxRetryAfter nor
+// MaxBackoff is set.
+const defaultMaxRetryAfter = time.Minute
+
+func (p *RetryPolicy) maxRetryAfter() time.Duration {
+	switch {
+	case p.MaxRetryAfter > 0:
+		return p.MaxRetryAfter
+	case p.MaxBackoff > 0:
+		return p.MaxBackoff
+	default:
+		return defaultMaxRetryAfter
+	}
+}
+
+// backoff returns the delay before the retry following the given attempt.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier <= 1 {
+		multiplier = 2
+	}
+
+	d := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		d *= multiplier
+		if p.MaxBackoff > 0 && d >= float64(p.MaxBackoff) {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+
+	if p.Jitter > 0 {
+		jitter := p.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		d -= d * jitter * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// IsRetryable reports whether the error returned by the client is likely
+// transient: a reset or refused connection, a response cut short, a
+// per-request timeout, or a 408, 429, 500, 502, 503 or 504 response. Missing
+// nodes, rejected requests, canceled contexts and other transport failures,
+// like TLS errors or unsupported URLs, are permanent.
+func IsRetryable(err error) bool {
+	var respErr *ResponseError
+	if errors.As(err, &respErr) {
+		switch respErr.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests,
+			http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		default:
+			return false
+		}
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return false
+	case errors.Is(err, context.DeadlineExceeded):
+		// the caller's deadline is checked separately, so this is a
+		// timeout of a single request
+		return true
+	case errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED):
+		return true
+	}
+
+	// every error of http.Client.Do is a *url.Error, which is a net.Error
+	// reporting the timeout of the error it wraps
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// parseRetryAfter parses the Retry-After header given either in seconds or
+// as an HTTP date. It returns 0 if the header is absent or invalid.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,256 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/iden3/go-merkletree-sql/v2"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+	"github.com/iden3/merkletree-proof/internal/testtree"
+	mpmemory "github.com/iden3/merkletree-proof/memory"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyHandler responds with the given status to the first failures
+// requests and passes others to the handler.
+type flakyHandler struct {
+	handler    http.Handler
+	status     int
+	retryAfter string
+	failures   int32
+	requests   int32
+}
+
+func (h *flakyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if atomic.AddInt32(&h.requests, 1) <= h.failures {
+		if h.retryAfter != "" {
+			w.Header().Set("Retry-After", h.retryAfter)
+		}
+		writeError(w, h.status, errors.New("try again"))
+		return
+	}
+	h.handler.ServeHTTP(w, r)
+}
+
+func TestReverseHashCli_Retry(t *testing.T) {
+	ctx := context.Background()
+	store := mpmemory.NewReverseHashCli()
+	h, err := NewHandler(store)
+	require.NoError(t, err)
+	mt := testtree.Build(t, 1, 5)
+	nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.SaveNodes(ctx, nodes))
+
+	policy := &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+	testCases := []struct {
+		title        string
+		status       int
+		failures     int32
+		wantErr      bool
+		wantRequests int32
+	}{
+		{
+			title:        "recovers",
+			status:       http.StatusBadGateway,
+			failures:     2,
+			wantRequests: 3,
+		},
+		{
+			title:        "attempts exhausted",
+			status:       http.StatusServiceUnavailable,
+			failures:     3,
+			wantErr:      true,
+			wantRequests: 3,
+		},
+		{
+			title:        "permanent error",
+			status:       http.StatusBadRequest,
+			failures:     1,
+			wantErr:      true,
+			wantRequests: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			fh := &flakyHandler{handler: h, status: tc.status,
+				failures: tc.failures}
+			srv := httptest.NewServer(fh)
+			defer srv.Close()
+
+			cli := &ReverseHashCli{URL: srv.URL, Retry: policy}
+			n, err := cli.GetNode(ctx, mt.Root())
+			if tc.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, mt.Root(), n.Hash)
+			}
+			require.Equal(t, tc.wantRequests, atomic.LoadInt32(&fh.requests))
+		})
+	}
+}
+
+func TestReverseHashCli_RetrySaveNodes(t *testing.T) {
+	ctx := context.Background()
+	h, err := NewHandler(mpmemory.NewReverseHashCli())
+	require.NoError(t, err)
+	mt := testtree.Build(t, 1, 5)
+	nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+
+	fh := &flakyHandler{handler: h, status: http.StatusBadGateway,
+		failures: 1}
+	srv := httptest.NewServer(fh)
+	defer srv.Close()
+
+	// not retried by default
+	cli := &ReverseHashCli{URL: srv.URL, Retry: &RetryPolicy{
+		MaxAttempts: 3, InitialBackoff: time.Millisecond}}
+	require.Error(t, cli.SaveNodes(ctx, nodes))
+	require.Equal(t, int32(1), atomic.LoadInt32(&fh.requests))
+
+	atomic.StoreInt32(&fh.requests, 0)
+	cli.Retry.RetrySaveNodes = true
+	require.NoError(t, cli.SaveNodes(ctx, nodes))
+	require.Equal(t, int32(2), atomic.LoadInt32(&fh.requests))
+}
+
+func TestReverseHashCli_RetryAfterDeadline(t *testing.T) {
+	fh := &flakyHandler{status: http.StatusTooManyRequests,
+		retryAfter: "3600", failures: 10}
+	srv := httptest.NewServer(fh)
+	defer srv.Close()
+
+	cli := &ReverseHashCli{URL: srv.URL, Retry: &RetryPolicy{
+		MaxAttempts: 3, InitialBackoff: time.Millisecond}}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// the server asks to wait longer than the deadline allows, so the
+	// client gives up at once
+	start := time.Now()
+	_, err := cli.GetNode(ctx, &merkletree.HashZero)
+	require.Error(t, err)
+	require.Less(t, time.Since(start), 10*time.Second)
+	require.Equal(t, int32(1), atomic.LoadInt32(&fh.requests))
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := &RetryPolicy{InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff: time.Second, Multiplier: 3}
+	require.Equal(t, 100*time.Millisecond, p.backoff(1))
+	require.Equal(t, 300*time.Millisecond, p.backoff(2))
+	require.Equal(t, 900*time.Millisecond, p.backoff(3))
+	require.Equal(t, time.Second, p.backoff(4))
+	require.Equal(t, time.Second, p.backoff(100))
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.backoff(2)
+		require.GreaterOrEqual(t, d, 150*time.Millisecond)
+		require.LessOrEqual(t, d, 300*time.Millisecond)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	require.Equal(t, time.Duration(0), parseRetryAfter(""))
+	require.Equal(t, time.Duration(0), parseRetryAfter("soon"))
+	require.Equal(t, time.Duration(0), parseRetryAfter("-5"))
+	require.Equal(t, 120*time.Second, parseRetryAfter("120"))
+
+	d := parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	require.Greater(t, d, 59*time.Minute)
+	require.LessOrEqual(t, d, time.Hour)
+}
+
+func TestReverseHashCli_RetryAfterCapped(t *testing.T) {
+	fh := &flakyHandler{status: http.StatusTooManyRequests,
+		retryAfter: "3600", failures: 10}
+	srv := httptest.NewServer(fh)
+	defer srv.Close()
+
+	cli := &ReverseHashCli{URL: srv.URL, Retry: &RetryPolicy{
+		MaxAttempts: 3, InitialBackoff: time.Millisecond,
+		MaxRetryAfter: 10 * time.Millisecond}}
+
+	// without a deadline the client waits only MaxRetryAfter
+	start := time.Now()
+	_, err := cli.GetNode(context.Background(), &merkletree.HashZero)
+	require.Error(t, err)
+	require.Less(t, time.Since(start), 10*time.Second)
+	require.Equal(t, int32(3), atomic.LoadInt32(&fh.requests))
+}
+
+func TestRetryPolicy_MaxRetryAfter(t *testing.T) {
+	require.Equal(t, time.Minute, (&RetryPolicy{}).maxRetryAfter())
+	require.Equal(t, 5*time.Second, DefaultRetryPolicy().maxRetryAfter())
+	require.Equal(t, time.Second,
+		(&RetryPolicy{MaxBackoff: 5 * time.Second,
+			MaxRetryAfter: time.Second}).maxRetryAfter())
+}
+
+func TestIsRetryable(t *testing.T) {
+	tlsSrv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tlsSrv.Close()
+	_, tlsErr := http.Get(tlsSrv.URL)
+	require.Error(t, tlsErr)
+
+	_, schemeErr := http.Get("ftp://example.com/node")
+	require.Error(t, schemeErr)
+
+	closedSrv := httptest.NewServer(http.NotFoundHandler())
+	closedSrv.Close()
+	_, refusedErr := http.Get(closedSrv.URL)
+	require.Error(t, refusedErr)
+
+	timeoutSrv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}))
+	defer timeoutSrv.Close()
+	_, timeoutErr := (&http.Client{Timeout: 10 * time.Millisecond}).Get(
+		timeoutSrv.URL)
+	require.Error(t, timeoutErr)
+
+	testCases := []struct {
+		title string
+		err   error
+		want  bool
+	}{
+		{"bad gateway",
+			&ResponseError{StatusCode: http.StatusBadGateway}, true},
+		{"forbidden", &ResponseError{StatusCode: http.StatusForbidden}, false},
+		{"canceled", context.Canceled, false},
+		{"deadline", context.DeadlineExceeded, true},
+		{"other error", errors.New("invalid node"), false},
+		{"unexpected EOF", &url.Error{Op: "Get", URL: "http://rhs",
+			Err: io.ErrUnexpectedEOF}, true},
+		{"connection reset", &url.Error{Op: "Post", URL: "http://rhs",
+			Err: &net.OpError{Op: "read", Net: "tcp",
+				Err: os.NewSyscallError("read", syscall.ECONNRESET)}}, true},
+		{"connection refused", refusedErr, true},
+		{"client timeout", timeoutErr, true},
+		{"TLS error", tlsErr, false},
+		{"unsupported scheme", schemeErr, false},
+		{"redirect limit", &url.Error{Op: "Get", URL: "http://rhs",
+			Err: errors.New("stopped after 10 redirects")}, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			require.Equal(t, tc.want, IsRetryable(tc.err), tc.err)
+		})
+	}
+}
@@ -0,0 +1,369 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+)
+
+const (
+	statusOK       = "OK"
+	statusNotFound = "not found"
+	statusError    = "error"
+)
+
+// NodeStore is a storage of nodes served by Handler.
+type NodeStore interface {
+	merkletree_proof.NodeReader
+	merkletree_proof.NodeWriter
+}
+
+// Handler serves the reverse hash service protocol on top of a NodeStore:
+//
+//	GET  /node/{hash} returns a node or 404 with "not found" status
+//	POST /node        saves a JSON array of nodes
+//	GET  /info        describes the service, see ServiceInfo
+//	POST /nodes       saves a stream of nodes, one JSON node per line
+//	GET  /nodes/{hash} streams all nodes reachable from the node
+//	POST /has         returns which of a JSON array of hashes are stored
+//
+// With WithWriteAuthorizer, POST /node requests must be signed, see
+// VerifyRequest, and POST /nodes is disabled.
+//
+// Node responses carry an ETag and a long-lived Cache-Control header, as
+// nodes never change, and conditional requests are answered with 304. With
+// WithCompression, responses are compressed with gzip if the request accepts
+// it.
+//
+// To serve it under a path prefix, wrap it with http.StripPrefix.
+type Handler struct {
+	store           NodeStore
+	maxBodySize     int64
+	maxStreamSize   int64
+	streamBatchSize int
+	readOnly        bool
+	compress        bool
+	// authorize, if set, requires signed writes
+	authorize         WriteAuthorizer
+	signatureAudience string
+	signatureMaxSkew  time.Duration
+}
+
+type HandlerOption func(h *Handler) error
+
+// WithMaxBodySize limits the size of the POST /node request body. The
+// default is 10 MiB.
+func WithMaxBodySize(size int64) HandlerOption {
+	return func(h *Handler) error {
+		if size <= 0 {
+			return errors.New("max body size must be positive")
+		}
+		h.maxBodySize = size
+		return nil
+	}
+}
+
+// WithCompression makes the handler compress responses with gzip if the
+// request accepts it, and report FeatureCompression.
+func WithCompression() HandlerOption {
+	return func(h *Handler) error {
+		h.compress = true
+		return nil
+	}
+}
+
+func NewHandler(store NodeStore, opts ...HandlerOption) (*Handler, error) {
+	if store == nil {
+		return nil, errors.New("node store is nil")
+	}
+
+	h := &Handler{
+		store:           store,
+		maxBodySize:     10 << 20,
+		maxStreamSize:   1 << 30,
+		streamBatchSize: 500,
+	}
+	for _, o := range opts {
+		err := o(h)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.compress {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			gw := &gzipResponseWriter{ResponseWriter: w}
+			defer gw.close()
+			w = gw
+		}
+	}
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case path == "/node":
+		if h.readOnly {
+			writeMethodNotAllowed(w)
+			return
+		}
+		if r.Method != http.MethodPost {
+			writeMethodNotAllowed(w, http.MethodPost)
+			return
+		}
+		h.saveNodes(w, r)
+	case path == "/info":
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeMethodNotAllowed(w, http.MethodGet, http.MethodHead)
+			return
+		}
+		h.info(w)
+	case path == "/has":
+		if r.Method != http.MethodPost {
+			writeMethodNotAllowed(w, http.MethodPost)
+			return
+		}
+		h.hasNodes(w, r)
+	case path == "/nodes":
+		if h.readOnly {
+			writeMethodNotAllowed(w)
+			return
+		}
+		if r.Method != http.MethodPost {
+			writeMethodNotAllowed(w, http.MethodPost)
+			return
+		}
+		if h.authorize != nil {
+			writeError(w, http.StatusForbidden,
+				errors.New("signed writes are required, use POST /node"))
+			return
+		}
+		h.saveNodeStream(w, r)
+	case strings.HasPrefix(path, "/nodes/"):
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeMethodNotAllowed(w, http.MethodGet, http.MethodHead)
+			return
+		}
+		h.exportNodes(w, r, strings.TrimPrefix(path, "/nodes/"))
+	case strings.HasPrefix(path, "/node/"):
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeMethodNotAllowed(w, http.MethodGet, http.MethodHead)
+			return
+		}
+		h.getNode(w, r, strings.TrimPrefix(path, "/node/"))
+	default:
+		writeError(w, http.StatusNotFound, errors.New("unknown endpoint"))
+	}
+}
+
+func (h *Handler) getNode(w http.ResponseWriter, r *http.Request,
+	hashHex string) {
+
+	hash, err := merkletree.NewHashFromHex(hashHex)
+	if err != nil {
+		writeError(w, http.StatusBadRequest,
+			fmt.Errorf("invalid node hash: %w", err))
+		return
+	}
+
+	n, err := h.store.GetNode(r.Context(), hash)
+	if errors.Is(err, abicsr.ErrNodeNotFound) {
+		writeJSON(w, http.StatusNotFound, statusResponse{Status: statusNotFound})
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// nodes are immutable, so they may be cached forever
+	etag := `"` + hash.Hex() + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, nodeResponse{Node: n, Status: statusOK})
+}
+
+// etagMatches reports whether the If-None-Match header value matches the
+// entity tag.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == etag || t == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) info(w http.ResponseWriter) {
+	info := ServiceInfo{
+		Version:  APIVersion,
+		Features: []string{FeatureConditionalGet, FeatureHasNodes},
+		ReadOnly: h.readOnly,
+	}
+	if h.compress {
+		info.Features = append(info.Features, FeatureCompression)
+	}
+	if !h.readOnly {
+		info.MaxBodySize = h.maxBodySize
+		if h.authorize != nil {
+			info.Features = append(info.Features, FeatureSignedWrites)
+		} else {
+			info.Features = append(info.Features, FeatureStreaming)
+		}
+	}
+	writeJSON(w, http.StatusOK, infoResponse{Status: statusOK,
+		ServiceInfo: info})
+}
+
+func (h *Handler) saveNodes(w http.ResponseWriter, r *http.Request) {
+	var body io.Reader = http.MaxBytesReader(w, r.Body, h.maxBodySize)
+	var signed []byte
+	if h.authorize != nil {
+		// the signature covers the whole body
+		var err error
+		signed, err = io.ReadAll(body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest,
+				fmt.Errorf("can't decode nodes: %w", err))
+			return
+		}
+		body = bytes.NewReader(signed)
+	}
+
+	var nodes []merkletree_proof.Node
+	dec := json.NewDecoder(body)
+	err := dec.Decode(&nodes)
+	if err != nil {
+		writeError(w, http.StatusBadRequest,
+			fmt.Errorf("can't decode nodes: %w", err))
+		return
+	}
+
+	for i, n := range nodes {
+		if err := n.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest,
+				fmt.Errorf("invalid node #%v: %w", i, err))
+			return
+		}
+	}
+
+	if h.authorize != nil && !h.authorizeWrite(w, r, signed, nodes) {
+		return
+	}
+
+	err = h.store.SaveNodes(r.Context(), nodes)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, statusResponse{Status: statusOK})
+}
+
+type statusResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, statusResponse{Status: statusError, Error: err.Error()})
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	if len(allowed) != 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+	}
+	writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+}
+
+// acceptsGzip reports whether the Accept-Encoding header value accepts
+// gzip.
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "gzip" && name != "x-gzip" && name != "*" {
+			continue
+		}
+		params = strings.TrimSpace(params)
+		if !strings.HasPrefix(params, "q=") {
+			return true
+		}
+		qv, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64)
+		return err == nil && qv > 0
+	}
+	return false
+}
+
+// gzipResponseWriter compresses the response body with gzip. Responses
+// without a body are sent as is.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	zw          *gzip.Writer
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.wroteHeader = true
+	if code != http.StatusNotModified && code != http.StatusNoContent {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+		w.zw = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.zw == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.zw.Write(b)
+}
+
+// Flush sends the data compressed so far, so streamed responses are not
+// held back.
+func (w *gzipResponseWriter) Flush() {
+	if w.zw != nil {
+		_ = w.zw.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *gzipResponseWriter) close() {
+	if w.zw != nil {
+		_ = w.zw.Close()
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,165 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+	"github.com/iden3/merkletree-proof/internal/testtree"
+	mpmemory "github.com/iden3/merkletree-proof/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+	h, err := NewHandler(mpmemory.NewReverseHashCli())
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	cli := &ReverseHashCli{URL: srv.URL}
+	mt := testtree.Build(t, 1, 5, 7, 100, 12345)
+	nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+	require.NoError(t, cli.SaveNodes(ctx, nodes))
+
+	for _, n := range nodes {
+		got, err := cli.GetNode(ctx, n.Hash)
+		require.NoError(t, err)
+		require.Equal(t, n, got)
+	}
+
+	for _, k := range []int64{1, 100, 3} {
+		key, err := merkletree.NewHashFromBigInt(big.NewInt(k))
+		require.NoError(t, err)
+		wantProof, _, err := mt.GenerateProof(ctx, big.NewInt(k), nil)
+		require.NoError(t, err)
+		proof, err := cli.GenerateProof(ctx, mt.Root(), key)
+		require.NoError(t, err)
+		require.Equal(t, wantProof, proof)
+	}
+
+	_, err = cli.GetNode(ctx, &merkletree.HashZero)
+	require.ErrorIs(t, err, abicsr.ErrNodeNotFound)
+}
+
+func TestHandler_Errors(t *testing.T) {
+	h, err := NewHandler(mpmemory.NewReverseHashCli())
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	testCases := []struct {
+		title      string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			title:      "not found",
+			method:     http.MethodGet,
+			path:       "/node/0000000000000000000000000000000000000000000000000000000000000000",
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"status":"not found"}`,
+		},
+		{
+			title:      "invalid hash",
+			method:     http.MethodGet,
+			path:       "/node/xyz",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"status":"error","error":"invalid node hash: encoding/hex: invalid byte: U+0078 'x'"}`,
+		},
+		{
+			title:  "invalid node",
+			method: http.MethodPost,
+			path:   "/node",
+			body: `[{"hash":"0100000000000000000000000000000000000000000000000000000000000000",
+"children":["0000000000000000000000000000000000000000000000000000000000000000",
+"0000000000000000000000000000000000000000000000000000000000000000"]}]`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"status":"error","error":"invalid node #0: node hash mismatch: want 6448b64684ee39a823d5fe5fd52431dc81e4817bf2c3ea3cab9e239efbf59820, got 0100000000000000000000000000000000000000000000000000000000000000"}`,
+		},
+		{
+			title:      "wrong method",
+			method:     http.MethodGet,
+			path:       "/node",
+			wantStatus: http.StatusMethodNotAllowed,
+			wantBody:   `{"status":"error","error":"method not allowed"}`,
+		},
+		{
+			title:      "unknown endpoint",
+			method:     http.MethodGet,
+			path:       "/tree",
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"status":"error","error":"unknown endpoint"}`,
+		},
+	}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.title, func(t *testing.T) {
+			req, err := http.NewRequest(tc.method, srv.URL+tc.path,
+				bytes.NewReader([]byte(tc.body)))
+			require.NoError(t, err)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer func() { _ = resp.Body.Close() }()
+
+			require.Equal(t, tc.wantStatus, resp.StatusCode)
+			var body json.RawMessage
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			require.JSONEq(t, tc.wantBody, string(body))
+		})
+	}
+}
+
+func TestHandler_Compression(t *testing.T) {
+	ctx := context.Background()
+	mt := testtree.Build(t, 1, 5)
+	store, err := mpmemory.NewReverseHashCliFromTree(ctx, mt)
+	require.NoError(t, err)
+	h, err := NewHandler(store, WithCompression())
+	require.NoError(t, err)
+
+	get := func(acceptEncoding, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/node/"+mt.Root().Hex(),
+			http.NoBody)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		req.Header.Set("If-None-Match", ifNoneMatch)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("gzip, deflate", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	require.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	zr, err := gzip.NewReader(w.Body)
+	require.NoError(t, err)
+	var resp nodeResponse
+	require.NoError(t, json.NewDecoder(zr).Decode(&resp))
+	require.Equal(t, mt.Root(), resp.Node.Hash)
+
+	for _, acceptEncoding := range []string{"", "identity", "gzip;q=0"} {
+		w = get(acceptEncoding, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Empty(t, w.Header().Get("Content-Encoding"))
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	}
+
+	// a response without a body is not encoded
+	w = get("gzip", `"`+mt.Root().Hex()+`"`)
+	require.Equal(t, http.StatusNotModified, w.Code)
+	require.Empty(t, w.Header().Get("Content-Encoding"))
+	require.Zero(t, w.Body.Len())
+}
@@ -0,0 +1,362 @@
+package http
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/iden3/go-iden3-crypto/babyjub"
+	"github.com/iden3/go-iden3-crypto/constants"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+)
+
+// Headers of signed requests.
+const (
+	HeaderSignature       = "X-RHS-Signature"
+	HeaderSignatureScheme = "X-RHS-Signature-Scheme"
+	HeaderIdentity        = "X-RHS-Identity"
+	HeaderTimestamp       = "X-RHS-Timestamp"
+)
+
+// Signature schemes supported by VerifyRequest.
+const (
+	// SchemeBabyJubJub is an EdDSA Poseidon signature with a Baby JubJub
+	// key. The identity is the hex compressed public key.
+	SchemeBabyJubJub = "babyjubjub"
+	// SchemeEthereum is a secp256k1 signature with an Ethereum key. The
+	// identity is the hex address.
+	SchemeEthereum = "ethereum"
+)
+
+var (
+	// ErrUnsigned is returned by VerifyRequest for requests without a
+	// signature.
+	ErrUnsigned = errors.New("request is not signed")
+	// ErrInvalidSignature is returned by VerifyRequest for requests with a
+	// malformed, expired or wrong signature.
+	ErrInvalidSignature = errors.New("invalid request signature")
+)
+
+// RequestSigner signs SaveNodes requests.
+type RequestSigner interface {
+	// Scheme returns the signature scheme, like SchemeBabyJubJub.
+	Scheme() string
+	// Identity returns the identity of the signer sent with the signature,
+	// in the format of the scheme.
+	Identity() string
+	// Sign signs the 32 bytes digest of the request.
+	Sign(digest []byte) ([]byte, error)
+}
+
+type babyJubJubSigner struct {
+	key *babyjub.PrivateKey
+}
+
+// NewBabyJubJubSigner returns a signer using the Baby JubJub key, like an
+// issuer's auth key.
+func NewBabyJubJubSigner(key *babyjub.PrivateKey) (RequestSigner, error) {
+	if key == nil {
+		return nil, errors.New("key is nil")
+	}
+	return babyJubJubSigner{key: key}, nil
+}
+
+func (s babyJubJubSigner) Scheme() string { return SchemeBabyJubJub }
+
+func (s babyJubJubSigner) Identity() string {
+	return s.key.Public().Compress().String()
+}
+
+func (s babyJubJubSigner) Sign(digest []byte) ([]byte, error) {
+	sig := s.key.SignPoseidon(digestToField(digest)).Compress()
+	return sig[:], nil
+}
+
+type ethereumSigner struct {
+	key *ecdsa.PrivateKey
+}
+
+// NewEthereumSigner returns a signer using the Ethereum key.
+func NewEthereumSigner(key *ecdsa.PrivateKey) (RequestSigner, error) {
+	if key == nil {
+		return nil, errors.New("key is nil")
+	}
+	return ethereumSigner{key: key}, nil
+}
+
+func (s ethereumSigner) Scheme() string { return SchemeEthereum }
+
+func (s ethereumSigner) Identity() string {
+	return crypto.PubkeyToAddress(s.key.PublicKey).Hex()
+}
+
+func (s ethereumSigner) Sign(digest []byte) ([]byte, error) {
+	return crypto.Sign(digest, s.key)
+}
+
+// operationSaveNodes is the operation name signed with SaveNodes requests.
+const operationSaveNodes = "SaveNodes"
+
+// WithSigner sets ReverseHashCli.Signer.
+func WithSigner(signer RequestSigner) Option {
+	return func(cli *ReverseHashCli) error {
+		cli.Signer = signer
+		return nil
+	}
+}
+
+// signRequest adds the signature of the request for the operation with the
+// body for the audience to its headers. If audience is empty, it is the
+// host of the request.
+func signRequest(signer RequestSigner, req *http.Request, audience,
+	operation string, body []byte, now time.Time) error {
+
+	if audience == "" {
+		audience = req.Host
+		if audience == "" {
+			audience = req.URL.Host
+		}
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	sig, err := signer.Sign(signingDigest(audience, operation, req.Method,
+		timestamp, body))
+	if err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+	req.Header.Set(HeaderSignatureScheme, signer.Scheme())
+	req.Header.Set(HeaderIdentity, signer.Identity())
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, hex.EncodeToString(sig))
+	return nil
+}
+
+// signingDigest returns the digest signed for a request: SHA-256 of the
+// audience, the operation, the method, the timestamp and the hex SHA-256 of
+// the body, each on its own line after a version line. The audience binds
+// the signature to one service and the operation, like operationSaveNodes,
+// to one kind of request. The operation is signed instead of the path, so
+// requests stay valid behind proxies that rewrite it.
+func signingDigest(audience, operation, method, timestamp string,
+	body []byte) []byte {
+
+	bodyHash := sha256.Sum256(body)
+	msg := "RHS-SIGNATURE-V1\n" + audience + "\n" + operation + "\n" +
+		method + "\n" + timestamp + "\n" + hex.EncodeToString(bodyHash[:])
+	digest := sha256.Sum256([]byte(msg))
+	return digest[:]
+}
+
+// digestToField maps the digest to an element of the field signed with
+// Poseidon.
+func digestToField(digest []byte) *big.Int {
+	msg := new(big.Int).SetBytes(digest)
+	return msg.Mod(msg, constants.Q)
+}
+
+// SignerIdentity is the verified signer of a request.
+type SignerIdentity struct {
+	Scheme   string
+	Identity string
+	SignedAt time.Time
+}
+
+// BabyJubJubKey returns the public key of a SchemeBabyJubJub signer.
+func (id SignerIdentity) BabyJubJubKey() (*babyjub.PublicKey, error) {
+	if id.Scheme != SchemeBabyJubJub {
+		return nil, fmt.Errorf("not a %v signer", SchemeBabyJubJub)
+	}
+	var comp babyjub.PublicKeyComp
+	err := comp.UnmarshalText([]byte(id.Identity))
+	if err != nil {
+		return nil, err
+	}
+	return comp.Decompress()
+}
+
+// EthereumAddress returns the address of a SchemeEthereum signer.
+func (id SignerIdentity) EthereumAddress() (ethcommon.Address, error) {
+	if id.Scheme != SchemeEthereum || !ethcommon.IsHexAddress(id.Identity) {
+		return ethcommon.Address{}, fmt.Errorf("not a %v signer",
+			SchemeEthereum)
+	}
+	return ethcommon.HexToAddress(id.Identity), nil
+}
+
+// defaultSignatureMaxSkew is the default max difference between the time a
+// request was signed and the time it is verified.
+const defaultSignatureMaxSkew = 5 * time.Minute
+
+// VerifyRequest checks the signature of the SaveNodes request made by a
+// ReverseHashCli with a Signer and returns the identity of the signer.
+// Signatures of other operations are rejected, whatever the path. body
+// is the request body, which the caller must read. audience identifies the
+// service, see ReverseHashCli.SignatureAudience; if empty, it is the Host of
+// the request. Signatures made more than maxSkew before or after now are
+// rejected; maxSkew defaults to 5 minutes if not positive.
+//
+// Signatures carry no nonce: a captured request may be replayed as a
+// SaveNodes request to the same audience within maxSkew of its signing. A
+// replayed request saves the same nodes again, which changes nothing as
+// nodes are immutable; services sharing an audience accept each other's
+// requests.
+//
+// It returns ErrUnsigned if the request has no signature and an error
+// wrapping ErrInvalidSignature if the signature is not valid.
+func VerifyRequest(r *http.Request, body []byte, audience string,
+	maxSkew time.Duration) (SignerIdentity, error) {
+
+	return verifyRequest(r, body, audience, maxSkew, time.Now())
+}
+
+func verifyRequest(r *http.Request, body []byte, audience string,
+	maxSkew time.Duration, now time.Time) (SignerIdentity, error) {
+
+	sigHex := r.Header.Get(HeaderSignature)
+	if sigHex == "" {
+		return SignerIdentity{}, ErrUnsigned
+	}
+	invalid := func(format string, args ...any) (SignerIdentity, error) {
+		return SignerIdentity{}, fmt.Errorf("%w: %v", ErrInvalidSignature,
+			fmt.Sprintf(format, args...))
+	}
+
+	sig, err := hex.DecodeString(strings.TrimPrefix(sigHex, "0x"))
+	if err != nil {
+		return invalid("malformed signature")
+	}
+	timestamp := r.Header.Get(HeaderTimestamp)
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return invalid("malformed timestamp")
+	}
+	id := SignerIdentity{
+		Scheme:   r.Header.Get(HeaderSignatureScheme),
+		Identity: r.Header.Get(HeaderIdentity),
+		SignedAt: time.Unix(secs, 0),
+	}
+
+	if maxSkew <= 0 {
+		maxSkew = defaultSignatureMaxSkew
+	}
+	if skew := now.Sub(id.SignedAt); skew > maxSkew || skew < -maxSkew {
+		return invalid("signed at %v", id.SignedAt.UTC())
+	}
+
+	if audience == "" {
+		audience = r.Host
+	}
+	digest := signingDigest(audience, operationSaveNodes, r.Method, timestamp,
+		body)
+	switch id.Scheme {
+	case SchemeBabyJubJub:
+		pub, err := id.BabyJubJubKey()
+		if err != nil {
+			return invalid("malformed public key")
+		}
+		var comp babyjub.SignatureComp
+		if len(sig) != len(comp) {
+			return invalid("malformed signature")
+		}
+		copy(comp[:], sig)
+		s, err := comp.Decompress()
+		if err != nil {
+			return invalid("malformed signature")
+		}
+		if !pub.VerifyPoseidon(digestToField(digest), s) {
+			return invalid("signature mismatch")
+		}
+	case SchemeEthereum:
+		addr, err := id.EthereumAddress()
+		if err != nil {
+			return invalid("malformed address")
+		}
+		if len(sig) != crypto.SignatureLength {
+			return invalid("malformed signature")
+		}
+		sig = append([]byte{}, sig...)
+		if sig[crypto.RecoveryIDOffset] >= 27 {
+			sig[crypto.RecoveryIDOffset] -= 27
+		}
+		pub, err := crypto.SigToPub(digest, sig)
+		if err != nil || crypto.PubkeyToAddress(*pub) != addr {
+			return invalid("signature mismatch")
+		}
+	default:
+		return invalid("unsupported scheme %q", id.Scheme)
+	}
+	return id, nil
+}
+
+// WriteAuthorizer decides whether the signer may save the nodes. A returned
+// error rejects the request with 403 Forbidden.
+type WriteAuthorizer func(ctx context.Context, signer SignerIdentity,
+	nodes []merkletree_proof.Node) error
+
+// WithWriteAuthorizer makes the handler accept only POST /node requests
+// signed as verified by VerifyRequest and allowed by authorize. Node
+// streams at POST /nodes can't be verified before they are saved and are
+// rejected.
+func WithWriteAuthorizer(authorize WriteAuthorizer) HandlerOption {
+	return func(h *Handler) error {
+		if authorize == nil {
+			return errors.New("write authorizer is nil")
+		}
+		h.authorize = authorize
+		return nil
+	}
+}
+
+// WithSignatureMaxSkew sets the maxSkew passed to VerifyRequest by the
+// handler. The default is 5 minutes.
+func WithSignatureMaxSkew(maxSkew time.Duration) HandlerOption {
+	return func(h *Handler) error {
+		if maxSkew <= 0 {
+			return errors.New("max skew must be positive")
+		}
+		h.signatureMaxSkew = maxSkew
+		return nil
+	}
+}
+
+// WithSignatureAudience sets the audience passed to VerifyRequest by the
+// handler. It must match ReverseHashCli.SignatureAudience of the clients.
+// By default it is the Host of each request, which proxies may rewrite.
+func WithSignatureAudience(audience string) HandlerOption {
+	return func(h *Handler) error {
+		if audience == "" {
+			return errors.New("signature audience is empty")
+		}
+		h.signatureAudience = audience
+		return nil
+	}
+}
+
+// authorizeWrite verifies the signature of the request and authorizes the
+// signer to save the nodes. It writes the error response and returns false
+// if the request is rejected.
+func (h *Handler) authorizeWrite(w http.ResponseWriter, r *http.Request,
+	body []byte, nodes []merkletree_proof.Node) bool {
+
+	signer, err := VerifyRequest(r, body, h.signatureAudience,
+		h.signatureMaxSkew)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return false
+	}
+	err = h.authorize(r.Context(), signer, nodes)
+	if err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,222 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/iden3/go-iden3-crypto/babyjub"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+	"github.com/iden3/merkletree-proof/internal/testtree"
+	mpmemory "github.com/iden3/merkletree-proof/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func testSigners(t testing.TB) map[string]RequestSigner {
+	bjjKey := babyjub.NewRandPrivKey()
+	bjjSigner, err := NewBabyJubJubSigner(&bjjKey)
+	require.NoError(t, err)
+	ethKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	ethSigner, err := NewEthereumSigner(ethKey)
+	require.NoError(t, err)
+	return map[string]RequestSigner{
+		SchemeBabyJubJub: bjjSigner,
+		SchemeEthereum:   ethSigner,
+	}
+}
+
+func TestVerifyRequest(t *testing.T) {
+	body := []byte(`[{"hash":"01"}]`)
+	now := time.Unix(1700000000, 0)
+
+	for scheme, signer := range testSigners(t) {
+		t.Run(scheme, func(t *testing.T) {
+			newRequest := func() *http.Request {
+				req, err := http.NewRequest(http.MethodPost,
+					"http://localhost/node", bytes.NewReader(body))
+				require.NoError(t, err)
+				require.NoError(t, signRequest(signer, req, "",
+					operationSaveNodes, body, now))
+				return req
+			}
+
+			id, err := verifyRequest(newRequest(), body, "", 0,
+				now.Add(time.Minute))
+			require.NoError(t, err)
+			require.Equal(t, SignerIdentity{Scheme: scheme,
+				Identity: signer.Identity(), SignedAt: now}, id)
+
+			switch scheme {
+			case SchemeBabyJubJub:
+				pub, err := id.BabyJubJubKey()
+				require.NoError(t, err)
+				require.Equal(t, signer.Identity(), pub.Compress().String())
+				_, err = id.EthereumAddress()
+				require.Error(t, err)
+			case SchemeEthereum:
+				addr, err := id.EthereumAddress()
+				require.NoError(t, err)
+				require.Equal(t, signer.Identity(), addr.Hex())
+			}
+
+			_, err = verifyRequest(newRequest(), []byte(`[]`), "", 0, now)
+			require.ErrorIs(t, err, ErrInvalidSignature)
+
+			_, err = verifyRequest(newRequest(), body, "", 0,
+				now.Add(6*time.Minute))
+			require.ErrorIs(t, err, ErrInvalidSignature)
+			_, err = verifyRequest(newRequest(), body, "", time.Hour,
+				now.Add(6*time.Minute))
+			require.NoError(t, err)
+
+			// signed for another service
+			req := newRequest()
+			req.Host = "rhs.example.com"
+			_, err = verifyRequest(req, body, "", 0, now)
+			require.ErrorIs(t, err, ErrInvalidSignature)
+			_, err = verifyRequest(newRequest(), body, "rhs.example.com", 0,
+				now)
+			require.ErrorIs(t, err, ErrInvalidSignature)
+
+			req = newRequest()
+			req.Method = http.MethodPut
+			_, err = verifyRequest(req, body, "", 0, now)
+			require.ErrorIs(t, err, ErrInvalidSignature)
+
+			// signed for another operation, even if sent to the same path
+			req, err = http.NewRequest(http.MethodPost,
+				"http://localhost/node", bytes.NewReader(body))
+			require.NoError(t, err)
+			require.NoError(t, signRequest(signer, req, "", "DeleteNodes",
+				body, now))
+			_, err = verifyRequest(req, body, "", 0, now)
+			require.ErrorIs(t, err, ErrInvalidSignature)
+
+			// an identity of another signer
+			req = newRequest()
+			other := testSigners(t)[scheme]
+			req.Header.Set(HeaderIdentity, other.Identity())
+			_, err = verifyRequest(req, body, "", 0, now)
+			require.ErrorIs(t, err, ErrInvalidSignature)
+
+			req = newRequest()
+			req.Header.Del(HeaderSignature)
+			_, err = verifyRequest(req, body, "", 0, now)
+			require.ErrorIs(t, err, ErrUnsigned)
+
+			req = newRequest()
+			req.Header.Set(HeaderSignatureScheme, "rsa")
+			_, err = verifyRequest(req, body, "", 0, now)
+			require.EqualError(t, err,
+				`invalid request signature: unsupported scheme "rsa"`)
+		})
+	}
+}
+
+func TestHandler_WriteAuthorizer(t *testing.T) {
+	ctx := context.Background()
+	signers := testSigners(t)
+	allowed := signers[SchemeEthereum]
+
+	store := mpmemory.NewReverseHashCli()
+	var authorized []SignerIdentity
+	h, err := NewHandler(store, WithWriteAuthorizer(
+		func(_ context.Context, signer SignerIdentity,
+			_ []merkletree_proof.Node) error {
+
+			if signer.Identity != allowed.Identity() {
+				return errors.New("unknown issuer")
+			}
+			authorized = append(authorized, signer)
+			return nil
+		}))
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	mt := testtree.Build(t, 1, 2, 3)
+	nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+
+	cli := &ReverseHashCli{URL: srv.URL}
+	info, err := cli.Info(ctx)
+	require.NoError(t, err)
+	require.True(t, info.Supports(FeatureSignedWrites))
+	require.False(t, info.Supports(FeatureStreaming))
+
+	var respErr *ResponseError
+	err = cli.SaveNodes(ctx, nodes)
+	require.ErrorAs(t, err, &respErr)
+	require.Equal(t, http.StatusUnauthorized, respErr.StatusCode)
+	require.Equal(t, "request is not signed", respErr.Message)
+
+	cli.Signer = signers[SchemeBabyJubJub]
+	err = cli.SaveNodes(ctx, nodes)
+	require.ErrorAs(t, err, &respErr)
+	require.Equal(t, http.StatusForbidden, respErr.StatusCode)
+	require.Equal(t, "unknown issuer", respErr.Message)
+	require.Zero(t, store.Len())
+
+	cli.Signer = allowed
+	require.NoError(t, cli.SaveNodes(ctx, nodes))
+	require.Equal(t, len(nodes), store.Len())
+	require.Len(t, authorized, 1)
+	require.Equal(t, SchemeEthereum, authorized[0].Scheme)
+
+	// streams are saved with signed requests
+	cli.SaveChunkSize = 2
+	offset, err := cli.SaveNodesStream(ctx, NodeSliceSource(nodes), 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(nodes)), offset)
+	require.Len(t, authorized, 1+(len(nodes)+1)/2)
+
+	cli.Signer = nil
+	_, err = cli.SaveNodesStream(ctx, NodeSliceSource(nodes), 0)
+	require.ErrorAs(t, err, &respErr)
+	require.Equal(t, http.StatusForbidden, respErr.StatusCode)
+
+	// request editors can't replace the signature
+	cli = &ReverseHashCli{URL: srv.URL, Signer: allowed,
+		RequestEditors: []RequestEditor{
+			func(_ context.Context, req *http.Request) error {
+				req.Header.Set(HeaderSignature, "00")
+				req.Header.Set(HeaderIdentity, "someone")
+				return nil
+			}}}
+	require.NoError(t, cli.SaveNodes(ctx, nodes))
+}
+
+func TestHandler_SignatureAudience(t *testing.T) {
+	ctx := context.Background()
+	signer := testSigners(t)[SchemeEthereum]
+	h, err := NewHandler(mpmemory.NewReverseHashCli(),
+		WithSignatureAudience("rhs.example.com"),
+		WithWriteAuthorizer(func(context.Context, SignerIdentity,
+			[]merkletree_proof.Node) error {
+
+			return nil
+		}))
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	mt := testtree.Build(t, 1, 2, 3)
+	nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+
+	// signed for the host of the URL by default
+	cli := &ReverseHashCli{URL: srv.URL, Signer: signer}
+	var respErr *ResponseError
+	err = cli.SaveNodes(ctx, nodes)
+	require.ErrorAs(t, err, &respErr)
+	require.Equal(t, http.StatusUnauthorized, respErr.StatusCode)
+
+	cli.SignatureAudience = "rhs.example.com"
+	require.NoError(t, cli.SaveNodes(ctx, nodes))
+}
@@ -0,0 +1,548 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+)
+
+// ndjsonContentType is the content type of node streams: one JSON node per
+// line.
+const ndjsonContentType = "application/x-ndjson"
+
+// maxStreamLineSize limits the size of a line of a node stream. A node with
+// three children takes about 300 bytes.
+const maxStreamLineSize = 64 << 10
+
+// defaultStreamChunkSize is the number of nodes saved with one request when
+// SaveNodesStream falls back to SaveNodes and the chunk size is unknown.
+const defaultStreamChunkSize = 500
+
+// streamStatus is the response to a node stream upload and the last line of
+// a node stream export. Offset is the position in the stream of the first
+// node not saved or not sent.
+type streamStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Offset int64  `json:"offset"`
+}
+
+// WithStreamBatchSize sets the number of streamed nodes the handler saves
+// with one NodeStore.SaveNodes call, and the number of exported nodes after
+// which the response is flushed. The default is 500.
+func WithStreamBatchSize(size int) HandlerOption {
+	return func(h *Handler) error {
+		if size <= 0 {
+			return errors.New("stream batch size must be positive")
+		}
+		h.streamBatchSize = size
+		return nil
+	}
+}
+
+// WithMaxStreamSize limits the size of the POST /nodes request body. A
+// client that hits the limit may resume the upload after the saved nodes
+// with another request. The default is 1 GiB.
+func WithMaxStreamSize(size int64) HandlerOption {
+	return func(h *Handler) error {
+		if size <= 0 {
+			return errors.New("max stream size must be positive")
+		}
+		h.maxStreamSize = size
+		return nil
+	}
+}
+
+// saveNodeStream saves nodes streamed in the request body in batches. The
+// body is read only as fast as the nodes are saved.
+func (h *Handler) saveNodeStream(w http.ResponseWriter, r *http.Request) {
+	offset, err := parseOffset(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	saved := offset
+	batch := make([]merkletree_proof.Node, 0, h.streamBatchSize)
+	save := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := h.store.SaveNodes(r.Context(), batch)
+		if err != nil {
+			return err
+		}
+		saved += int64(len(batch))
+		batch = make([]merkletree_proof.Node, 0, h.streamBatchSize)
+		return nil
+	}
+	// fail saves the nodes decoded so far, so the client may resume after
+	// them, and responds with the error
+	fail := func(code int, err error) {
+		if saveErr := save(); saveErr != nil {
+			code, err = http.StatusInternalServerError, saveErr
+		}
+		writeJSON(w, code, streamStatus{Status: statusError,
+			Error: err.Error(), Offset: saved})
+	}
+
+	sc := bufio.NewScanner(http.MaxBytesReader(w, r.Body, h.maxStreamSize))
+	sc.Buffer(make([]byte, 4096), maxStreamLineSize)
+	pos := offset
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var n merkletree_proof.Node
+		err = json.Unmarshal(line, &n)
+		if err != nil && sc.Err() != nil {
+			// the scanner returns a line cut by a read error before
+			// the error
+			break
+		} else if err != nil {
+			fail(http.StatusBadRequest,
+				fmt.Errorf("can't decode node #%v: %w", pos, err))
+			return
+		}
+		err = n.Validate()
+		if err != nil {
+			fail(http.StatusBadRequest,
+				fmt.Errorf("invalid node #%v: %w", pos, err))
+			return
+		}
+		pos++
+
+		batch = append(batch, n)
+		if len(batch) >= h.streamBatchSize {
+			err = save()
+			if err != nil {
+				fail(http.StatusInternalServerError, err)
+				return
+			}
+		}
+	}
+	if err = sc.Err(); err != nil {
+		fail(http.StatusBadRequest,
+			fmt.Errorf("can't read node #%v: %w", pos, err))
+		return
+	}
+
+	err = save()
+	if err != nil {
+		fail(http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, streamStatus{Status: statusOK, Offset: saved})
+}
+
+// exportNodes streams all nodes reachable from the root, starting from the
+// node at the offset, and ends the stream with a streamStatus line. The
+// walk always starts from the root and skips the nodes before the offset, so
+// resuming costs as much as reading them again from the store.
+func (h *Handler) exportNodes(w http.ResponseWriter, r *http.Request,
+	hashHex string) {
+
+	root, err := merkletree.NewHashFromHex(hashHex)
+	if err != nil {
+		writeError(w, http.StatusBadRequest,
+			fmt.Errorf("invalid node hash: %w", err))
+		return
+	}
+	offset, err := parseOffset(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	_, err = h.store.GetNode(r.Context(), root)
+	if errors.Is(err, abicsr.ErrNodeNotFound) {
+		writeJSON(w, http.StatusNotFound, statusResponse{Status: statusNotFound})
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	flusher, _ := w.(http.Flusher)
+	var pos int64
+	err = merkletree_proof.WalkReachableNodes(r.Context(), h.store,
+		[]*merkletree.Hash{root}, merkletree_proof.NewMemoryHashSet(),
+		func(n merkletree_proof.Node) error {
+			if pos < offset {
+				pos++
+				return nil
+			}
+			err := enc.Encode(n)
+			if err != nil {
+				return err
+			}
+			pos++
+			if (pos-offset)%int64(h.streamBatchSize) == 0 {
+				err = bw.Flush()
+				if err != nil {
+					return err
+				}
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+			return nil
+		})
+
+	status := streamStatus{Status: statusOK, Offset: pos}
+	if err != nil {
+		status = streamStatus{Status: statusError, Error: err.Error(),
+			Offset: pos}
+	}
+	_ = enc.Encode(status)
+	_ = bw.Flush()
+}
+
+func parseOffset(r *http.Request) (int64, error) {
+	v := r.URL.Query().Get("offset")
+	if v == "" {
+		return 0, nil
+	}
+	offset, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("invalid offset: %v", v)
+	}
+	return offset, nil
+}
+
+// NodeSource returns nodes to stream one at a time and io.EOF after the
+// last one.
+type NodeSource func() (merkletree_proof.Node, error)
+
+// NodeSliceSource returns a NodeSource of the nodes.
+func NodeSliceSource(nodes []merkletree_proof.Node) NodeSource {
+	i := 0
+	return func() (merkletree_proof.Node, error) {
+		if i >= len(nodes) {
+			return merkletree_proof.Node{}, io.EOF
+		}
+		i++
+		return nodes[i-1], nil
+	}
+}
+
+// SaveNodesStream saves nodes from the source with a single streaming
+// request, without holding them in memory. Nodes are read from the source
+// only as fast as the service saves them.
+//
+// The first offset nodes of the source are skipped. The returned offset is
+// the position of the first node not known to be saved, also on failure, so
+// an interrupted upload may be resumed by passing it along with a new source
+// of the same nodes. Nodes after that offset may have been saved too, which
+// is harmless.
+//
+// The HTTPTimeout does not apply to the stream, only the context does. If
+// Signer is set, or Discover is set and the service does not support
+// streaming, the nodes are saved in chunks with SaveNodes requests.
+func (cli *ReverseHashCli) SaveNodesStream(ctx context.Context,
+	src NodeSource, offset int64) (int64, error) {
+
+	if cli.URL == "" {
+		return offset, errors.New(
+			"HTTP reverse hash service url is not specified")
+	}
+	if offset < 0 {
+		return offset, errors.New("offset must not be negative")
+	}
+	err := skipNodes(src, offset)
+	if err != nil {
+		return offset, err
+	}
+	if cli.Signer != nil ||
+		(cli.Discover && !cli.supports(ctx, FeatureStreaming)) {
+
+		return cli.saveNodesChunked(ctx, src, offset)
+	}
+
+	release, err := cli.Limiter.Acquire(ctx)
+	if err != nil {
+		return offset, err
+	}
+	defer release()
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pw.CloseWithError(writeNodeStream(pw, src))
+	}()
+	defer func() {
+		_ = pr.Close()
+		<-done
+	}()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		cli.baseURL()+"/nodes?offset="+strconv.FormatInt(offset, 10), pr)
+	if err != nil {
+		return offset, err
+	}
+	httpReq.Header.Set("Content-Type", ndjsonContentType)
+
+	httpResp, err := cli.do(ctx, httpReq, nil)
+	if err != nil {
+		return offset, err
+	}
+	defer func() { _ = httpResp.Body.Close() }()
+
+	data, err := io.ReadAll(io.LimitReader(httpResp.Body, maxErrorBodySize))
+	if err != nil {
+		return offset, err
+	}
+	var status streamStatus
+	if json.Unmarshal(data, &status) == nil && status.Offset > offset {
+		offset = status.Offset
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		return offset, newResponseError(httpResp, bytes.NewReader(data), nil)
+	}
+	if status.Status != statusOK {
+		return offset, &ResponseError{
+			StatusCode: httpResp.StatusCode,
+			Status:     status.Status,
+			Message:    status.Error,
+			Method:     httpReq.Method,
+			URL:        httpReq.URL.String(),
+		}
+	}
+	return offset, nil
+}
+
+func writeNodeStream(w io.Writer, src NodeSource) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for {
+		n, err := src()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+		err = enc.Encode(n)
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// saveNodesChunked saves nodes from the source with SaveNodes requests.
+func (cli *ReverseHashCli) saveNodesChunked(ctx context.Context,
+	src NodeSource, offset int64) (int64, error) {
+
+	size := cli.SaveChunkSize
+	if size <= 0 {
+		size = cli.discoveredChunkSize(ctx)
+	}
+	if size <= 0 {
+		size = defaultStreamChunkSize
+	}
+
+	chunk := make([]merkletree_proof.Node, 0, size)
+	for {
+		n, err := src()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return offset, err
+		}
+		if err == nil {
+			chunk = append(chunk, n)
+			if len(chunk) < size {
+				continue
+			}
+		}
+
+		if len(chunk) > 0 {
+			saveErr := cli.saveNodesObserved(ctx, chunk)
+			if saveErr != nil {
+				return offset, saveErr
+			}
+			offset += int64(len(chunk))
+			chunk = chunk[:0]
+		}
+		if err != nil {
+			return offset, nil
+		}
+	}
+}
+
+func skipNodes(src NodeSource, n int64) error {
+	for i := int64(0); i < n; i++ {
+		_, err := src()
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("source has %v nodes, less than the offset %v",
+				i, n)
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportNodes streams all nodes reachable from the root from the service
+// and calls fn for each of them, starting from the node at the offset. A
+// root may be a state node or a root of a tree, as in
+// merkletree_proof.WalkReachable, and the order of nodes is the same for
+// the same stored nodes. The stream is read only as fast as fn returns.
+//
+// The returned offset is the position of the node after the last one
+// passed to fn, also on failure, so an interrupted export may be resumed by
+// passing it to another call. The service walks the tree from the root
+// again and skips the nodes before the offset, so a resumed export reads
+// all of them from its store once more. An error returned by fn stops the
+// export and is returned as is.
+//
+// The HTTPTimeout does not apply to the stream, only the context does. If
+// Discover is set and the service does not support streaming, nodes are
+// fetched one by one with GetNode.
+func (cli *ReverseHashCli) ExportNodes(ctx context.Context,
+	root *merkletree.Hash, offset int64,
+	fn func(n merkletree_proof.Node) error) (int64, error) {
+
+	if cli.URL == "" {
+		return offset, errors.New(
+			"HTTP reverse hash service url is not specified")
+	}
+	if root == nil {
+		return offset, errors.New("root is nil")
+	}
+	if offset < 0 {
+		return offset, errors.New("offset must not be negative")
+	}
+	if cli.Discover && !cli.supports(ctx, FeatureStreaming) {
+		return cli.exportNodesByOne(ctx, root, offset, fn)
+	}
+
+	release, err := cli.Limiter.Acquire(ctx)
+	if err != nil {
+		return offset, err
+	}
+	defer release()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		cli.baseURL()+"/nodes/"+root.Hex()+"?offset="+
+			strconv.FormatInt(offset, 10), http.NoBody)
+	if err != nil {
+		return offset, err
+	}
+	httpReq.Header.Set("Accept", ndjsonContentType)
+
+	httpResp, err := cli.do(ctx, httpReq, nil)
+	if err != nil {
+		return offset, err
+	}
+	defer func() { _ = httpResp.Body.Close() }()
+
+	switch {
+	case httpResp.StatusCode == http.StatusNotFound:
+		respErr := newResponseError(httpResp, httpResp.Body, root)
+		if respErr.Status == statusNotFound {
+			return offset, abicsr.ErrNodeNotFound
+		}
+		return offset, respErr
+	case httpResp.StatusCode != http.StatusOK:
+		return offset, newResponseError(httpResp, httpResp.Body, root)
+	}
+
+	sc := bufio.NewScanner(httpResp.Body)
+	sc.Buffer(make([]byte, 4096), maxStreamLineSize)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var status streamStatus
+		err = json.Unmarshal(line, &status)
+		if err != nil {
+			return offset, fmt.Errorf("unable to decode node #%v: %w",
+				offset, err)
+		}
+		if status.Status != "" {
+			if status.Status == statusOK {
+				return offset, nil
+			}
+			return offset, &ResponseError{
+				StatusCode: httpResp.StatusCode,
+				Status:     status.Status,
+				Message:    status.Error,
+				Method:     httpReq.Method,
+				URL:        httpReq.URL.String(),
+				Hash:       root,
+			}
+		}
+
+		var n merkletree_proof.Node
+		err = json.Unmarshal(line, &n)
+		if err != nil {
+			return offset, fmt.Errorf("unable to decode node #%v: %w",
+				offset, err)
+		}
+		err = fn(n)
+		if err != nil {
+			return offset, err
+		}
+		offset++
+	}
+
+	err = sc.Err()
+	if err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	return offset, fmt.Errorf("node stream ended unexpectedly: %w", err)
+}
+
+// exportNodesByOne walks the nodes reachable from the root with GetNode.
+func (cli *ReverseHashCli) exportNodesByOne(ctx context.Context,
+	root *merkletree.Hash, offset int64,
+	fn func(n merkletree_proof.Node) error) (int64, error) {
+
+	_, err := cli.GetNode(ctx, root)
+	if err != nil {
+		return offset, err
+	}
+
+	var pos int64
+	err = merkletree_proof.WalkReachableNodes(ctx, cli,
+		[]*merkletree.Hash{root}, merkletree_proof.NewMemoryHashSet(),
+		func(n merkletree_proof.Node) error {
+			if pos < offset {
+				pos++
+				return nil
+			}
+			err := fn(n)
+			if err != nil {
+				return err
+			}
+			pos++
+			return nil
+		})
+	if pos < offset {
+		pos = offset
+	}
+	return pos, err
+}
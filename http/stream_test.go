@@ -0,0 +1,240 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+	"github.com/iden3/merkletree-proof/internal/testtree"
+	mpmemory "github.com/iden3/merkletree-proof/memory"
+	"github.com/stretchr/testify/require"
+)
+
+// batchStore records sizes of SaveNodes batches and fails them after
+// failAfter batches if it is positive.
+type batchStore struct {
+	NodeStore
+
+	mu        sync.Mutex
+	batches   []int
+	failAfter int
+}
+
+func (s *batchStore) SaveNodes(ctx context.Context,
+	nodes []merkletree_proof.Node) error {
+
+	s.mu.Lock()
+	if s.failAfter > 0 && len(s.batches) >= s.failAfter {
+		s.mu.Unlock()
+		return errors.New("disk full")
+	}
+	s.batches = append(s.batches, len(nodes))
+	s.mu.Unlock()
+	return s.NodeStore.SaveNodes(ctx, nodes)
+}
+
+func TestReverseHashCli_SaveNodesStream(t *testing.T) {
+	ctx := context.Background()
+	mem := mpmemory.NewReverseHashCli()
+	store := &batchStore{NodeStore: mem, failAfter: 2}
+	h, err := NewHandler(store, WithStreamBatchSize(3))
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	mt := testtree.Build(t, 1, 2, 3, 4, 5, 6)
+	nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+	require.Greater(t, len(nodes), 6)
+
+	cli := &ReverseHashCli{URL: srv.URL}
+	offset, err := cli.SaveNodesStream(ctx, NodeSliceSource(nodes), 0)
+	var respErr *ResponseError
+	require.ErrorAs(t, err, &respErr)
+	require.Equal(t, http.StatusInternalServerError, respErr.StatusCode)
+	require.Equal(t, "disk full", respErr.Message)
+	require.Equal(t, int64(6), offset)
+	require.Equal(t, 6, mem.Len())
+
+	// resume after the saved nodes
+	store.failAfter = 0
+	offset, err = cli.SaveNodesStream(ctx, NodeSliceSource(nodes), offset)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(nodes)), offset)
+	require.Equal(t, len(nodes), mem.Len())
+	for _, b := range store.batches {
+		require.LessOrEqual(t, b, 3)
+	}
+
+	_, err = cli.SaveNodesStream(ctx, NodeSliceSource(nodes),
+		int64(len(nodes)+1))
+	require.EqualError(t, err, fmt.Sprintf(
+		"source has %v nodes, less than the offset %v",
+		len(nodes), len(nodes)+1))
+}
+
+func TestReverseHashCli_SaveNodesStreamMaxSize(t *testing.T) {
+	ctx := context.Background()
+	mem := mpmemory.NewReverseHashCli()
+	h, err := NewHandler(mem, WithStreamBatchSize(2),
+		WithMaxStreamSize(1000))
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	mt := testtree.Build(t, 1, 2, 3, 4, 5, 6)
+	nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+
+	// every request saves the nodes read before the limit, so the upload
+	// completes after a few resumes
+	cli := &ReverseHashCli{URL: srv.URL}
+	var offset int64
+	for i := 0; ; i++ {
+		require.Less(t, i, len(nodes))
+		var next int64
+		next, err = cli.SaveNodesStream(ctx, NodeSliceSource(nodes), offset)
+		if err == nil {
+			offset = next
+			break
+		}
+		var respErr *ResponseError
+		require.ErrorAs(t, err, &respErr)
+		require.Equal(t, http.StatusBadRequest, respErr.StatusCode)
+		require.Contains(t, respErr.Message, "request body too large")
+		require.Greater(t, next, offset)
+		offset = next
+	}
+	require.Equal(t, int64(len(nodes)), offset)
+	require.Equal(t, len(nodes), mem.Len())
+
+	_, err = NewHandler(mem, WithMaxStreamSize(0))
+	require.EqualError(t, err, "max stream size must be positive")
+}
+
+func TestReverseHashCli_SaveNodesStreamInvalidNode(t *testing.T) {
+	ctx := context.Background()
+	mem := mpmemory.NewReverseHashCli()
+	h, err := NewHandler(mem)
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	mt := testtree.Build(t, 1, 2, 3)
+	nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+	bad := merkletree_proof.Node{Hash: &hashOne,
+		Children: []*merkletree.Hash{&hashOne, &hashOne}}
+	stream := append(append([]merkletree_proof.Node{}, nodes...), bad)
+
+	cli := &ReverseHashCli{URL: srv.URL}
+	offset, err := cli.SaveNodesStream(ctx, NodeSliceSource(stream), 0)
+	var respErr *ResponseError
+	require.ErrorAs(t, err, &respErr)
+	require.Equal(t, http.StatusBadRequest, respErr.StatusCode)
+	require.True(t, strings.HasPrefix(respErr.Message, "invalid node #"))
+	// the valid nodes before the invalid one are saved
+	require.Equal(t, int64(len(nodes)), offset)
+	require.Equal(t, len(nodes), mem.Len())
+
+	// source errors abort the stream
+	srcErr := errors.New("source failed")
+	_, err = cli.SaveNodesStream(ctx, func() (merkletree_proof.Node, error) {
+		return merkletree_proof.Node{}, srcErr
+	}, 0)
+	require.ErrorIs(t, err, srcErr)
+}
+
+func TestReverseHashCli_ExportNodes(t *testing.T) {
+	ctx := context.Background()
+	mem := mpmemory.NewReverseHashCli()
+	mt := testtree.Build(t, 1, 2, 3, 4, 5, 6)
+	nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+	require.NoError(t, mem.SaveNodes(ctx, nodes))
+
+	h, err := NewHandler(mem, WithStreamBatchSize(2))
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	cli := &ReverseHashCli{URL: srv.URL}
+
+	var all []merkletree_proof.Node
+	offset, err := cli.ExportNodes(ctx, mt.Root(), 0,
+		func(n merkletree_proof.Node) error {
+			all = append(all, n)
+			return nil
+		})
+	require.NoError(t, err)
+	require.Equal(t, int64(len(nodes)), offset)
+	require.ElementsMatch(t, nodes, all)
+
+	// interrupt the export and resume it
+	stop := errors.New("stop")
+	var got []merkletree_proof.Node
+	collect := func(n merkletree_proof.Node) error {
+		if len(got) == 5 {
+			return stop
+		}
+		got = append(got, n)
+		return nil
+	}
+	offset, err = cli.ExportNodes(ctx, mt.Root(), 0, collect)
+	require.ErrorIs(t, err, stop)
+	require.Equal(t, int64(5), offset)
+	offset, err = cli.ExportNodes(ctx, mt.Root(), offset,
+		func(n merkletree_proof.Node) error {
+			got = append(got, n)
+			return nil
+		})
+	require.NoError(t, err)
+	require.Equal(t, int64(len(nodes)), offset)
+	require.Equal(t, all, got)
+
+	_, err = cli.ExportNodes(ctx, &hashOne, 0,
+		func(merkletree_proof.Node) error { return nil })
+	require.ErrorIs(t, err, abicsr.ErrNodeNotFound)
+}
+
+func TestReverseHashCli_StreamLegacy(t *testing.T) {
+	ctx := context.Background()
+	mem := mpmemory.NewReverseHashCli()
+	h, err := NewHandler(mem)
+	require.NoError(t, err)
+	srv := &uploadServer{handler: h}
+	mux := http.NewServeMux()
+	mux.Handle("/node", srv)
+	mux.Handle("/node/", srv)
+	httpSrv := httptest.NewServer(mux)
+	defer httpSrv.Close()
+
+	mt := testtree.Build(t, 1, 2, 3, 4, 5, 6)
+	nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+
+	cli := &ReverseHashCli{URL: httpSrv.URL, Discover: true,
+		SaveChunkSize: 4}
+	offset, err := cli.SaveNodesStream(ctx, NodeSliceSource(nodes), 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(nodes)), offset)
+	require.Equal(t, len(nodes), mem.Len())
+	require.Equal(t, (len(nodes)+3)/4, srv.posts)
+
+	var got []merkletree_proof.Node
+	offset, err = cli.ExportNodes(ctx, mt.Root(), 2,
+		func(n merkletree_proof.Node) error {
+			got = append(got, n)
+			return nil
+		})
+	require.NoError(t, err)
+	require.Equal(t, int64(len(nodes)), offset)
+	require.Len(t, got, len(nodes)-2)
+}
@@ -0,0 +1,209 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	merkletree_proof "github.com/iden3/merkletree-proof"
+)
+
+// WithSaveChunkSize sets ReverseHashCli.SaveChunkSize.
+func WithSaveChunkSize(size int) Option {
+	return func(cli *ReverseHashCli) error {
+		if size < 0 {
+			return errors.New("chunk size must not be negative")
+		}
+		cli.SaveChunkSize = size
+		return nil
+	}
+}
+
+// WithSaveParallelism sets ReverseHashCli.SaveParallelism.
+func WithSaveParallelism(n int) Option {
+	return func(cli *ReverseHashCli) error {
+		if n < 0 {
+			return errors.New("parallelism must not be negative")
+		}
+		cli.SaveParallelism = n
+		return nil
+	}
+}
+
+// ChunkResult is the outcome of uploading one chunk of nodes.
+type ChunkResult struct {
+	// Start and End are bounds of the chunk in the uploaded nodes:
+	// nodes[Start:End].
+	Start    int
+	End      int
+	Err      error
+	Duration time.Duration
+}
+
+// UploadReport holds the result of every chunk of an upload, ordered by
+// position in the uploaded nodes.
+type UploadReport struct {
+	// Nodes is the number of uploaded nodes.
+	Nodes  int
+	Chunks []ChunkResult
+}
+
+// Done reports whether all chunks were saved.
+func (r UploadReport) Done() bool {
+	return len(r.Failed()) == 0
+}
+
+// Succeeded returns indexes of chunks that were saved.
+func (r UploadReport) Succeeded() []int {
+	var idxs []int
+	for i, c := range r.Chunks {
+		if c.Err == nil {
+			idxs = append(idxs, i)
+		}
+	}
+	return idxs
+}
+
+// Failed returns indexes of chunks that were not saved.
+func (r UploadReport) Failed() []int {
+	var idxs []int
+	for i, c := range r.Chunks {
+		if c.Err != nil {
+			idxs = append(idxs, i)
+		}
+	}
+	return idxs
+}
+
+// UploadError is returned when some chunks of an upload failed. The upload
+// may be resumed with ResumeUpload.
+type UploadError struct {
+	Report UploadReport
+}
+
+func (e *UploadError) Error() string {
+	var errs []string
+	for i, c := range e.Report.Chunks {
+		if c.Err != nil {
+			errs = append(errs, fmt.Sprintf("chunk #%v (nodes %v-%v): %v",
+				i, c.Start, c.End, c.Err))
+		}
+	}
+	return fmt.Sprintf("failed to save %v of %v chunks: %v", len(errs),
+		len(e.Report.Chunks), strings.Join(errs, "; "))
+}
+
+// Upload saves nodes in chunks of SaveChunkSize nodes, uploading up to
+// SaveParallelism chunks at once. Chunks are retried according to the retry
+// policy if it allows retrying SaveNodes. The report is returned even if
+// some chunks failed, in which case the error is an *UploadError and the
+// upload may be resumed with ResumeUpload.
+func (cli *ReverseHashCli) Upload(ctx context.Context,
+	nodes []merkletree_proof.Node) (UploadReport, error) {
+
+	return cli.upload(ctx, nodes, cli.SaveChunkSize)
+}
+
+func (cli *ReverseHashCli) upload(ctx context.Context,
+	nodes []merkletree_proof.Node, size int) (UploadReport, error) {
+
+	if size <= 0 {
+		size = len(nodes)
+	}
+
+	report := UploadReport{Nodes: len(nodes)}
+	for start := 0; start < len(nodes); start += size {
+		end := start + size
+		if end > len(nodes) {
+			end = len(nodes)
+		}
+		report.Chunks = append(report.Chunks,
+			ChunkResult{Start: start, End: end})
+	}
+
+	idxs := make([]int, len(report.Chunks))
+	for i := range idxs {
+		idxs[i] = i
+	}
+	cli.uploadChunks(ctx, nodes, report.Chunks, idxs)
+	return report, uploadError(report)
+}
+
+// ResumeUpload uploads once more the chunks that failed in the report and
+// returns the updated report. The nodes must be the same as passed to
+// Upload.
+func (cli *ReverseHashCli) ResumeUpload(ctx context.Context,
+	nodes []merkletree_proof.Node, report UploadReport) (UploadReport, error) {
+
+	if report.Nodes != len(nodes) {
+		return report, errors.New("report does not belong to these nodes")
+	}
+
+	newReport := UploadReport{
+		Nodes:  report.Nodes,
+		Chunks: make([]ChunkResult, len(report.Chunks)),
+	}
+	copy(newReport.Chunks, report.Chunks)
+	cli.uploadChunks(ctx, nodes, newReport.Chunks, report.Failed())
+	return newReport, uploadError(newReport)
+}
+
+// uploadChunks uploads the chunks with the given indexes and stores the
+// results in chunks.
+func (cli *ReverseHashCli) uploadChunks(ctx context.Context,
+	nodes []merkletree_proof.Node, chunks []ChunkResult, idxs []int) {
+
+	parallelism := cli.SaveParallelism
+	if parallelism <= 0 {
+		parallelism = 1
+	}
+	sem := make(chan struct{}, parallelism)
+
+	var wg sync.WaitGroup
+	for _, idx := range idxs {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			chunks[idx].Err = ctx.Err()
+			continue
+		}
+
+		wg.Add(1)
+		go func(c *ChunkResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			start := time.Now()
+			c.Err = cli.saveNodesObserved(ctx, nodes[c.Start:c.End])
+			c.Duration = time.Since(start)
+		}(&chunks[idx])
+	}
+	wg.Wait()
+}
+
+// maxNodeJSONSize is an upper bound of the JSON size of a node with three
+// children in a POST /node request.
+const maxNodeJSONSize = 320
+
+// discoveredChunkSize returns the number of nodes that fit the max body size
+// reported by the service, or 0 if it is unknown.
+func (cli *ReverseHashCli) discoveredChunkSize(ctx context.Context) int {
+	info, err := cli.Info(ctx)
+	if err != nil || info.MaxBodySize <= 0 {
+		return 0
+	}
+	size := int(info.MaxBodySize / maxNodeJSONSize)
+	if size < 1 {
+		size = 1
+	}
+	return size
+}
+
+func uploadError(report UploadReport) error {
+	if report.Done() {
+		return nil
+	}
+	return &UploadError{Report: report}
+}
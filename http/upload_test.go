@@ -0,0 +1,136 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	merkletree_proof "github.com/iden3/merkletree-proof"
+	"github.com/iden3/merkletree-proof/internal/testtree"
+	mpmemory "github.com/iden3/merkletree-proof/memory"
+	"github.com/stretchr/testify/require"
+)
+
+// uploadServer counts POST requests and their concurrency, and fails
+// requests containing the poisoned string while it is set.
+type uploadServer struct {
+	handler http.Handler
+
+	mu          sync.Mutex
+	poison      string
+	posts       int
+	inFlight    int
+	maxInFlight int
+}
+
+func (s *uploadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.handler.ServeHTTP(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	s.mu.Lock()
+	s.posts++
+	s.inFlight++
+	if s.inFlight > s.maxInFlight {
+		s.maxInFlight = s.inFlight
+	}
+	poisoned := s.poison != "" && bytes.Contains(body, []byte(s.poison))
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.inFlight--
+		s.mu.Unlock()
+	}()
+
+	time.Sleep(5 * time.Millisecond)
+	if poisoned {
+		writeError(w, http.StatusInternalServerError, errors.New("disk full"))
+		return
+	}
+	s.handler.ServeHTTP(w, r)
+}
+
+func TestReverseHashCli_Upload(t *testing.T) {
+	ctx := context.Background()
+	store := mpmemory.NewReverseHashCli()
+	h, err := NewHandler(store)
+	require.NoError(t, err)
+
+	mt := testtree.Build(t, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+	nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+	require.Greater(t, len(nodes), 12)
+
+	// the root hash is not a child of any node, so only its chunk fails
+	require.Equal(t, mt.Root(), nodes[0].Hash)
+	srv := &uploadServer{handler: h, poison: nodes[0].Hash.Hex()}
+	httpSrv := httptest.NewServer(srv)
+	defer httpSrv.Close()
+
+	cli, err := NewReverseHashCli(httpSrv.URL, WithSaveChunkSize(4),
+		WithSaveParallelism(2))
+	require.NoError(t, err)
+
+	report, err := cli.Upload(ctx, nodes)
+	var uploadErr *UploadError
+	require.ErrorAs(t, err, &uploadErr)
+	require.Equal(t, report, uploadErr.Report)
+	require.Equal(t, len(nodes), report.Nodes)
+	require.Len(t, report.Chunks, (len(nodes)+3)/4)
+	require.Equal(t, []int{0}, report.Failed())
+	require.Equal(t, 4, report.Chunks[1].Start)
+	require.Equal(t, 8, report.Chunks[1].End)
+	require.Equal(t, 2, srv.maxInFlight)
+	require.Equal(t, len(report.Chunks), srv.posts)
+	require.Equal(t, len(nodes)-4, store.Len())
+
+	// only the failed chunk is uploaded again
+	srv.poison = ""
+	report, err = cli.ResumeUpload(ctx, nodes, report)
+	require.NoError(t, err)
+	require.True(t, report.Done())
+	require.Equal(t, len(report.Chunks)+1, srv.posts)
+	require.Equal(t, len(nodes), store.Len())
+
+	_, err = cli.ResumeUpload(ctx, nodes[1:], report)
+	require.Error(t, err)
+}
+
+func TestReverseHashCli_SaveNodesChunked(t *testing.T) {
+	ctx := context.Background()
+	store := mpmemory.NewReverseHashCli()
+	h, err := NewHandler(store)
+	require.NoError(t, err)
+	srv := &uploadServer{handler: h}
+	httpSrv := httptest.NewServer(srv)
+	defer httpSrv.Close()
+
+	mt := testtree.Build(t, 1, 2, 3, 4, 5)
+	nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+
+	cli := &ReverseHashCli{URL: httpSrv.URL, SaveChunkSize: 3}
+	require.NoError(t, cli.SaveNodes(ctx, nodes))
+	require.Equal(t, (len(nodes)+2)/3, srv.posts)
+	require.Equal(t, 1, srv.maxInFlight)
+	require.Equal(t, len(nodes), store.Len())
+
+	// small batches are saved with one request
+	srv.posts = 0
+	require.NoError(t, cli.SaveNodes(ctx, nodes[:3]))
+	require.Equal(t, 1, srv.posts)
+}
@@ -0,0 +1,28 @@
+// Package testrhs provides reverse hash service fakes for tests.
+package testrhs
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/iden3/go-merkletree-sql/v2"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+)
+
+// CountingReader counts GetNode calls of the reader.
+type CountingReader struct {
+	merkletree_proof.NodeReader
+	calls int32
+}
+
+func (r *CountingReader) GetNode(ctx context.Context,
+	hash *merkletree.Hash) (merkletree_proof.Node, error) {
+
+	atomic.AddInt32(&r.calls, 1)
+	return r.NodeReader.GetNode(ctx, hash)
+}
+
+// Calls returns the number of GetNode calls.
+func (r *CountingReader) Calls() int {
+	return int(atomic.LoadInt32(&r.calls))
+}
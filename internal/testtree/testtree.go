@@ -0,0 +1,39 @@
+// Package testtree builds merkle trees for tests.
+package testtree
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/iden3/go-merkletree-sql/v2"
+	"github.com/iden3/go-merkletree-sql/v2/db/memory"
+	"github.com/stretchr/testify/require"
+)
+
+// Build returns a tree in memory with the keys, each with the value of the
+// key multiplied by 10.
+func Build(t testing.TB, keys ...int64) *merkletree.MerkleTree {
+	return BuildIn(t, memory.NewMemoryStorage(), keys...)
+}
+
+// BuildIn is like Build, but stores the tree in s.
+func BuildIn(t testing.TB, s merkletree.Storage,
+	keys ...int64) *merkletree.MerkleTree {
+
+	ctx := context.Background()
+	mt, err := merkletree.NewMerkleTree(ctx, s, 40)
+	require.NoError(t, err)
+	for _, k := range keys {
+		err = mt.Add(ctx, big.NewInt(k), big.NewInt(k*10))
+		require.NoError(t, err)
+	}
+	return mt
+}
+
+// Hash returns the hash with the value i.
+func Hash(t testing.TB, i int64) *merkletree.Hash {
+	h, err := merkletree.NewHashFromBigInt(big.NewInt(i))
+	require.NoError(t, err)
+	return h
+}
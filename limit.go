@@ -0,0 +1,150 @@
+package merkletree_proof
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter limits the rate of requests with a token bucket and the number of
+// requests in flight. It is safe for concurrent use and may be shared by
+// several clients to enforce a common limit. A nil *Limiter does not limit
+// anything.
+type Limiter struct {
+	// slots has a buffer of the max number of requests in flight, or is nil
+	// if the number is not limited
+	slots chan struct{}
+
+	mu     sync.Mutex
+	rate   float64 // tokens per second, 0 if the rate is not limited
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+type LimiterOption func(l *Limiter) error
+
+// WithRateLimit allows perSecond requests per second on average and up to
+// burst requests at once.
+func WithRateLimit(perSecond float64, burst int) LimiterOption {
+	return func(l *Limiter) error {
+		if perSecond <= 0 || math.IsInf(perSecond, 0) || math.IsNaN(perSecond) {
+			return errors.New("rate limit must be positive")
+		}
+		if burst <= 0 {
+			return errors.New("burst must be positive")
+		}
+		l.rate = perSecond
+		l.burst = float64(burst)
+		l.tokens = float64(burst)
+		return nil
+	}
+}
+
+// WithMaxInFlight limits the number of requests in flight.
+func WithMaxInFlight(n int) LimiterOption {
+	return func(l *Limiter) error {
+		if n <= 0 {
+			return errors.New("max in-flight requests must be positive")
+		}
+		l.slots = make(chan struct{}, n)
+		return nil
+	}
+}
+
+func NewLimiter(opts ...LimiterOption) (*Limiter, error) {
+	l := &Limiter{}
+	for _, o := range opts {
+		err := o(l)
+		if err != nil {
+			return nil, err
+		}
+	}
+	l.last = time.Now()
+	return l, nil
+}
+
+// Acquire waits until a request may be sent and returns a function to call
+// when the request is finished. It returns the context error if the context
+// is done before that.
+func (l *Limiter) Acquire(ctx context.Context) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	release = func() {}
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		var once sync.Once
+		release = func() { once.Do(func() { <-l.slots }) }
+	}
+
+	if err = l.wait(ctx); err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// InFlight returns the number of acquired and not yet released requests if
+// their number is limited.
+func (l *Limiter) InFlight() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.slots)
+}
+
+// wait takes a token from the bucket, waiting for it if needed.
+func (l *Limiter) wait(ctx context.Context) error {
+	if l.rate == 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	// reserve the token; the balance may go negative, so that concurrent
+	// callers queue up behind each other
+	l.tokens--
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		l.cancelReservation()
+		return context.DeadlineExceeded
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.cancelReservation()
+		return ctx.Err()
+	}
+}
+
+func (l *Limiter) cancelReservation() {
+	l.mu.Lock()
+	l.tokens++
+	l.mu.Unlock()
+}
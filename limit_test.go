@@ -0,0 +1,102 @@
+package merkletree_proof
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter_MaxInFlight(t *testing.T) {
+	l, err := NewLimiter(WithMaxInFlight(2))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	var inFlight, maxInFlight int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := l.Acquire(ctx)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer release()
+
+			n := atomic.AddInt32(&inFlight, 1)
+			for {
+				m := atomic.LoadInt32(&maxInFlight)
+				if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&inFlight, -1)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(2), maxInFlight)
+	require.Equal(t, 0, l.InFlight())
+
+	// waiting for a slot honours the context
+	release1, err := l.Acquire(ctx)
+	require.NoError(t, err)
+	release2, err := l.Acquire(ctx)
+	require.NoError(t, err)
+	ctx2, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = l.Acquire(ctx2)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// release is idempotent
+	release1()
+	release1()
+	require.Equal(t, 1, l.InFlight())
+	release2()
+}
+
+func TestLimiter_Rate(t *testing.T) {
+	l, err := NewLimiter(WithRateLimit(100, 2))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 7; i++ {
+		release, err := l.Acquire(ctx)
+		require.NoError(t, err)
+		release()
+	}
+	// the burst of 2 is free, the other 5 requests take 10ms each
+	require.GreaterOrEqual(t, time.Since(start), 45*time.Millisecond)
+
+	// a deadline shorter than the wait fails at once and returns the token
+	l, err = NewLimiter(WithRateLimit(1, 1))
+	require.NoError(t, err)
+	_, err = l.Acquire(ctx)
+	require.NoError(t, err)
+	ctx2, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = l.Acquire(ctx2)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	l.mu.Lock()
+	require.InDelta(t, 0, l.tokens, 0.1)
+	l.mu.Unlock()
+}
+
+func TestLimiter_Nil(t *testing.T) {
+	var l *Limiter
+	release, err := l.Acquire(context.Background())
+	require.NoError(t, err)
+	release()
+	require.Equal(t, 0, l.InFlight())
+
+	_, err = NewLimiter(WithRateLimit(0, 1))
+	require.Error(t, err)
+	_, err = NewLimiter(WithMaxInFlight(0))
+	require.Error(t, err)
+}
@@ -0,0 +1,121 @@
+package merkletree_proof
+
+import (
+	"context"
+	"errors"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+)
+
+// Logger is a structured logger. Arguments are alternating keys and values.
+// *slog.Logger implements it.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// Keys of log attributes used by clients and resolvers of this module.
+const (
+	LogKeyBackend         = "backend"
+	LogKeyHash            = "hash"
+	LogKeyTreeRoot        = "tree_root"
+	LogKeyKey             = "key"
+	LogKeyDepth           = "depth"
+	LogKeyTxHash          = "tx_hash"
+	LogKeyNodes           = "nodes"
+	LogKeyDuration        = "duration"
+	LogKeyError           = "error"
+	LogKeyStatusID        = "status_id"
+	LogKeyRevocationNonce = "revocation_nonce"
+)
+
+// NopLogger is a Logger that discards everything.
+type NopLogger struct{}
+
+func (NopLogger) Debug(string, ...any) {}
+func (NopLogger) Info(string, ...any)  {}
+func (NopLogger) Warn(string, ...any)  {}
+func (NopLogger) Error(string, ...any) {}
+
+// LogObserver is an Observer writing calls and proofs to the Logger.
+// Successful calls are logged at the debug level and failed ones at the warn
+// level. A missing node is not a failure.
+type LogObserver struct {
+	Logger Logger
+}
+
+func (o LogObserver) ObserveCall(_ context.Context, info CallInfo) {
+	args := []any{LogKeyBackend, info.Backend}
+	switch info.Op {
+	case OpGetNode:
+		args = append(args, LogKeyHash, hexOrEmpty(info.Hash))
+	case OpSaveNodes, OpHasNodes:
+		args = append(args, LogKeyNodes, info.Nodes)
+	}
+	args = append(args, LogKeyDuration, info.Duration)
+
+	switch {
+	case info.Err == nil:
+		o.Logger.Debug(info.Op.String()+" succeeded", args...)
+	case errors.Is(info.Err, abicsr.ErrNodeNotFound):
+		o.Logger.Debug("node not found", args...)
+	default:
+		o.Logger.Warn(info.Op.String()+" failed",
+			append(args, LogKeyError, info.Err)...)
+	}
+}
+
+func (o LogObserver) ObserveProof(_ context.Context, info ProofInfo) {
+	args := []any{LogKeyTreeRoot, hexOrEmpty(info.TreeRoot),
+		LogKeyKey, hexOrEmpty(info.Key), LogKeyDepth, info.Depth,
+		LogKeyNodes, info.Nodes, LogKeyDuration, info.Duration}
+	if info.Err != nil {
+		o.Logger.Warn("proof generation failed",
+			append(args, LogKeyError, info.Err)...)
+		return
+	}
+	o.Logger.Debug("proof generated", args...)
+}
+
+func hexOrEmpty(h *merkletree.Hash) string {
+	if h == nil {
+		return ""
+	}
+	return h.Hex()
+}
+
+// JoinObservers returns an Observer calling all non-nil observers in order,
+// or nil if there are none.
+func JoinObservers(observers ...Observer) Observer {
+	var joined multiObserver
+	for _, o := range observers {
+		if o != nil {
+			joined = append(joined, o)
+		}
+	}
+	switch len(joined) {
+	case 0:
+		return nil
+	case 1:
+		return joined[0]
+	default:
+		return joined
+	}
+}
+
+type multiObserver []Observer
+
+func (m multiObserver) ObserveCall(ctx context.Context, info CallInfo) {
+	for _, o := range m {
+		o.ObserveCall(ctx, info)
+	}
+}
+
+func (m multiObserver) ObserveProof(ctx context.Context, info ProofInfo) {
+	for _, o := range m {
+		o.ObserveProof(ctx, info)
+	}
+}
@@ -0,0 +1,115 @@
+package merkletree_proof
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/merkletree-proof/internal/testtree"
+	"github.com/stretchr/testify/require"
+)
+
+type logRecord struct {
+	level string
+	msg   string
+	args  []any
+}
+
+type recordingLogger struct {
+	records []logRecord
+}
+
+func (l *recordingLogger) log(level, msg string, args []any) {
+	l.records = append(l.records, logRecord{level: level, msg: msg, args: args})
+}
+
+func (l *recordingLogger) Debug(msg string, args ...any) { l.log("debug", msg, args) }
+func (l *recordingLogger) Info(msg string, args ...any)  { l.log("info", msg, args) }
+func (l *recordingLogger) Warn(msg string, args ...any)  { l.log("warn", msg, args) }
+func (l *recordingLogger) Error(msg string, args ...any) { l.log("error", msg, args) }
+
+func TestLogObserver(t *testing.T) {
+	ctx := context.Background()
+	hash := testtree.Hash(t, 10)
+	errFailed := errors.New("connection reset")
+
+	testCases := []struct {
+		title string
+		info  CallInfo
+		want  logRecord
+	}{
+		{
+			title: "get node",
+			info: CallInfo{Backend: "http", Op: OpGetNode, Hash: hash,
+				Duration: time.Second},
+			want: logRecord{level: "debug", msg: "GetNode succeeded",
+				args: []any{LogKeyBackend, "http", LogKeyHash, hash.Hex(),
+					LogKeyDuration, time.Second}},
+		},
+		{
+			title: "node not found",
+			info: CallInfo{Backend: "http", Op: OpGetNode, Hash: hash,
+				Duration: time.Second, Err: abicsr.ErrNodeNotFound},
+			want: logRecord{level: "debug", msg: "node not found",
+				args: []any{LogKeyBackend, "http", LogKeyHash, hash.Hex(),
+					LogKeyDuration, time.Second}},
+		},
+		{
+			title: "save nodes failed",
+			info: CallInfo{Backend: "eth", Op: OpSaveNodes, Nodes: 3,
+				Duration: time.Second, Err: errFailed},
+			want: logRecord{level: "warn", msg: "SaveNodes failed",
+				args: []any{LogKeyBackend, "eth", LogKeyNodes, 3,
+					LogKeyDuration, time.Second, LogKeyError, errFailed}},
+		},
+		{
+			title: "has nodes",
+			info: CallInfo{Backend: "eth", Op: OpHasNodes, Nodes: 5,
+				Duration: time.Second},
+			want: logRecord{level: "debug", msg: "HasNodes succeeded",
+				args: []any{LogKeyBackend, "eth", LogKeyNodes, 5,
+					LogKeyDuration, time.Second}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			logger := &recordingLogger{}
+			LogObserver{Logger: logger}.ObserveCall(ctx, tc.info)
+			require.Equal(t, []logRecord{tc.want}, logger.records)
+		})
+	}
+}
+
+func TestLogObserver_Proof(t *testing.T) {
+	ctx := context.Background()
+	root, key := testtree.Hash(t, 1), testtree.Hash(t, 2)
+	logger := &recordingLogger{}
+	LogObserver{Logger: logger}.ObserveProof(ctx, ProofInfo{TreeRoot: root,
+		Key: key, Depth: 3, Nodes: 4, Duration: time.Second})
+	require.Equal(t, []logRecord{{level: "debug", msg: "proof generated",
+		args: []any{LogKeyTreeRoot, root.Hex(), LogKeyKey, key.Hex(),
+			LogKeyDepth, 3, LogKeyNodes, 4, LogKeyDuration, time.Second}}},
+		logger.records)
+}
+
+func TestJoinObservers(t *testing.T) {
+	require.Nil(t, JoinObservers(nil, nil))
+
+	logger := &recordingLogger{}
+	single := LogObserver{Logger: logger}
+	require.Equal(t, single, JoinObservers(nil, single))
+
+	var calls int
+	joined := JoinObservers(single, nil, ObserverFuncs{
+		Call: func(_ context.Context, _ CallInfo) { calls++ },
+	})
+	joined.ObserveCall(context.Background(),
+		CallInfo{Op: OpSaveNodes, Nodes: 1})
+	joined.ObserveProof(context.Background(), ProofInfo{})
+	require.Equal(t, 1, calls)
+	require.Len(t, logger.records, 2)
+	require.Equal(t, "proof generated", logger.records[1].msg)
+}
@@ -0,0 +1,177 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+)
+
+var _ merkletree_proof.ReverseHashCli = (*ReverseHashCli)(nil)
+var _ merkletree_proof.PrunableStore = (*ReverseHashCli)(nil)
+var _ merkletree_proof.NodeChecker = (*ReverseHashCli)(nil)
+
+// ReverseHashCli is an in-memory implementation of the reverse hash service.
+// It is safe for concurrent use and is mostly useful in tests.
+type ReverseHashCli struct {
+	mu    sync.RWMutex
+	nodes map[merkletree.Hash][]merkletree.Hash
+}
+
+func NewReverseHashCli() *ReverseHashCli {
+	return &ReverseHashCli{nodes: make(map[merkletree.Hash][]merkletree.Hash)}
+}
+
+// NewReverseHashCliFromTree returns a ReverseHashCli seeded with all nodes
+// of the tree reachable from its current root.
+func NewReverseHashCliFromTree(ctx context.Context,
+	mt *merkletree.MerkleTree) (*ReverseHashCli, error) {
+
+	cli := NewReverseHashCli()
+	nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = cli.SaveNodes(ctx, nodes)
+	if err != nil {
+		return nil, err
+	}
+	return cli, nil
+}
+
+func (cli *ReverseHashCli) GenerateProof(ctx context.Context,
+	treeRoot *merkletree.Hash,
+	key *merkletree.Hash) (*merkletree.Proof, error) {
+
+	return merkletree_proof.GenerateProof(ctx, cli, treeRoot, key)
+}
+
+func (cli *ReverseHashCli) GetNode(_ context.Context,
+	hash *merkletree.Hash) (merkletree_proof.Node, error) {
+
+	if hash == nil {
+		return merkletree_proof.Node{}, errors.New("hash is nil")
+	}
+
+	cli.mu.RLock()
+	children, ok := cli.nodes[*hash]
+	cli.mu.RUnlock()
+	if !ok {
+		return merkletree_proof.Node{}, abicsr.ErrNodeNotFound
+	}
+
+	h := *hash
+	n := merkletree_proof.Node{
+		Hash:     &h,
+		Children: make([]*merkletree.Hash, len(children)),
+	}
+	for i := range children {
+		c := children[i]
+		n.Children[i] = &c
+	}
+	return n, nil
+}
+
+// HasNodes reports which of the nodes are stored.
+func (cli *ReverseHashCli) HasNodes(_ context.Context,
+	hashes []*merkletree.Hash) ([]bool, error) {
+
+	cli.mu.RLock()
+	defer cli.mu.RUnlock()
+	present := make([]bool, len(hashes))
+	for i, h := range hashes {
+		if h == nil {
+			return nil, errors.New("hash is nil")
+		}
+		_, present[i] = cli.nodes[*h]
+	}
+	return present, nil
+}
+
+// SaveNodes validates all nodes and saves them. If any node is invalid,
+// none of the nodes are saved.
+func (cli *ReverseHashCli) SaveNodes(_ context.Context,
+	nodes []merkletree_proof.Node) error {
+
+	for i, n := range nodes {
+		if err := n.Validate(); err != nil {
+			return fmt.Errorf("invalid node #%v: %w", i, err)
+		}
+	}
+
+	cli.mu.Lock()
+	defer cli.mu.Unlock()
+	if cli.nodes == nil {
+		cli.nodes = make(map[merkletree.Hash][]merkletree.Hash)
+	}
+	for _, n := range nodes {
+		children := make([]merkletree.Hash, len(n.Children))
+		for i, c := range n.Children {
+			children[i] = *c
+		}
+		cli.nodes[*n.Hash] = children
+	}
+	return nil
+}
+
+// DeleteNodes deletes nodes by their hashes. Missing nodes are ignored.
+func (cli *ReverseHashCli) DeleteNodes(_ context.Context,
+	hashes []*merkletree.Hash) error {
+
+	for _, h := range hashes {
+		if h == nil {
+			return errors.New("hash is nil")
+		}
+	}
+
+	cli.mu.Lock()
+	defer cli.mu.Unlock()
+	for _, h := range hashes {
+		delete(cli.nodes, *h)
+	}
+	return nil
+}
+
+// ForEachNode calls fn for hashes of all stored nodes. fn may modify the
+// store. Nodes deleted before fn is called for them are skipped, and nodes
+// saved during the call may be skipped too.
+func (cli *ReverseHashCli) ForEachNode(ctx context.Context,
+	fn func(hash *merkletree.Hash) error) error {
+
+	cli.mu.RLock()
+	defer cli.mu.RUnlock()
+
+	// the map is ranged over with the lock held and the lock is released
+	// while fn runs; a map may be modified while it is ranged over, so no
+	// copy of the hashes is needed
+	for h := range cli.nodes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		h := h
+		if err := cli.callUnlocked(fn, &h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// callUnlocked calls fn with the read lock released.
+func (cli *ReverseHashCli) callUnlocked(
+	fn func(hash *merkletree.Hash) error, hash *merkletree.Hash) error {
+
+	cli.mu.RUnlock()
+	defer cli.mu.RLock()
+	return fn(hash)
+}
+
+// Len returns the number of stored nodes.
+func (cli *ReverseHashCli) Len() int {
+	cli.mu.RLock()
+	defer cli.mu.RUnlock()
+	return len(cli.nodes)
+}
@@ -0,0 +1,190 @@
+package memory
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+	"github.com/iden3/merkletree-proof/internal/testtree"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReverseHashCli_GenerateProof(t *testing.T) {
+	ctx := context.Background()
+	mt := testtree.Build(t, 1, 5, 7, 100, 12345)
+
+	cli, err := NewReverseHashCliFromTree(ctx, mt)
+	require.NoError(t, err)
+
+	for _, k := range []int64{1, 5, 7, 100, 12345, 3, 99999} {
+		key, err := merkletree.NewHashFromBigInt(big.NewInt(k))
+		require.NoError(t, err)
+
+		wantProof, _, err := mt.GenerateProof(ctx, big.NewInt(k), nil)
+		require.NoError(t, err)
+
+		proof, err := cli.GenerateProof(ctx, mt.Root(), key)
+		require.NoError(t, err)
+		require.Equal(t, wantProof, proof)
+	}
+}
+
+func TestReverseHashCli_GetNode(t *testing.T) {
+	ctx := context.Background()
+	mt := testtree.Build(t, 1, 2)
+	cli, err := NewReverseHashCliFromTree(ctx, mt)
+	require.NoError(t, err)
+	// root middle node and two leaves
+	require.Equal(t, 3, cli.Len())
+
+	n, err := cli.GetNode(ctx, mt.Root())
+	require.NoError(t, err)
+	require.Equal(t, merkletree_proof.NodeTypeMiddle, n.Type())
+	require.Equal(t, mt.Root(), n.Hash)
+
+	// returned node must not alias stored data
+	n.Children[0][0] ^= 0xff
+	n2, err := cli.GetNode(ctx, mt.Root())
+	require.NoError(t, err)
+	require.NotEqual(t, n.Children[0], n2.Children[0])
+
+	_, err = cli.GetNode(ctx, &merkletree.HashZero)
+	require.ErrorIs(t, err, abicsr.ErrNodeNotFound)
+
+	present, err := cli.HasNodes(ctx,
+		[]*merkletree.Hash{&merkletree.HashZero, mt.Root()})
+	require.NoError(t, err)
+	require.Equal(t, []bool{false, true}, present)
+}
+
+func TestReverseHashCli_SaveNodes_Invalid(t *testing.T) {
+	ctx := context.Background()
+	cli := NewReverseHashCli()
+
+	one, err := merkletree.NewHashFromBigInt(big.NewInt(1))
+	require.NoError(t, err)
+	valid, err := merkletree_proof.NewNodeFromMerkleTreeNode(
+		merkletree.NewNodeMiddle(one, &merkletree.HashZero))
+	require.NoError(t, err)
+	invalid := merkletree_proof.Node{
+		Hash:     one,
+		Children: []*merkletree.Hash{one, &merkletree.HashZero},
+	}
+
+	err = cli.SaveNodes(ctx, []merkletree_proof.Node{valid, invalid})
+	require.ErrorContains(t, err, "invalid node #1: node hash mismatch")
+	require.Equal(t, 0, cli.Len())
+}
+
+func TestReverseHashCli_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	mt := testtree.Build(t, 1, 5, 7, 100)
+	nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+
+	var cli ReverseHashCli
+	var wg sync.WaitGroup
+	errs := make(chan error, len(nodes))
+	for _, n := range nodes {
+		wg.Add(2)
+		go func(n merkletree_proof.Node) {
+			defer wg.Done()
+			errs <- cli.SaveNodes(ctx, []merkletree_proof.Node{n})
+		}(n)
+		go func(n merkletree_proof.Node) {
+			defer wg.Done()
+			_, _ = cli.GetNode(ctx, n.Hash)
+		}(n)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.Equal(t, len(nodes), cli.Len())
+}
+
+func TestReverseHashCli_Prune(t *testing.T) {
+	ctx := context.Background()
+	live := testtree.Build(t, 1, 5, 7)
+	liveNodes, err := merkletree_proof.NodesFromTree(ctx, live, nil)
+	require.NoError(t, err)
+	dead := testtree.Build(t, 2, 3)
+	deadNodes, err := merkletree_proof.NodesFromTree(ctx, dead, nil)
+	require.NoError(t, err)
+
+	cli := NewReverseHashCli()
+	require.NoError(t, cli.SaveNodes(ctx, liveNodes))
+	require.NoError(t, cli.SaveNodes(ctx, deadNodes))
+
+	roots := []*merkletree.Hash{live.Root()}
+	result, err := merkletree_proof.Prune(ctx, cli, roots,
+		merkletree_proof.WithDryRun(true))
+	require.NoError(t, err)
+	require.Equal(t, len(deadNodes), result.Deleted)
+	require.Equal(t, len(liveNodes)+len(deadNodes), cli.Len())
+
+	result, err = merkletree_proof.Prune(ctx, cli, roots,
+		merkletree_proof.WithDeleteBatchSize(1))
+	require.NoError(t, err)
+	require.Equal(t, merkletree_proof.PruneResult{
+		Reachable: len(liveNodes),
+		Deleted:   len(deadNodes),
+	}, result)
+	require.Equal(t, len(liveNodes), cli.Len())
+
+	_, err = cli.GetNode(ctx, dead.Root())
+	require.ErrorIs(t, err, abicsr.ErrNodeNotFound)
+	for _, k := range []int64{1, 5, 7} {
+		wantProof, _, err := live.GenerateProof(ctx, big.NewInt(k), nil)
+		require.NoError(t, err)
+		key, err := merkletree.NewHashFromBigInt(big.NewInt(k))
+		require.NoError(t, err)
+		proof, err := cli.GenerateProof(ctx, live.Root(), key)
+		require.NoError(t, err)
+		require.Equal(t, wantProof, proof)
+	}
+
+	err = cli.DeleteNodes(ctx, []*merkletree.Hash{live.Root(), nil})
+	require.EqualError(t, err, "hash is nil")
+	require.Equal(t, len(liveNodes), cli.Len())
+}
+
+func TestReverseHashCli_ForEachNode(t *testing.T) {
+	ctx := context.Background()
+	nodes, err := merkletree_proof.NodesFromTree(ctx,
+		testtree.Build(t, 1, 5, 7, 100), nil)
+	require.NoError(t, err)
+	more, err := merkletree_proof.NodesFromTree(ctx,
+		testtree.Build(t, 2, 3, 4), nil)
+	require.NoError(t, err)
+
+	cli := NewReverseHashCli()
+	require.NoError(t, cli.SaveNodes(ctx, nodes))
+
+	// nodes are deleted while iterating and saved concurrently
+	saved := make(chan error, 1)
+	go func() {
+		var err error
+		for _, n := range more {
+			if err = cli.SaveNodes(ctx, []merkletree_proof.Node{n}); err != nil {
+				break
+			}
+		}
+		saved <- err
+	}()
+	err = cli.ForEachNode(ctx, func(hash *merkletree.Hash) error {
+		return cli.DeleteNodes(ctx, []*merkletree.Hash{hash})
+	})
+	require.NoError(t, err)
+	require.NoError(t, <-saved)
+
+	for _, n := range nodes {
+		_, err = cli.GetNode(ctx, n.Hash)
+		require.ErrorIs(t, err, abicsr.ErrNodeNotFound)
+	}
+}
@@ -0,0 +1,145 @@
+package merkletree_proof
+
+import (
+	"context"
+	"time"
+
+	"github.com/iden3/go-merkletree-sql/v2"
+)
+
+// Operation is a reverse hash service call reported to an Observer.
+type Operation byte
+
+const (
+	OpUnknown Operation = iota
+	OpGetNode
+	OpSaveNodes
+	OpHasNodes
+)
+
+func (op Operation) String() string {
+	switch op {
+	case OpGetNode:
+		return "GetNode"
+	case OpSaveNodes:
+		return "SaveNodes"
+	case OpHasNodes:
+		return "HasNodes"
+	default:
+		return "unknown"
+	}
+}
+
+// CallInfo describes a finished GetNode, SaveNodes or HasNodes call.
+type CallInfo struct {
+	// Backend is a name of the client, like "http" or "eth".
+	Backend string
+	Op      Operation
+	// Hash is the requested node hash for OpGetNode.
+	Hash *merkletree.Hash
+	// Nodes is the number of nodes saved for OpSaveNodes or checked for
+	// OpHasNodes.
+	Nodes    int
+	Duration time.Duration
+	// BytesSent and BytesReceived are sizes of request and response bodies
+	// if the backend knows them.
+	BytesSent     int64
+	BytesReceived int64
+	Err           error
+}
+
+// ProofInfo describes a finished GenerateProof call.
+type ProofInfo struct {
+	TreeRoot *merkletree.Hash
+	Key      *merkletree.Hash
+	// Depth is the number of middle nodes on the path to the key.
+	Depth int
+	// Nodes is the number of nodes fetched from the reader.
+	Nodes    int
+	Duration time.Duration
+	Err      error
+}
+
+// Observer receives information about calls to a reverse hash service, for
+// example to export metrics. Methods are called after the call is finished
+// and may be called concurrently. They should return quickly.
+type Observer interface {
+	ObserveCall(ctx context.Context, info CallInfo)
+	ObserveProof(ctx context.Context, info ProofInfo)
+}
+
+// ObserverFuncs is an Observer calling its non-nil fields.
+type ObserverFuncs struct {
+	Call  func(ctx context.Context, info CallInfo)
+	Proof func(ctx context.Context, info ProofInfo)
+}
+
+func (o ObserverFuncs) ObserveCall(ctx context.Context, info CallInfo) {
+	if o.Call != nil {
+		o.Call(ctx, info)
+	}
+}
+
+func (o ObserverFuncs) ObserveProof(ctx context.Context, info ProofInfo) {
+	if o.Proof != nil {
+		o.Proof(ctx, info)
+	}
+}
+
+type proofConfig struct {
+	observer Observer
+}
+
+type ProofOption func(cfg *proofConfig)
+
+// WithProofObserver reports the generated proof to the observer. A nil
+// observer is ignored.
+func WithProofObserver(observer Observer) ProofOption {
+	return func(cfg *proofConfig) {
+		cfg.observer = observer
+	}
+}
+
+var _ NodeReader = (*ObservedReader)(nil)
+
+// ObservedReader is a NodeReader reporting every GetNode call and every proof
+// generated with its GenerateProof method to an Observer.
+type ObservedReader struct {
+	reader   NodeReader
+	backend  string
+	observer Observer
+}
+
+// NewObservedReader wraps the reader. The backend name is passed to the
+// observer in CallInfo. With a nil observer the calls are not reported.
+func NewObservedReader(reader NodeReader, backend string,
+	observer Observer) *ObservedReader {
+
+	return &ObservedReader{reader: reader, backend: backend, observer: observer}
+}
+
+func (r *ObservedReader) GetNode(ctx context.Context,
+	hash *merkletree.Hash) (Node, error) {
+
+	if r.observer == nil {
+		return r.reader.GetNode(ctx, hash)
+	}
+
+	start := time.Now()
+	n, err := r.reader.GetNode(ctx, hash)
+	r.observer.ObserveCall(ctx, CallInfo{
+		Backend:  r.backend,
+		Op:       OpGetNode,
+		Hash:     hash,
+		Duration: time.Since(start),
+		Err:      err,
+	})
+	return n, err
+}
+
+func (r *ObservedReader) GenerateProof(ctx context.Context,
+	treeRoot *merkletree.Hash,
+	key *merkletree.Hash) (*merkletree.Proof, error) {
+
+	return GenerateProof(ctx, r, treeRoot, key, WithProofObserver(r.observer))
+}
@@ -0,0 +1,110 @@
+package merkletree_proof
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+	"github.com/iden3/merkletree-proof/internal/testtree"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingObserver struct {
+	mu     sync.Mutex
+	calls  []CallInfo
+	proofs []ProofInfo
+}
+
+func (o *recordingObserver) ObserveCall(_ context.Context, info CallInfo) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.calls = append(o.calls, info)
+}
+
+func (o *recordingObserver) ObserveProof(_ context.Context, info ProofInfo) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.proofs = append(o.proofs, info)
+}
+
+func TestObservedReader(t *testing.T) {
+	ctx := context.Background()
+	mt := testtree.Build(t, 1, 2, 5)
+	nodes, err := NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+	backend := &testBackend{}
+	require.NoError(t, backend.SaveNodes(ctx, nodes))
+
+	obs := &recordingObserver{}
+	r := NewObservedReader(backend, "test", obs)
+
+	key := testtree.Hash(t, 5)
+	wantProof, _, err := mt.GenerateProof(ctx, big.NewInt(5), nil)
+	require.NoError(t, err)
+	proof, err := r.GenerateProof(ctx, mt.Root(), key)
+	require.NoError(t, err)
+	require.Equal(t, wantProof, proof)
+
+	require.Len(t, obs.proofs, 1)
+	p := obs.proofs[0]
+	require.Equal(t, mt.Root(), p.TreeRoot)
+	require.Equal(t, key, p.Key)
+	require.Equal(t, len(proof.AllSiblings()), p.Depth)
+	require.Equal(t, len(obs.calls), p.Nodes)
+	require.NoError(t, p.Err)
+	for _, c := range obs.calls {
+		require.Equal(t, "test", c.Backend)
+		require.Equal(t, OpGetNode, c.Op)
+		require.NoError(t, c.Err)
+	}
+	require.Equal(t, mt.Root(), obs.calls[0].Hash)
+
+	// errors are reported too
+	_, err = r.GetNode(ctx, testtree.Hash(t, 100))
+	require.ErrorIs(t, err, abicsr.ErrNodeNotFound)
+	require.ErrorIs(t, obs.calls[len(obs.calls)-1].Err, abicsr.ErrNodeNotFound)
+
+	_, err = r.GenerateProof(ctx, testtree.Hash(t, 100), key)
+	require.ErrorIs(t, err, abicsr.ErrNodeNotFound)
+	require.Len(t, obs.proofs, 2)
+	require.ErrorIs(t, obs.proofs[1].Err, abicsr.ErrNodeNotFound)
+	require.Equal(t, 0, obs.proofs[1].Nodes)
+}
+
+func TestObservedReader_NilObserver(t *testing.T) {
+	ctx := context.Background()
+	mt := testtree.Build(t, 1, 2, 5)
+	nodes, err := NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+	backend := &testBackend{}
+	require.NoError(t, backend.SaveNodes(ctx, nodes))
+
+	r := NewObservedReader(backend, "test", nil)
+	n, err := r.GetNode(ctx, mt.Root())
+	require.NoError(t, err)
+	require.Equal(t, mt.Root(), n.Hash)
+
+	wantProof, _, err := mt.GenerateProof(ctx, big.NewInt(5), nil)
+	require.NoError(t, err)
+	proof, err := r.GenerateProof(ctx, mt.Root(), testtree.Hash(t, 5))
+	require.NoError(t, err)
+	require.Equal(t, wantProof, proof)
+}
+
+func TestGenerateProof_EmptyTreeObserved(t *testing.T) {
+	var got []ProofInfo
+	obs := ObserverFuncs{Proof: func(_ context.Context, info ProofInfo) {
+		got = append(got, info)
+	}}
+	proof, err := GenerateProof(context.Background(), &testBackend{},
+		&merkletree.HashZero, testtree.Hash(t, 1),
+		WithProofObserver(obs))
+	require.NoError(t, err)
+	require.False(t, proof.Existence)
+	require.Len(t, got, 1)
+	require.Equal(t, 0, got[0].Nodes)
+	require.Equal(t, 0, got[0].Depth)
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"time"
 
 	"github.com/iden3/go-merkletree-sql/v2"
 )
@@ -14,16 +15,30 @@ import (
 // Deprecated: use github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi ErrNodeNotFound instead.
 var ErrNodeNotFound = errors.New("node not found")
 
-type ReverseHashCli interface {
-	GenerateProof(ctx context.Context,
-		treeRoot *merkletree.Hash,
-		key *merkletree.Hash) (*merkletree.Proof, error)
+// NodeReader fetches nodes by their hashes. It returns
+// abicsr.ErrNodeNotFound if the node is not found.
+type NodeReader interface {
 	GetNode(ctx context.Context,
 		hash *merkletree.Hash) (Node, error)
+}
+
+// NodeWriter saves nodes.
+type NodeWriter interface {
 	SaveNodes(ctx context.Context,
 		nodes []Node) error
 }
 
+// ReverseHashCli is a client of a reverse hash service that can both read
+// and write nodes. Code that only reads or only writes nodes should accept
+// NodeReader or NodeWriter instead.
+type ReverseHashCli interface {
+	NodeReader
+	NodeWriter
+	GenerateProof(ctx context.Context,
+		treeRoot *merkletree.Hash,
+		key *merkletree.Hash) (*merkletree.Proof, error)
+}
+
 type NodeType byte
 
 const (
@@ -56,6 +71,34 @@ func (n Node) Type() NodeType {
 	return NodeTypeUnknown
 }
 
+// Validate checks that the node is of a known type and its hash is the
+// Poseidon hash of its children.
+func (n Node) Validate() error {
+	if n.Hash == nil {
+		return errors.New("node hash is nil")
+	}
+	children := make([]*big.Int, len(n.Children))
+	for i, c := range n.Children {
+		if c == nil {
+			return fmt.Errorf("node child #%v is nil: %v", i, n.Hash.Hex())
+		}
+		children[i] = c.BigInt()
+	}
+	if n.Type() == NodeTypeUnknown {
+		return fmt.Errorf("unknown node type: %v", n.Hash.Hex())
+	}
+
+	h, err := merkletree.HashElems(children...)
+	if err != nil {
+		return err
+	}
+	if !h.Equals(n.Hash) {
+		return fmt.Errorf("node hash mismatch: want %v, got %v",
+			h.Hex(), n.Hash.Hex())
+	}
+	return nil
+}
+
 type jsonNode struct {
 	Hash     string   `json:"hash"`
 	Children []string `json:"children"`
@@ -82,20 +125,51 @@ func (n Node) MarshalJSON() ([]byte, error) {
 	})
 }
 
-type NodeReader interface {
-	GetNode(context.Context, *merkletree.Hash) (Node, error)
+// GenerateProof generates proof of existence or in-existence of a key in
+// a tree identified by a treeRoot, fetching nodes from the reader.
+func GenerateProof(ctx context.Context, cli NodeReader,
+	treeRoot *merkletree.Hash,
+	key *merkletree.Hash, opts ...ProofOption) (*merkletree.Proof, error) {
+
+	var cfg proofConfig
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	if cfg.observer == nil {
+		proof, _, err := generateProof(ctx, cli, treeRoot, key)
+		return proof, err
+	}
+
+	start := time.Now()
+	proof, nodes, err := generateProof(ctx, cli, treeRoot, key)
+	info := ProofInfo{
+		TreeRoot: treeRoot,
+		Key:      key,
+		Nodes:    nodes,
+		Duration: time.Since(start),
+		Err:      err,
+	}
+	if proof != nil {
+		info.Depth = len(proof.AllSiblings())
+	}
+	cfg.observer.ObserveProof(ctx, info)
+	return proof, err
 }
 
-func GenerateProof(ctx context.Context, cli NodeReader,
+// generateProof returns the proof and the number of nodes fetched.
+func generateProof(ctx context.Context, cli NodeReader,
 	treeRoot *merkletree.Hash,
-	key *merkletree.Hash) (*merkletree.Proof, error) {
+	key *merkletree.Hash) (*merkletree.Proof, int, error) {
 
 	var exists bool
 	var siblings []*merkletree.Hash
 	var nodeAux *merkletree.NodeAux
+	var nodes int
 
-	mkProof := func() (*merkletree.Proof, error) {
-		return merkletree.NewProofFromData(exists, siblings, nodeAux)
+	mkProof := func() (*merkletree.Proof, int, error) {
+		proof, err := merkletree.NewProofFromData(exists, siblings, nodeAux)
+		return proof, nodes, err
 	}
 
 	nextKey := treeRoot
@@ -105,8 +179,9 @@ func GenerateProof(ctx context.Context, cli NodeReader,
 		}
 		n, err := cli.GetNode(ctx, nextKey)
 		if err != nil {
-			return nil, err
+			return nil, nodes, err
 		}
+		nodes++
 		switch nt := n.Type(); nt {
 		case NodeTypeLeaf:
 			if bytes.Equal(key[:], n.Children[0][:]) {
@@ -128,13 +203,13 @@ func GenerateProof(ctx context.Context, cli NodeReader,
 				siblings = append(siblings, n.Children[1])
 			}
 		default:
-			return nil, fmt.Errorf(
+			return nil, nodes, fmt.Errorf(
 				"found unexpected node type in tree (%v): %v",
 				nt, n.Hash.Hex())
 		}
 	}
 
-	return nil, errors.New("tree depth is too high")
+	return nil, nodes, errors.New("tree depth is too high")
 }
 
 func hashesToHexes(hashes []*merkletree.Hash) []string {
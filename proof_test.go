@@ -2,6 +2,7 @@ package merkletree_proof
 
 import (
 	"encoding/json"
+	"math/big"
 	"testing"
 
 	"github.com/iden3/go-merkletree-sql/v2"
@@ -132,3 +133,27 @@ func hashFromHex(in string) *merkletree.Hash {
 	}
 	return h
 }
+
+func TestNode_Validate(t *testing.T) {
+	k, err := merkletree.NewHashFromBigInt(big.NewInt(5))
+	require.NoError(t, err)
+	v, err := merkletree.NewHashFromBigInt(big.NewInt(50))
+	require.NoError(t, err)
+
+	leaf, err := NewNodeFromMerkleTreeNode(merkletree.NewNodeLeaf(k, v))
+	require.NoError(t, err)
+	require.Equal(t, NodeTypeLeaf, leaf.Type())
+	require.NoError(t, leaf.Validate())
+
+	middle, err := NewNodeFromMerkleTreeNode(
+		merkletree.NewNodeMiddle(leaf.Hash, &merkletree.HashZero))
+	require.NoError(t, err)
+	require.Equal(t, NodeTypeMiddle, middle.Type())
+	require.NoError(t, middle.Validate())
+
+	middle.Children[0], middle.Children[1] = middle.Children[1], middle.Children[0]
+	require.ErrorContains(t, middle.Validate(), "node hash mismatch")
+
+	unknown := Node{Hash: leaf.Hash, Children: []*merkletree.Hash{k}}
+	require.ErrorContains(t, unknown.Validate(), "unknown node type")
+}
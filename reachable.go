@@ -0,0 +1,286 @@
+package merkletree_proof
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+)
+
+// HashSet is a set of node hashes that WalkReachable uses to visit every
+// node once. The default implementation keeps hashes in memory; stores with
+// many nodes may provide one backed by disk.
+type HashSet interface {
+	// Add adds the hash to the set and reports whether it was not in the
+	// set before.
+	Add(hash merkletree.Hash) (bool, error)
+	Has(hash merkletree.Hash) (bool, error)
+}
+
+// MemoryHashSet is a HashSet kept in memory. It is safe for concurrent use.
+type MemoryHashSet struct {
+	mu sync.RWMutex
+	m  map[merkletree.Hash]struct{}
+}
+
+func NewMemoryHashSet() *MemoryHashSet {
+	return &MemoryHashSet{m: make(map[merkletree.Hash]struct{})}
+}
+
+func (s *MemoryHashSet) Add(hash merkletree.Hash) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.m[hash]; ok {
+		return false, nil
+	}
+	s.m[hash] = struct{}{}
+	return true, nil
+}
+
+func (s *MemoryHashSet) Has(hash merkletree.Hash) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.m[hash]
+	return ok, nil
+}
+
+// remove removes the hash from the set.
+func (s *MemoryHashSet) remove(hash merkletree.Hash) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.m, hash)
+}
+
+func (s *MemoryHashSet) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.m)
+}
+
+// WalkReachable calls fn once for every stored node reachable from the
+// roots. A root may be a state node, in which case the claims, revocation
+// and roots trees it references are walked, or a root of a tree. Nodes
+// missing from the reader are skipped.
+//
+// Nodes are streamed to fn as they are found. Apart from the visited set,
+// memory used by the walk is bounded by the depth of the trees. Subtrees
+// already in visited are not walked again, so the same set may be reused
+// across calls to walk more roots.
+func WalkReachable(ctx context.Context, cli NodeReader,
+	roots []*merkletree.Hash, visited HashSet,
+	fn func(hash *merkletree.Hash) error) error {
+
+	return WalkReachableNodes(ctx, cli, roots, visited,
+		func(n Node) error { return fn(n.Hash) })
+}
+
+// WalkReachableNodes is like WalkReachable but passes the nodes to fn. The
+// walk is depth-first with children visited in order, so the order of nodes
+// is the same for the same roots and stored nodes.
+func WalkReachableNodes(ctx context.Context, cli NodeReader,
+	roots []*merkletree.Hash, visited HashSet, fn func(n Node) error) error {
+
+	return walkReachable(ctx, cli, roots, visited, fn, nil)
+}
+
+// walkReachable implements WalkReachableNodes. If missing is not nil, it is
+// called for nodes that are referenced but not stored.
+func walkReachable(ctx context.Context, cli NodeReader,
+	roots []*merkletree.Hash, visited HashSet, fn func(n Node) error,
+	missing func(hash merkletree.Hash)) error {
+
+	stack := make([]*merkletree.Hash, 0, len(roots))
+	for i := len(roots) - 1; i >= 0; i-- {
+		stack = append(stack, roots[i])
+	}
+
+	for len(stack) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		hash := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if hash == nil || *hash == merkletree.HashZero {
+			continue
+		}
+
+		added, err := visited.Add(*hash)
+		if err != nil {
+			return err
+		}
+		if !added {
+			continue
+		}
+
+		n, err := cli.GetNode(ctx, hash)
+		if errors.Is(err, abicsr.ErrNodeNotFound) {
+			if missing != nil {
+				missing(*hash)
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		err = fn(n)
+		if err != nil {
+			return err
+		}
+
+		switch n.Type() {
+		case NodeTypeMiddle, NodeTypeState:
+			for i := len(n.Children) - 1; i >= 0; i-- {
+				stack = append(stack, n.Children[i])
+			}
+		}
+	}
+	return nil
+}
+
+// ReachableNodes returns the set of hashes of all stored nodes reachable
+// from the roots. See WalkReachable.
+func ReachableNodes(ctx context.Context, cli NodeReader,
+	roots []*merkletree.Hash) (*MemoryHashSet, error) {
+
+	// the visited set is the result once missing nodes are taken out of it
+	visited := NewMemoryHashSet()
+	err := walkReachable(ctx, cli, roots, visited,
+		func(_ Node) error { return nil }, visited.remove)
+	if err != nil {
+		return nil, err
+	}
+	return visited, nil
+}
+
+// NodeIterator is implemented by stores that can enumerate their nodes. The
+// store must allow fn to modify it, for example to delete nodes.
+type NodeIterator interface {
+	ForEachNode(ctx context.Context,
+		fn func(hash *merkletree.Hash) error) error
+}
+
+// NodeDeleter is implemented by stores that support deletion of nodes.
+type NodeDeleter interface {
+	DeleteNodes(ctx context.Context, hashes []*merkletree.Hash) error
+}
+
+// PrunableStore is a store that can be garbage collected with Prune.
+type PrunableStore interface {
+	NodeReader
+	NodeIterator
+	NodeDeleter
+}
+
+// PruneResult is the result of Prune.
+type PruneResult struct {
+	Reachable int
+	Deleted   int
+}
+
+type pruneConfig struct {
+	visited   HashSet
+	batchSize int
+	dryRun    bool
+}
+
+type PruneOption func(cfg *pruneConfig) error
+
+// WithVisitedSet sets the set used to track reachable nodes. By default it
+// is kept in memory.
+func WithVisitedSet(visited HashSet) PruneOption {
+	return func(cfg *pruneConfig) error {
+		if visited == nil {
+			return errors.New("visited set is nil")
+		}
+		cfg.visited = visited
+		return nil
+	}
+}
+
+// WithDeleteBatchSize sets the number of nodes deleted with one
+// DeleteNodes call. The default is 1000.
+func WithDeleteBatchSize(size int) PruneOption {
+	return func(cfg *pruneConfig) error {
+		if size <= 0 {
+			return errors.New("delete batch size must be positive")
+		}
+		cfg.batchSize = size
+		return nil
+	}
+}
+
+// WithDryRun makes Prune only count unreachable nodes without deleting
+// them.
+func WithDryRun(dryRun bool) PruneOption {
+	return func(cfg *pruneConfig) error {
+		cfg.dryRun = dryRun
+		return nil
+	}
+}
+
+// Prune deletes all nodes of the store that are not reachable from the live
+// roots. See WalkReachable for what roots may be.
+//
+// Nodes saved while Prune is running may be deleted if they are not
+// reachable from the roots, including nodes that a new tree shares with
+// pruned ones. Writes to the store should be paused, or roots of the trees
+// being written included in roots.
+func Prune(ctx context.Context, store PrunableStore, roots []*merkletree.Hash,
+	opts ...PruneOption) (PruneResult, error) {
+
+	cfg := pruneConfig{batchSize: 1000}
+	for _, o := range opts {
+		err := o(&cfg)
+		if err != nil {
+			return PruneResult{}, err
+		}
+	}
+	if cfg.visited == nil {
+		cfg.visited = NewMemoryHashSet()
+	}
+
+	var result PruneResult
+	err := WalkReachable(ctx, store, roots, cfg.visited,
+		func(_ *merkletree.Hash) error {
+			result.Reachable++
+			return nil
+		})
+	if err != nil {
+		return result, err
+	}
+
+	var batch []*merkletree.Hash
+	deleteBatch := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if !cfg.dryRun {
+			if err := store.DeleteNodes(ctx, batch); err != nil {
+				return err
+			}
+		}
+		result.Deleted += len(batch)
+		batch = nil
+		return nil
+	}
+
+	err = store.ForEachNode(ctx, func(hash *merkletree.Hash) error {
+		reachable, err := cfg.visited.Has(*hash)
+		if err != nil || reachable {
+			return err
+		}
+		h := *hash
+		batch = append(batch, &h)
+		if len(batch) >= cfg.batchSize {
+			return deleteBatch()
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	return result, deleteBatch()
+}
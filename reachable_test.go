@@ -0,0 +1,72 @@
+package merkletree_proof
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/iden3/go-merkletree-sql/v2"
+	"github.com/iden3/merkletree-proof/internal/testtree"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReachableNodes(t *testing.T) {
+	ctx := context.Background()
+	claims := testtree.Build(t, 1, 2, 3)
+	revocations := testtree.Build(t, 5, 6)
+	claimsNodes, err := NodesFromTree(ctx, claims, nil)
+	require.NoError(t, err)
+	revNodes, err := NodesFromTree(ctx, revocations, nil)
+	require.NoError(t, err)
+
+	stateHash, err := merkletree.HashElems(claims.Root().BigInt(),
+		revocations.Root().BigInt(), big.NewInt(0))
+	require.NoError(t, err)
+	state := Node{
+		Hash: stateHash,
+		Children: []*merkletree.Hash{claims.Root(), revocations.Root(),
+			&merkletree.HashZero},
+	}
+
+	// a tree of an abandoned state
+	abandoned := testtree.Build(t, 7, 8)
+	abandonedNodes, err := NodesFromTree(ctx, abandoned, nil)
+	require.NoError(t, err)
+
+	cli := &testBackend{}
+	require.NoError(t, cli.SaveNodes(ctx, claimsNodes))
+	require.NoError(t, cli.SaveNodes(ctx, revNodes))
+	require.NoError(t, cli.SaveNodes(ctx, abandonedNodes))
+	require.NoError(t, cli.SaveNodes(ctx, []Node{state}))
+
+	missing := testtree.Hash(t, 42)
+	reachable, err := ReachableNodes(ctx, cli,
+		[]*merkletree.Hash{stateHash, missing})
+	require.NoError(t, err)
+	require.Equal(t, 1+len(claimsNodes)+len(revNodes), reachable.Len())
+	ok, err := reachable.Has(*missing)
+	require.NoError(t, err)
+	require.False(t, ok)
+	for _, n := range append(claimsNodes, revNodes...) {
+		ok, err := reachable.Has(*n.Hash)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+	for _, n := range abandonedNodes {
+		ok, err := reachable.Has(*n.Hash)
+		require.NoError(t, err)
+		require.False(t, ok)
+	}
+
+	// every node is streamed once even if roots overlap
+	var walked []*merkletree.Hash
+	err = WalkReachable(ctx, cli,
+		[]*merkletree.Hash{claims.Root(), stateHash, claims.Root()},
+		NewMemoryHashSet(), func(hash *merkletree.Hash) error {
+			walked = append(walked, hash)
+			return nil
+		})
+	require.NoError(t, err)
+	require.Len(t, walked, reachable.Len())
+	require.Equal(t, claims.Root(), walked[0])
+}
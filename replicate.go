@@ -0,0 +1,237 @@
+package merkletree_proof
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Quorum is the number of backends that must accept a SaveNodes call for
+// the ReplicatedWriter to consider it successful. The zero value is
+// QuorumAll.
+type Quorum int
+
+const (
+	// QuorumAll requires every backend to accept the nodes.
+	QuorumAll Quorum = 0
+	// QuorumAny requires at least one backend to accept the nodes.
+	QuorumAny Quorum = 1
+)
+
+// ReplicatedWriter saves nodes to several backends in parallel, for example
+// to publish a tree both to an HTTP RHS and to on-chain storage.
+type ReplicatedWriter struct {
+	backends []NodeWriter
+	quorum   Quorum
+}
+
+var _ NodeWriter = (*ReplicatedWriter)(nil)
+
+type ReplicatedWriterOption func(w *ReplicatedWriter) error
+
+// WithQuorum sets the number of backends that must accept the nodes. Use
+// QuorumAll, QuorumAny or any number between 1 and the number of backends.
+func WithQuorum(quorum Quorum) ReplicatedWriterOption {
+	return func(w *ReplicatedWriter) error {
+		w.quorum = quorum
+		return nil
+	}
+}
+
+func NewReplicatedWriter(backends []NodeWriter,
+	opts ...ReplicatedWriterOption) (*ReplicatedWriter, error) {
+
+	if len(backends) == 0 {
+		return nil, errors.New("no backends to replicate to")
+	}
+	for i, b := range backends {
+		if b == nil {
+			return nil, fmt.Errorf("backend #%v is nil", i)
+		}
+	}
+
+	w := &ReplicatedWriter{
+		// the caller must not change the backends of the writer
+		backends: append([]NodeWriter(nil), backends...),
+		quorum:   QuorumAll,
+	}
+	for _, o := range opts {
+		err := o(w)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if w.quorum < 0 || int(w.quorum) > len(backends) {
+		return nil, fmt.Errorf("invalid quorum %v for %v backends",
+			w.quorum, len(backends))
+	}
+
+	return w, nil
+}
+
+// Backends returns a copy of the backends in the order they were passed to
+// NewReplicatedWriter. BackendResult.Backend is an index into this slice.
+func (w *ReplicatedWriter) Backends() []NodeWriter {
+	return append([]NodeWriter(nil), w.backends...)
+}
+
+// SaveNodes saves nodes to all backends and returns a *ReplicationError if
+// the quorum is not reached. Use Replicate to get the result of each
+// backend when the quorum is reached but some backends failed.
+func (w *ReplicatedWriter) SaveNodes(ctx context.Context,
+	nodes []Node) error {
+
+	_, err := w.Replicate(ctx, nodes)
+	return err
+}
+
+// Replicate saves nodes to all backends in parallel and waits for all of
+// them to finish. The report is returned even when the quorum is not reached,
+// in which case the error is a *ReplicationError.
+func (w *ReplicatedWriter) Replicate(ctx context.Context,
+	nodes []Node) (ReplicationReport, error) {
+
+	idxs := make([]int, len(w.backends))
+	for i := range idxs {
+		idxs[i] = i
+	}
+	report := ReplicationReport{Results: w.save(ctx, nodes, idxs), writer: w}
+	return report, w.checkQuorum(report)
+}
+
+// Retry saves nodes once more to the backends that failed in the report and
+// returns the updated report. Results of backends that have already
+// succeeded are kept as is. The report must be returned by Replicate or Retry
+// of the same writer.
+func (w *ReplicatedWriter) Retry(ctx context.Context, nodes []Node,
+	report ReplicationReport) (ReplicationReport, error) {
+
+	if !w.owns(report) {
+		return report, errors.New(
+			"report does not belong to this replicated writer")
+	}
+
+	failed := report.Failed()
+	retried := w.save(ctx, nodes, failed)
+
+	newReport := ReplicationReport{
+		Results: make([]BackendResult, len(report.Results)),
+		writer:  w,
+	}
+	copy(newReport.Results, report.Results)
+	for _, r := range retried {
+		newReport.Results[r.Backend] = r
+	}
+	return newReport, w.checkQuorum(newReport)
+}
+
+// owns reports whether the report was made by the writer and has a result
+// for each of its backends in order.
+func (w *ReplicatedWriter) owns(report ReplicationReport) bool {
+	if report.writer != w || len(report.Results) != len(w.backends) {
+		return false
+	}
+	for i, r := range report.Results {
+		if r.Backend != i {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *ReplicatedWriter) save(ctx context.Context, nodes []Node,
+	idxs []int) []BackendResult {
+
+	results := make([]BackendResult, len(idxs))
+	var wg sync.WaitGroup
+	wg.Add(len(idxs))
+	for i, idx := range idxs {
+		go func(i, idx int) {
+			defer wg.Done()
+			start := time.Now()
+			err := w.backends[idx].SaveNodes(ctx, nodes)
+			results[i] = BackendResult{
+				Backend:  idx,
+				Err:      err,
+				Duration: time.Since(start),
+			}
+		}(i, idx)
+	}
+	wg.Wait()
+	return results
+}
+
+func (w *ReplicatedWriter) required() int {
+	if w.quorum == QuorumAll {
+		return len(w.backends)
+	}
+	return int(w.quorum)
+}
+
+func (w *ReplicatedWriter) checkQuorum(report ReplicationReport) error {
+	if report.Succeeded() >= w.required() {
+		return nil
+	}
+	return &ReplicationError{Report: report, Required: w.required()}
+}
+
+// BackendResult is the outcome of a SaveNodes call on one backend.
+type BackendResult struct {
+	// Backend is an index of the backend in ReplicatedWriter.Backends.
+	Backend  int
+	Err      error
+	Duration time.Duration
+}
+
+// ReplicationReport holds the result of every backend, ordered by backend
+// index.
+type ReplicationReport struct {
+	Results []BackendResult
+	// writer is the ReplicatedWriter that made the report
+	writer *ReplicatedWriter
+}
+
+// Succeeded returns the number of backends that accepted the nodes.
+func (r ReplicationReport) Succeeded() int {
+	n := 0
+	for _, res := range r.Results {
+		if res.Err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Failed returns indexes of backends that failed to save the nodes.
+func (r ReplicationReport) Failed() []int {
+	var idxs []int
+	for _, res := range r.Results {
+		if res.Err != nil {
+			idxs = append(idxs, res.Backend)
+		}
+	}
+	return idxs
+}
+
+// ReplicationError is returned when fewer backends than required by the
+// quorum accepted the nodes.
+type ReplicationError struct {
+	Report   ReplicationReport
+	Required int
+}
+
+func (e *ReplicationError) Error() string {
+	var errs []string
+	for _, res := range e.Report.Results {
+		if res.Err != nil {
+			errs = append(errs,
+				fmt.Sprintf("backend #%v: %v", res.Backend, res.Err))
+		}
+	}
+	return fmt.Sprintf("nodes saved to %v of %v required backends: %v",
+		e.Report.Succeeded(), e.Required, strings.Join(errs, "; "))
+}
@@ -0,0 +1,178 @@
+package merkletree_proof
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+	"github.com/stretchr/testify/require"
+)
+
+type testBackend struct {
+	sync.Mutex
+	err   error
+	saved []Node
+}
+
+func (b *testBackend) GetNode(_ context.Context,
+	hash *merkletree.Hash) (Node, error) {
+
+	b.Lock()
+	defer b.Unlock()
+	for _, n := range b.saved {
+		if n.Hash.Equals(hash) {
+			return n, nil
+		}
+	}
+	return Node{}, abicsr.ErrNodeNotFound
+}
+
+func (b *testBackend) SaveNodes(_ context.Context, nodes []Node) error {
+	b.Lock()
+	defer b.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	b.saved = append(b.saved, nodes...)
+	return nil
+}
+
+func TestReplicatedWriter(t *testing.T) {
+	nodes := []Node{{
+		Hash: hashFromHex("20a8bc6b66482191ad30d7c0a95e7a512297f0a2da9fccc0803b0b03aa3f5222"),
+		Children: []*merkletree.Hash{
+			hashFromHex("79f66791900bc0c9260f708e317437415b9f45673384f5b0752f5a649f661207"),
+			hashFromHex("f9b198c1da06c8cc8aedf408f2be2fd9def1818496924542c3194ceb7c70bb01"),
+		},
+	}}
+	errBackend := errors.New("backend is down")
+
+	testCases := []struct {
+		title     string
+		quorum    Quorum
+		errs      []error
+		wantErr   string
+		wantSaved []bool
+	}{
+		{
+			title:     "all backends succeeded",
+			quorum:    QuorumAll,
+			errs:      []error{nil, nil, nil},
+			wantSaved: []bool{true, true, true},
+		},
+		{
+			title:     "quorum all with one failure",
+			quorum:    QuorumAll,
+			errs:      []error{nil, errBackend, nil},
+			wantErr:   "nodes saved to 2 of 3 required backends: backend #1: backend is down",
+			wantSaved: []bool{true, false, true},
+		},
+		{
+			title:     "quorum any with one success",
+			quorum:    QuorumAny,
+			errs:      []error{errBackend, errBackend, nil},
+			wantSaved: []bool{false, false, true},
+		},
+		{
+			title:     "quorum two with one success",
+			quorum:    Quorum(2),
+			errs:      []error{errBackend, errBackend, nil},
+			wantErr:   "nodes saved to 1 of 2 required backends: backend #0: backend is down; backend #1: backend is down",
+			wantSaved: []bool{false, false, true},
+		},
+	}
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.title, func(t *testing.T) {
+			backends := make([]NodeWriter, len(tc.errs))
+			for j, err := range tc.errs {
+				backends[j] = &testBackend{err: err}
+			}
+			w, err := NewReplicatedWriter(backends, WithQuorum(tc.quorum))
+			require.NoError(t, err)
+
+			report, err := w.Replicate(context.Background(), nodes)
+			if tc.wantErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.wantErr)
+				var replErr *ReplicationError
+				require.True(t, errors.As(err, &replErr))
+			}
+			require.Len(t, report.Results, len(tc.errs))
+			for j, wantSaved := range tc.wantSaved {
+				require.Equal(t, j, report.Results[j].Backend)
+				require.Equal(t, wantSaved, report.Results[j].Err == nil)
+				b := backends[j].(*testBackend)
+				if wantSaved {
+					require.Equal(t, nodes, b.saved)
+				} else {
+					require.Empty(t, b.saved)
+				}
+			}
+		})
+	}
+}
+
+func TestReplicatedWriter_Retry(t *testing.T) {
+	nodes := []Node{{
+		Hash: hashFromHex("20a8bc6b66482191ad30d7c0a95e7a512297f0a2da9fccc0803b0b03aa3f5222"),
+		Children: []*merkletree.Hash{
+			hashFromHex("79f66791900bc0c9260f708e317437415b9f45673384f5b0752f5a649f661207"),
+			hashFromHex("f9b198c1da06c8cc8aedf408f2be2fd9def1818496924542c3194ceb7c70bb01"),
+		},
+	}}
+	b1 := &testBackend{}
+	b2 := &testBackend{err: errors.New("backend is down")}
+	w, err := NewReplicatedWriter([]NodeWriter{b1, b2})
+	require.NoError(t, err)
+
+	report, err := w.Replicate(context.Background(), nodes)
+	require.Error(t, err)
+	require.Equal(t, []int{1}, report.Failed())
+
+	b2.err = nil
+	report, err = w.Retry(context.Background(), nodes, report)
+	require.NoError(t, err)
+	require.Empty(t, report.Failed())
+	// the backend that has already succeeded must not be called again
+	require.Equal(t, nodes, b1.saved)
+	require.Equal(t, nodes, b2.saved)
+
+	// a report of another writer with as many backends is rejected
+	other, err := NewReplicatedWriter([]NodeWriter{b2, b1})
+	require.NoError(t, err)
+	_, err = other.Retry(context.Background(), nodes, report)
+	require.EqualError(t, err,
+		"report does not belong to this replicated writer")
+	_, err = w.Retry(context.Background(), nodes, ReplicationReport{
+		Results: make([]BackendResult, 2)})
+	require.EqualError(t, err,
+		"report does not belong to this replicated writer")
+}
+
+func TestReplicatedWriter_Backends(t *testing.T) {
+	b1, b2 := &testBackend{}, &testBackend{}
+	backends := []NodeWriter{b1, b2}
+	w, err := NewReplicatedWriter(backends)
+	require.NoError(t, err)
+
+	// changes of the slices don't change the backends of the writer
+	backends[0] = b2
+	got := w.Backends()
+	require.Same(t, b1, got[0])
+	got[1] = b1
+	require.Same(t, b2, w.Backends()[1])
+}
+
+func TestNewReplicatedWriter_InvalidQuorum(t *testing.T) {
+	_, err := NewReplicatedWriter(
+		[]NodeWriter{&testBackend{}}, WithQuorum(Quorum(2)))
+	require.EqualError(t, err, "invalid quorum 2 for 1 backends")
+
+	_, err = NewReplicatedWriter(nil)
+	require.EqualError(t, err, "no backends to replicate to")
+}
@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
@@ -20,6 +21,7 @@ import (
 	"github.com/iden3/go-merkletree-sql/v2"
 	"github.com/iden3/go-schema-processor/v2/utils"
 	"github.com/iden3/go-schema-processor/v2/verifiable"
+	merkletree_proof "github.com/iden3/merkletree-proof"
 	"github.com/pkg/errors"
 )
 
@@ -27,13 +29,42 @@ import (
 type OnChainResolver struct {
 	ethClients             map[core.ChainID]*ethclient.Client
 	stateContractAddresses map[core.ChainID]common.Address
+	logger                 merkletree_proof.Logger
+}
+
+type resolverConfig struct {
+	logger merkletree_proof.Logger
+}
+
+// ResolverOption configures OnChainResolver and RHSResolver.
+type ResolverOption func(cfg *resolverConfig)
+
+// WithLogger sets a logger receiving every resolved status at the debug
+// level and failures at the warn level. RHSResolver also passes it to the
+// RHS client. By default or if the logger is nil, nothing is logged.
+func WithLogger(logger merkletree_proof.Logger) ResolverOption {
+	return func(cfg *resolverConfig) {
+		cfg.logger = logger
+	}
+}
+
+func newResolverConfig(opts []ResolverOption) resolverConfig {
+	var cfg resolverConfig
+	for _, o := range opts {
+		o(&cfg)
+	}
+	return cfg
 }
 
 // NewOnChainResolver returns new onChain resolver
-func NewOnChainResolver(ethClients map[core.ChainID]*ethclient.Client, stateContractAddresses map[core.ChainID]common.Address) *OnChainResolver {
+func NewOnChainResolver(ethClients map[core.ChainID]*ethclient.Client, stateContractAddresses map[core.ChainID]common.Address,
+	opts ...ResolverOption) *OnChainResolver {
+
+	cfg := newResolverConfig(opts)
 	return &OnChainResolver{
 		ethClients:             ethClients,
 		stateContractAddresses: stateContractAddresses,
+		logger:                 cfg.logger,
 	}
 }
 
@@ -41,6 +72,9 @@ func NewOnChainResolver(ethClients map[core.ChainID]*ethclient.Client, stateCont
 func (r OnChainResolver) Resolve(ctx context.Context,
 	status verifiable.CredentialStatus) (out verifiable.RevocationStatus, err error) {
 
+	start := time.Now()
+	defer func() { logResolved(r.logger, "onchain", status, start, err) }()
+
 	if status.Type != verifiable.Iden3OnchainSparseMerkleTreeProof2023 {
 		return out, errors.New("invalid status type")
 	}
@@ -121,6 +155,24 @@ func (r OnChainResolver) Resolve(ctx context.Context,
 	return toRevocationStatus(resp)
 }
 
+func logResolved(logger merkletree_proof.Logger, backend string,
+	status verifiable.CredentialStatus, start time.Time, err error) {
+
+	if logger == nil {
+		return
+	}
+	args := []any{merkletree_proof.LogKeyBackend, backend,
+		merkletree_proof.LogKeyStatusID, status.ID,
+		merkletree_proof.LogKeyRevocationNonce, status.RevocationNonce,
+		merkletree_proof.LogKeyDuration, time.Since(start)}
+	if err != nil {
+		logger.Warn("credential status resolution failed",
+			append(args, merkletree_proof.LogKeyError, err)...)
+		return
+	}
+	logger.Debug("credential status resolved", args...)
+}
+
 func newOnchainRevStatusFromURI(statusID string, statusRevNonce uint64) (onChainRevStatus, error) {
 	var s onChainRevStatus
 
@@ -15,6 +15,7 @@ import (
 	core "github.com/iden3/go-iden3-core/v2"
 	"github.com/iden3/go-merkletree-sql/v2"
 	"github.com/iden3/go-schema-processor/v2/verifiable"
+	merkletree_proof "github.com/iden3/merkletree-proof"
 	mp "github.com/iden3/merkletree-proof/http"
 	"github.com/pkg/errors"
 )
@@ -23,13 +24,18 @@ import (
 type RHSResolver struct {
 	ethClients             map[core.ChainID]*ethclient.Client
 	stateContractAddresses map[core.ChainID]common.Address
+	logger                 merkletree_proof.Logger
 }
 
 // NewRHSResolver returns new RHS resolver
-func NewRHSResolver(ethClients map[core.ChainID]*ethclient.Client, stateContractAddresses map[core.ChainID]common.Address) *RHSResolver {
+func NewRHSResolver(ethClients map[core.ChainID]*ethclient.Client, stateContractAddresses map[core.ChainID]common.Address,
+	opts ...ResolverOption) *RHSResolver {
+
+	cfg := newResolverConfig(opts)
 	return &RHSResolver{
 		ethClients:             ethClients,
 		stateContractAddresses: stateContractAddresses,
+		logger:                 cfg.logger,
 	}
 }
 
@@ -37,6 +43,9 @@ func NewRHSResolver(ethClients map[core.ChainID]*ethclient.Client, stateContract
 func (r RHSResolver) Resolve(ctx context.Context,
 	status verifiable.CredentialStatus) (out verifiable.RevocationStatus, err error) {
 
+	start := time.Now()
+	defer func() { logResolved(r.logger, "rhs", status, start, err) }()
+
 	if status.Type != verifiable.Iden3ReverseSparseMerkleTreeProof {
 		return out, errors.New("invalid status type")
 	}
@@ -72,12 +81,12 @@ func (r RHSResolver) Resolve(ctx context.Context,
 		return out, err
 	}
 
-	rhsCli, err := newRhsCli(baseRHSURL)
+	rhsCli, err := newRhsCli(baseRHSURL, r.logger)
 	if err != nil {
 		return out, err
 	}
 
-	out.Issuer, err = issuerFromRHS(ctx, *rhsCli, state)
+	out.Issuer, err = issuerFromRHS(ctx, rhsCli, state)
 	if errors.Is(err, abicsr.ErrNodeNotFound) {
 		if genesisState != nil && state.Equals(genesisState) {
 			return out, errors.New("genesis state is not found in RHS")
@@ -143,7 +152,7 @@ func genesisStateMatch(state *merkletree.Hash, id core.ID) (bool, error) {
 	return bytes.Equal(otherID[:], id[:]), nil
 }
 
-func issuerFromRHS(ctx context.Context, rhsCli mp.ReverseHashCli,
+func issuerFromRHS(ctx context.Context, rhsCli merkletree_proof.NodeReader,
 	state *merkletree.Hash) (verifiable.TreeState, error) {
 
 	var issuer verifiable.TreeState
@@ -170,7 +179,9 @@ func issuerFromRHS(ctx context.Context, rhsCli mp.ReverseHashCli,
 	return issuer, err
 }
 
-func newRhsCli(rhsURL string) (*mp.ReverseHashCli, error) {
+func newRhsCli(rhsURL string,
+	logger merkletree_proof.Logger) (*mp.ReverseHashCli, error) {
+
 	if rhsURL == "" {
 		return nil, errors.New("reverse hash service url is empty")
 	}
@@ -178,6 +189,7 @@ func newRhsCli(rhsURL string) (*mp.ReverseHashCli, error) {
 	return &mp.ReverseHashCli{
 		URL:         rhsURL,
 		HTTPTimeout: 10 * time.Second,
+		Logger:      logger,
 	}, nil
 }
 
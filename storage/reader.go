@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+)
+
+var _ merkletree_proof.NodeReader = (*NodeReader)(nil)
+
+// NodeReader reads nodes directly from a go-merkletree-sql storage, so an
+// issuer can generate proofs with the same code as verifiers without
+// uploading the tree to the RHS first.
+//
+// Merkle tree storage does not know about identity states, so state nodes
+// must be registered with AddState.
+type NodeReader struct {
+	storage merkletree.Storage
+
+	mu     sync.RWMutex
+	states map[merkletree.Hash][3]merkletree.Hash
+}
+
+func NewNodeReader(storage merkletree.Storage) *NodeReader {
+	return &NodeReader{
+		storage: storage,
+		states:  make(map[merkletree.Hash][3]merkletree.Hash),
+	}
+}
+
+// AddState registers a state node built from the roots of the claims,
+// revocation and roots trees and returns the state hash.
+func (r *NodeReader) AddState(claimsTreeRoot, revocationTreeRoot,
+	rootOfRoots *merkletree.Hash) (*merkletree.Hash, error) {
+
+	if claimsTreeRoot == nil || revocationTreeRoot == nil ||
+		rootOfRoots == nil {
+		return nil, errors.New("tree root is nil")
+	}
+
+	state, err := merkletree.HashElems(claimsTreeRoot.BigInt(),
+		revocationTreeRoot.BigInt(), rootOfRoots.BigInt())
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.states[*state] = [3]merkletree.Hash{
+		*claimsTreeRoot, *revocationTreeRoot, *rootOfRoots}
+	return state, nil
+}
+
+func (r *NodeReader) GenerateProof(ctx context.Context,
+	treeRoot *merkletree.Hash,
+	key *merkletree.Hash) (*merkletree.Proof, error) {
+
+	return merkletree_proof.GenerateProof(ctx, r, treeRoot, key)
+}
+
+// GetNode returns a state node registered with AddState or a middle or leaf
+// node from the storage.
+func (r *NodeReader) GetNode(ctx context.Context,
+	hash *merkletree.Hash) (merkletree_proof.Node, error) {
+
+	if hash == nil {
+		return merkletree_proof.Node{}, errors.New("hash is nil")
+	}
+
+	r.mu.RLock()
+	roots, ok := r.states[*hash]
+	r.mu.RUnlock()
+	if ok {
+		h := *hash
+		return merkletree_proof.Node{
+			Hash:     &h,
+			Children: []*merkletree.Hash{&roots[0], &roots[1], &roots[2]},
+		}, nil
+	}
+
+	n, err := r.storage.Get(ctx, hash[:])
+	if errors.Is(err, merkletree.ErrNotFound) {
+		return merkletree_proof.Node{}, abicsr.ErrNodeNotFound
+	} else if err != nil {
+		return merkletree_proof.Node{}, err
+	}
+
+	if n.Type == merkletree.NodeTypeEmpty {
+		return merkletree_proof.Node{}, abicsr.ErrNodeNotFound
+	}
+
+	return merkletree_proof.NewNodeFromMerkleTreeNode(n)
+}
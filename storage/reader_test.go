@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+	"github.com/iden3/go-merkletree-sql/v2/db/memory"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+	"github.com/iden3/merkletree-proof/internal/testtree"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeReader_GenerateProof(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewMemoryStorage()
+	mt := testtree.BuildIn(t, s, 1, 5, 7, 100, 12345)
+	r := NewNodeReader(s)
+
+	for _, k := range []int64{1, 5, 7, 100, 12345, 3, 99999} {
+		key, err := merkletree.NewHashFromBigInt(big.NewInt(k))
+		require.NoError(t, err)
+
+		wantProof, _, err := mt.GenerateProof(ctx, big.NewInt(k), nil)
+		require.NoError(t, err)
+
+		proof, err := r.GenerateProof(ctx, mt.Root(), key)
+		require.NoError(t, err)
+		require.Equal(t, wantProof, proof)
+	}
+}
+
+func TestNodeReader_GetNode(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewMemoryStorage()
+	mt := testtree.BuildIn(t, s, 1, 2)
+	r := NewNodeReader(s)
+
+	root, err := r.GetNode(ctx, mt.Root())
+	require.NoError(t, err)
+	require.Equal(t, merkletree_proof.NodeTypeMiddle, root.Type())
+	require.NoError(t, root.Validate())
+
+	leaf, err := r.GetNode(ctx, root.Children[0])
+	require.NoError(t, err)
+	require.Equal(t, merkletree_proof.NodeTypeLeaf, leaf.Type())
+	require.NoError(t, leaf.Validate())
+
+	_, err = r.GetNode(ctx, &merkletree.HashZero)
+	require.ErrorIs(t, err, abicsr.ErrNodeNotFound)
+
+	state, err := r.AddState(mt.Root(), &merkletree.HashZero,
+		&merkletree.HashZero)
+	require.NoError(t, err)
+	stateNode, err := r.GetNode(ctx, state)
+	require.NoError(t, err)
+	require.Equal(t, merkletree_proof.NodeTypeState, stateNode.Type())
+	require.NoError(t, stateNode.Validate())
+	require.Equal(t, mt.Root(), stateNode.Children[0])
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+)
+
+// ErrReadOnly is returned by RemoteStorage on any attempt to modify the tree.
+var ErrReadOnly = errors.New("storage is read-only")
+
+var _ merkletree.Storage = (*RemoteStorage)(nil)
+
+// RemoteStorage is a read-only merkletree.Storage that fetches nodes on
+// demand from a NodeReader, usually a reverse hash service. It allows to run
+// go-merkletree-sql operations like GenerateProof, DumpLeafs or Walk against
+// a tree stored in the RHS without restoring it first.
+//
+// Every fetched node is checked against its hash before it is cached.
+type RemoteStorage struct {
+	reader merkletree_proof.NodeReader
+	root   merkletree.Hash
+
+	mu        sync.Mutex
+	cacheSize int
+	cache     map[merkletree.Hash]*list.Element
+	lru       *list.List
+}
+
+type cacheEntry struct {
+	hash merkletree.Hash
+	node merkletree.Node
+}
+
+type RemoteStorageOption func(s *RemoteStorage) error
+
+// WithCacheSize limits the number of cached nodes. The least recently used
+// nodes are evicted first. Zero or negative size means unlimited cache.
+func WithCacheSize(size int) RemoteStorageOption {
+	return func(s *RemoteStorage) error {
+		s.cacheSize = size
+		return nil
+	}
+}
+
+func NewRemoteStorage(reader merkletree_proof.NodeReader,
+	root *merkletree.Hash, opts ...RemoteStorageOption) (*RemoteStorage, error) {
+
+	if reader == nil {
+		return nil, errors.New("node reader is nil")
+	}
+	if root == nil {
+		return nil, errors.New("tree root is nil")
+	}
+
+	s := &RemoteStorage{
+		reader: reader,
+		root:   *root,
+		cache:  make(map[merkletree.Hash]*list.Element),
+		lru:    list.New(),
+	}
+	for _, o := range opts {
+		err := o(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// NewRemoteTree returns a read-only merkle tree with the given root backed by
+// RemoteStorage.
+func NewRemoteTree(ctx context.Context, reader merkletree_proof.NodeReader,
+	root *merkletree.Hash, maxLevels int,
+	opts ...RemoteStorageOption) (*merkletree.MerkleTree, error) {
+
+	s, err := NewRemoteStorage(reader, root, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return merkletree.NewMerkleTree(ctx, s, maxLevels)
+}
+
+// Get returns a node by its key. It returns merkletree.ErrNotFound if the
+// node is not found by the reader.
+func (s *RemoteStorage) Get(ctx context.Context,
+	key []byte) (*merkletree.Node, error) {
+
+	var hash merkletree.Hash
+	if len(key) != len(hash) {
+		return nil, fmt.Errorf("invalid key length: %v", len(key))
+	}
+	copy(hash[:], key)
+
+	if n, ok := s.cached(hash); ok {
+		return n, nil
+	}
+
+	node, err := s.reader.GetNode(ctx, &hash)
+	if errors.Is(err, abicsr.ErrNodeNotFound) {
+		return nil, merkletree.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if node.Hash == nil || !node.Hash.Equals(&hash) {
+		return nil, fmt.Errorf("reader returned wrong node for %v", hash.Hex())
+	}
+	err = node.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := node.MerkleTreeNode()
+	if err != nil {
+		return nil, err
+	}
+
+	s.store(hash, n)
+	return n, nil
+}
+
+// Put always returns ErrReadOnly.
+func (s *RemoteStorage) Put(_ context.Context, _ []byte,
+	_ *merkletree.Node) error {
+
+	return ErrReadOnly
+}
+
+// GetRoot returns the root the storage was created with.
+func (s *RemoteStorage) GetRoot(_ context.Context) (*merkletree.Hash, error) {
+	root := s.root
+	return &root, nil
+}
+
+// SetRoot always returns ErrReadOnly.
+func (s *RemoteStorage) SetRoot(_ context.Context, _ *merkletree.Hash) error {
+	return ErrReadOnly
+}
+
+func (s *RemoteStorage) cached(hash merkletree.Hash) (*merkletree.Node, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.cache[hash]
+	if !ok {
+		return nil, false
+	}
+	s.lru.MoveToFront(e)
+	// return a copy as go-merkletree-sql caches the key inside the node
+	n := e.Value.(*cacheEntry).node
+	return &n, true
+}
+
+func (s *RemoteStorage) store(hash merkletree.Hash, n *merkletree.Node) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.cache[hash]; ok {
+		s.lru.MoveToFront(e)
+		return
+	}
+
+	s.cache[hash] = s.lru.PushFront(&cacheEntry{hash: hash, node: *n})
+	if s.cacheSize > 0 && s.lru.Len() > s.cacheSize {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.cache, oldest.Value.(*cacheEntry).hash)
+	}
+}
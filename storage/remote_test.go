@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/iden3/go-merkletree-sql/v2"
+	"github.com/iden3/go-merkletree-sql/v2/db/memory"
+	"github.com/iden3/merkletree-proof/internal/testrhs"
+	"github.com/iden3/merkletree-proof/internal/testtree"
+	mpmemory "github.com/iden3/merkletree-proof/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteStorage(t *testing.T) {
+	ctx := context.Background()
+	mt := testtree.BuildIn(t, memory.NewMemoryStorage(), 1, 5, 7, 100, 12345)
+	rhs, err := mpmemory.NewReverseHashCliFromTree(ctx, mt)
+	require.NoError(t, err)
+	reader := &testrhs.CountingReader{NodeReader: rhs}
+
+	remoteTree, err := NewRemoteTree(ctx, reader, mt.Root(), 40)
+	require.NoError(t, err)
+	require.Equal(t, mt.Root(), remoteTree.Root())
+
+	wantDump, err := mt.DumpLeafs(ctx, nil)
+	require.NoError(t, err)
+	dump, err := remoteTree.DumpLeafs(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, wantDump, dump)
+	calls := reader.Calls()
+
+	for _, k := range []int64{1, 7, 3} {
+		wantProof, _, err := mt.GenerateProof(ctx, big.NewInt(k), nil)
+		require.NoError(t, err)
+		proof, _, err := remoteTree.GenerateProof(ctx, big.NewInt(k), nil)
+		require.NoError(t, err)
+		require.Equal(t, wantProof, proof)
+	}
+	// all nodes were cached by DumpLeafs
+	require.Equal(t, calls, reader.Calls())
+
+	err = remoteTree.Add(ctx, big.NewInt(2), big.NewInt(20))
+	require.ErrorIs(t, err, ErrReadOnly)
+}
+
+func TestRemoteStorage_CacheSize(t *testing.T) {
+	ctx := context.Background()
+	mt := testtree.BuildIn(t, memory.NewMemoryStorage(), 1, 2)
+	rhs, err := mpmemory.NewReverseHashCliFromTree(ctx, mt)
+	require.NoError(t, err)
+	reader := &testrhs.CountingReader{NodeReader: rhs}
+
+	s, err := NewRemoteStorage(reader, mt.Root(), WithCacheSize(1))
+	require.NoError(t, err)
+
+	root, err := rhs.GetNode(ctx, mt.Root())
+	require.NoError(t, err)
+
+	for _, h := range []*merkletree.Hash{mt.Root(), mt.Root(),
+		root.Children[0], mt.Root()} {
+
+		_, err = s.Get(ctx, h[:])
+		require.NoError(t, err)
+	}
+	// the root was evicted by its child
+	require.Equal(t, 3, reader.Calls())
+
+	_, err = s.Get(ctx, merkletree.HashZero[:])
+	require.ErrorIs(t, err, merkletree.ErrNotFound)
+}
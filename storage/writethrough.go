@@ -0,0 +1,246 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/iden3/go-merkletree-sql/v2"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+)
+
+// ErrOutboxFull is returned by WriteThroughStorage.Put when the outbox limit
+// is reached and the nodes in the outbox still can't be published.
+var ErrOutboxFull = errors.New("outbox of unpublished nodes is full")
+
+var _ merkletree.Storage = (*WriteThroughStorage)(nil)
+
+// WriteThroughStorage wraps a merkletree.Storage and publishes new middle and
+// leaf nodes to a NodeWriter, usually a reverse hash service, every time the
+// merkle tree commits a change by setting a new root.
+//
+// If the underlying storage writes in a database transaction, a new root is
+// not committed until the transaction is, and nodes of a transaction that is
+// rolled back must not be published. Use WithManualCommit in that case and
+// call Commit after the transaction commits or Rollback after it is rolled
+// back.
+//
+// Nodes that failed to be published stay in an in-memory outbox and are
+// published again on the next commit or on Flush. Publication errors do not
+// fail the tree operation, because the change is already persisted in the
+// underlying storage. They are reported to the handler set by
+// WithPublishErrorHandler.
+//
+// A failed Put or SetRoot aborts the tree operation, so the nodes put since
+// the previous commit are discarded and never published.
+type WriteThroughStorage struct {
+	storage merkletree.Storage
+	writer  merkletree_proof.NodeWriter
+
+	outboxLimit    int
+	onPublishError func(err error)
+	manualCommit   bool
+
+	mu sync.Mutex
+	// nodes put by the current tree operation
+	pending []merkletree_proof.Node
+	// nodes of finished tree operations waiting for Commit
+	uncommitted []merkletree_proof.Node
+	outbox      []merkletree_proof.Node
+
+	// serializes publications, so nodes are published in commit order
+	flushMu sync.Mutex
+}
+
+type WriteThroughStorageOption func(s *WriteThroughStorage) error
+
+// WithOutboxLimit limits the number of unpublished nodes. When the limit is
+// reached, Put tries to publish the outbox and fails with ErrOutboxFull if it
+// can't, so the tree is not modified further until the RHS is reachable.
+// Zero or negative limit means no limit.
+func WithOutboxLimit(limit int) WriteThroughStorageOption {
+	return func(s *WriteThroughStorage) error {
+		s.outboxLimit = limit
+		return nil
+	}
+}
+
+// WithPublishErrorHandler sets a function that is called when nodes fail to
+// be published on commit.
+func WithPublishErrorHandler(fn func(err error)) WriteThroughStorageOption {
+	return func(s *WriteThroughStorage) error {
+		s.onPublishError = fn
+		return nil
+	}
+}
+
+// WithManualCommit makes SetRoot keep the nodes of finished tree operations
+// until Commit or Rollback is called, instead of publishing them at once.
+func WithManualCommit() WriteThroughStorageOption {
+	return func(s *WriteThroughStorage) error {
+		s.manualCommit = true
+		return nil
+	}
+}
+
+func NewWriteThroughStorage(storage merkletree.Storage,
+	writer merkletree_proof.NodeWriter,
+	opts ...WriteThroughStorageOption) (*WriteThroughStorage, error) {
+
+	if storage == nil {
+		return nil, errors.New("storage is nil")
+	}
+	if writer == nil {
+		return nil, errors.New("node writer is nil")
+	}
+
+	s := &WriteThroughStorage{storage: storage, writer: writer}
+	for _, o := range opts {
+		err := o(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *WriteThroughStorage) Get(ctx context.Context,
+	key []byte) (*merkletree.Node, error) {
+
+	return s.storage.Get(ctx, key)
+}
+
+// Put saves the node to the underlying storage and queues it for
+// publication on commit.
+func (s *WriteThroughStorage) Put(ctx context.Context, key []byte,
+	n *merkletree.Node) error {
+
+	if s.outboxLimit > 0 && s.Outbox() >= s.outboxLimit {
+		if err := s.Flush(ctx); err != nil {
+			s.discardPending()
+			return fmt.Errorf("%w: %v", ErrOutboxFull, err)
+		}
+	}
+
+	err := s.storage.Put(ctx, key, n)
+	if err != nil {
+		s.discardPending()
+		return err
+	}
+
+	if n == nil || n.Type == merkletree.NodeTypeEmpty {
+		return nil
+	}
+	node, err := merkletree_proof.NewNodeFromMerkleTreeNode(n)
+	if err != nil {
+		s.discardPending()
+		return err
+	}
+
+	s.mu.Lock()
+	s.pending = append(s.pending, node)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *WriteThroughStorage) GetRoot(
+	ctx context.Context) (*merkletree.Hash, error) {
+
+	return s.storage.GetRoot(ctx)
+}
+
+// SetRoot saves the root to the underlying storage, which finishes the tree
+// operation, and publishes all nodes put since the previous commit. With
+// WithManualCommit, the nodes are published by Commit instead.
+func (s *WriteThroughStorage) SetRoot(ctx context.Context,
+	hash *merkletree.Hash) error {
+
+	err := s.storage.SetRoot(ctx, hash)
+	if err != nil {
+		s.discardPending()
+		return err
+	}
+
+	s.mu.Lock()
+	s.uncommitted = append(s.uncommitted, s.pending...)
+	s.pending = nil
+	s.mu.Unlock()
+
+	if !s.manualCommit {
+		_ = s.commit(ctx)
+	}
+	return nil
+}
+
+// Commit publishes the nodes of all tree operations finished since the
+// previous commit. Call it after the transaction of the underlying storage
+// commits when WithManualCommit is used. Nodes that fail to be published stay
+// in the outbox, and the error is returned as well as reported to the
+// publish error handler.
+func (s *WriteThroughStorage) Commit(ctx context.Context) error {
+	return s.commit(ctx)
+}
+
+// Rollback discards the nodes of all tree operations since the previous
+// commit. Call it after the transaction of the underlying storage is rolled
+// back when WithManualCommit is used.
+func (s *WriteThroughStorage) Rollback() {
+	s.mu.Lock()
+	s.pending = nil
+	s.uncommitted = nil
+	s.mu.Unlock()
+}
+
+func (s *WriteThroughStorage) commit(ctx context.Context) error {
+	s.mu.Lock()
+	s.outbox = append(s.outbox, s.uncommitted...)
+	s.uncommitted = nil
+	s.mu.Unlock()
+
+	err := s.Flush(ctx)
+	if err != nil && s.onPublishError != nil {
+		s.onPublishError(err)
+	}
+	return err
+}
+
+// discardPending drops the nodes put by a tree operation that failed.
+func (s *WriteThroughStorage) discardPending() {
+	s.mu.Lock()
+	s.pending = nil
+	s.mu.Unlock()
+}
+
+// Flush publishes all committed nodes from the outbox. On failure the nodes
+// stay in the outbox.
+func (s *WriteThroughStorage) Flush(ctx context.Context) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	nodes := s.outbox
+	s.mu.Unlock()
+
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	err := s.writer.SaveNodes(ctx, nodes)
+	if err != nil {
+		return fmt.Errorf("failed to publish %v nodes: %w", len(nodes), err)
+	}
+
+	// nodes could be added to the outbox while publishing
+	s.mu.Lock()
+	s.outbox = append([]merkletree_proof.Node(nil), s.outbox[len(nodes):]...)
+	s.mu.Unlock()
+	return nil
+}
+
+// Outbox returns the number of committed nodes that are not published yet.
+func (s *WriteThroughStorage) Outbox() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.outbox)
+}
@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+
+	abicsr "github.com/iden3/contracts-abi/onchain-credential-status-resolver/go/abi"
+	"github.com/iden3/go-merkletree-sql/v2"
+	"github.com/iden3/go-merkletree-sql/v2/db/memory"
+	merkletree_proof "github.com/iden3/merkletree-proof"
+	"github.com/iden3/merkletree-proof/internal/testtree"
+	mpmemory "github.com/iden3/merkletree-proof/memory"
+	"github.com/stretchr/testify/require"
+)
+
+type flakyWriter struct {
+	merkletree_proof.NodeWriter
+	mu   sync.Mutex
+	fail bool
+}
+
+func (w *flakyWriter) setFail(fail bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.fail = fail
+}
+
+func (w *flakyWriter) SaveNodes(ctx context.Context,
+	nodes []merkletree_proof.Node) error {
+
+	w.mu.Lock()
+	fail := w.fail
+	w.mu.Unlock()
+	if fail {
+		return errors.New("RHS is down")
+	}
+	return w.NodeWriter.SaveNodes(ctx, nodes)
+}
+
+// failingStorage lets puts Put calls succeed and fails the following ones.
+// A negative puts never fails.
+type failingStorage struct {
+	merkletree.Storage
+	puts int
+}
+
+func (s *failingStorage) Put(ctx context.Context, key []byte,
+	n *merkletree.Node) error {
+
+	if s.puts == 0 {
+		return errors.New("storage is down")
+	}
+	s.puts--
+	return s.Storage.Put(ctx, key, n)
+}
+
+func TestWriteThroughStorage(t *testing.T) {
+	ctx := context.Background()
+	rhs := mpmemory.NewReverseHashCli()
+	writer := &flakyWriter{NodeWriter: rhs}
+
+	var publishErrs []error
+	s, err := NewWriteThroughStorage(memory.NewMemoryStorage(), writer,
+		WithPublishErrorHandler(func(err error) {
+			publishErrs = append(publishErrs, err)
+		}))
+	require.NoError(t, err)
+	mt, err := merkletree.NewMerkleTree(ctx, s, 40)
+	require.NoError(t, err)
+
+	requireInSync := func() {
+		want, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+		require.NoError(t, err)
+		for _, n := range want {
+			got, err := rhs.GetNode(ctx, n.Hash)
+			require.NoError(t, err)
+			require.Equal(t, n, got)
+		}
+	}
+
+	require.NoError(t, mt.Add(ctx, big.NewInt(1), big.NewInt(10)))
+	require.NoError(t, mt.Add(ctx, big.NewInt(5), big.NewInt(50)))
+	requireInSync()
+	require.Equal(t, 0, s.Outbox())
+
+	writer.setFail(true)
+	require.NoError(t, mt.Add(ctx, big.NewInt(7), big.NewInt(70)))
+	require.Len(t, publishErrs, 1)
+	require.NotZero(t, s.Outbox())
+
+	// nodes are published on the next commit
+	writer.setFail(false)
+	_, err = mt.Update(ctx, big.NewInt(7), big.NewInt(71))
+	require.NoError(t, err)
+	require.Len(t, publishErrs, 1)
+	require.Equal(t, 0, s.Outbox())
+	requireInSync()
+}
+
+func TestWriteThroughStorage_OutboxLimit(t *testing.T) {
+	ctx := context.Background()
+	writer := &flakyWriter{NodeWriter: mpmemory.NewReverseHashCli(),
+		fail: true}
+	s, err := NewWriteThroughStorage(memory.NewMemoryStorage(), writer,
+		WithOutboxLimit(1))
+	require.NoError(t, err)
+	mt, err := merkletree.NewMerkleTree(ctx, s, 40)
+	require.NoError(t, err)
+
+	require.NoError(t, mt.Add(ctx, big.NewInt(1), big.NewInt(10)))
+	require.Equal(t, 1, s.Outbox())
+
+	err = mt.Add(ctx, big.NewInt(2), big.NewInt(20))
+	require.ErrorIs(t, err, ErrOutboxFull)
+
+	writer.setFail(false)
+	require.NoError(t, s.Flush(ctx))
+	require.Equal(t, 0, s.Outbox())
+	require.NoError(t, mt.Add(ctx, big.NewInt(2), big.NewInt(20)))
+}
+
+func TestWriteThroughStorage_FailedWrite(t *testing.T) {
+	ctx := context.Background()
+	rhs := mpmemory.NewReverseHashCli()
+	storage := &failingStorage{Storage: memory.NewMemoryStorage(), puts: -1}
+	s, err := NewWriteThroughStorage(storage, rhs)
+	require.NoError(t, err)
+	mt, err := merkletree.NewMerkleTree(ctx, s, 40)
+	require.NoError(t, err)
+	require.NoError(t, mt.Add(ctx, big.NewInt(1), big.NewInt(10)))
+
+	// the leaf is put, then the operation fails
+	storage.puts = 1
+	require.Error(t, mt.Add(ctx, big.NewInt(5), big.NewInt(50)))
+
+	// the leaf of the failed operation is not published with the next
+	// commit
+	storage.puts = -1
+	require.NoError(t, mt.Add(ctx, big.NewInt(7), big.NewInt(70)))
+	nodes, err := merkletree_proof.NodesFromTree(ctx, mt, nil)
+	require.NoError(t, err)
+	require.Equal(t, len(nodes), rhs.Len())
+}
+
+func TestWriteThroughStorage_ManualCommit(t *testing.T) {
+	ctx := context.Background()
+	rhs := mpmemory.NewReverseHashCli()
+	s, err := NewWriteThroughStorage(memory.NewMemoryStorage(), rhs,
+		WithManualCommit())
+	require.NoError(t, err)
+	mt, err := merkletree.NewMerkleTree(ctx, s, 40)
+	require.NoError(t, err)
+
+	// nodes of a rolled back transaction are not published
+	require.NoError(t, mt.Add(ctx, big.NewInt(1), big.NewInt(10)))
+	require.Equal(t, 0, rhs.Len())
+	s.Rollback()
+	require.NoError(t, s.Commit(ctx))
+	require.Equal(t, 0, rhs.Len())
+
+	require.NoError(t, mt.Add(ctx, big.NewInt(5), big.NewInt(50)))
+	require.NoError(t, mt.Add(ctx, big.NewInt(7), big.NewInt(70)))
+	require.Equal(t, 0, rhs.Len())
+	require.NoError(t, s.Commit(ctx))
+	require.Equal(t, 0, s.Outbox())
+
+	_, err = rhs.GetNode(ctx, mt.Root())
+	require.NoError(t, err)
+	leaf, err := merkletree.NewNodeLeaf(
+		testtree.Hash(t, 1), testtree.Hash(t, 10)).Key()
+	require.NoError(t, err)
+	_, err = rhs.GetNode(ctx, leaf)
+	require.ErrorIs(t, err, abicsr.ErrNodeNotFound)
+}
@@ -203,7 +203,7 @@ func runTestCases(t *testing.T, rhsCli merkletree_proof.ReverseHashCli) {
 	}
 }
 
-func getRevTreeRoot(rhsCli merkletree_proof.ReverseHashCli,
+func getRevTreeRoot(rhsCli merkletree_proof.NodeReader,
 	state *merkletree.Hash) (*merkletree.Hash, error) {
 	stateNode, err := rhsCli.GetNode(context.Background(), state)
 	if err != nil {
@@ -219,7 +219,7 @@ func getRevTreeRoot(rhsCli merkletree_proof.ReverseHashCli,
 	return stateNode.Children[1], nil
 }
 
-func saveIdenStateToRHS(t testing.TB, rhsCli merkletree_proof.ReverseHashCli,
+func saveIdenStateToRHS(t testing.TB, rhsCli merkletree_proof.NodeWriter,
 	merkleTree *merkletree.MerkleTree) *merkletree.Hash {
 
 	revTreeRoot := merkleTree.Root()
@@ -258,7 +258,7 @@ func buildTree(t testing.TB, revNonces []uint64) *merkletree.MerkleTree {
 	return mt
 }
 
-func saveTreeToRHS(t testing.TB, rhsCli merkletree_proof.ReverseHashCli,
+func saveTreeToRHS(t testing.TB, rhsCli merkletree_proof.NodeWriter,
 	merkleTree *merkletree.MerkleTree) {
 	ctx := context.Background()
 	var req []merkletree_proof.Node
@@ -0,0 +1,89 @@
+package merkletree_proof
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/iden3/go-merkletree-sql/v2"
+)
+
+// NewNodeFromMerkleTreeNode converts a middle or leaf node of
+// go-merkletree-sql into a Node. Empty nodes are never stored in the RHS, so
+// they are rejected.
+func NewNodeFromMerkleTreeNode(n *merkletree.Node) (Node, error) {
+	if n == nil {
+		return Node{}, errors.New("node is nil")
+	}
+
+	key, err := n.Key()
+	if err != nil {
+		return Node{}, err
+	}
+
+	// copy hashes, so the returned node does not share memory with the
+	// merkle tree storage
+	switch n.Type {
+	case merkletree.NodeTypeMiddle:
+		return Node{
+			Hash:     copyHash(key),
+			Children: []*merkletree.Hash{copyHash(n.ChildL), copyHash(n.ChildR)},
+		}, nil
+	case merkletree.NodeTypeLeaf:
+		return Node{
+			Hash: copyHash(key),
+			Children: []*merkletree.Hash{copyHash(n.Entry[0]),
+				copyHash(n.Entry[1]), copyHash(hashOne)},
+		}, nil
+	default:
+		return Node{}, fmt.Errorf("unsupported node type: %v", n.Type)
+	}
+}
+
+// MerkleTreeNode converts a middle or leaf node into a go-merkletree-sql
+// node. State nodes have no counterpart in go-merkletree-sql and are
+// rejected.
+func (n Node) MerkleTreeNode() (*merkletree.Node, error) {
+	switch nt := n.Type(); nt {
+	case NodeTypeMiddle:
+		return merkletree.NewNodeMiddle(
+			copyHash(n.Children[0]), copyHash(n.Children[1])), nil
+	case NodeTypeLeaf:
+		return merkletree.NewNodeLeaf(
+			copyHash(n.Children[0]), copyHash(n.Children[1])), nil
+	default:
+		return nil, fmt.Errorf("unsupported node type (%v): %v", nt,
+			n.Hash.Hex())
+	}
+}
+
+// NodesFromTree returns all middle and leaf nodes of the tree reachable from
+// the root. If root is nil, the current root of the tree is used.
+func NodesFromTree(ctx context.Context, mt *merkletree.MerkleTree,
+	root *merkletree.Hash) ([]Node, error) {
+
+	var nodes []Node
+	var walkErr error
+	err := mt.Walk(ctx, root, func(n *merkletree.Node) {
+		if walkErr != nil || n.Type == merkletree.NodeTypeEmpty {
+			return
+		}
+		var node Node
+		node, walkErr = NewNodeFromMerkleTreeNode(n)
+		if walkErr == nil {
+			nodes = append(nodes, node)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if walkErr != nil {
+		return nil, walkErr
+	}
+	return nodes, nil
+}
+
+func copyHash(h *merkletree.Hash) *merkletree.Hash {
+	c := *h
+	return &c
+}